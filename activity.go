@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// titles shown in the owner activity feed, types not listed here are not logged
+var activityTitles = map[string]string{
+	"booking.created":          "Booking baru",
+	"booking.accepted":         "Booking diterima",
+	"booking.rejected":         "Booking ditolak",
+	"booking.cancelled":        "Booking dibatalkan",
+	"booking.verified":         "Booking diverifikasi",
+	"booking.confirmed":        "Booking terkonfirmasi",
+	"contract.signed":          "Kontrak ditandatangani",
+	"payment.created":          "Pembayaran baru",
+	"payment.confirmed":        "Pembayaran dikonfirmasi",
+	"payment.refunded":         "Pembayaran dikembalikan",
+	"discount.created":         "Diskon dibuat",
+	"review.created":           "Ulasan baru",
+	"ruko.created":             "Ruko ditambahkan",
+	"ruko.updated":             "Ruko diperbarui",
+	"ruko.images":              "Foto ruko diperbarui",
+	"ruko.rented_offline":      "Ruko disewa offline",
+	"ruko.archived":            "Ruko diarsipkan",
+	"ruko.restored":            "Ruko dipulihkan",
+	"rental.offline_corrected": "Sewa offline dikoreksi",
+	"rental.offline_ended":     "Sewa offline diakhiri",
+}
+
+// activityRefs keeps the ids and simple values of an event, full documents are not copied
+func activityRefs(data map[string]interface{}) map[string]string {
+	refs := map[string]string{}
+	for k, v := range data {
+		switch x := v.(type) {
+		case string:
+			refs[k] = x
+		case bool:
+			refs[k] = strconv.FormatBool(x)
+		case int:
+			refs[k] = strconv.Itoa(x)
+		case float64:
+			refs[k] = strconv.FormatFloat(x, 'f', -1, 64)
+		case primitive.ObjectID:
+			refs[k] = x.Hex()
+		case Booking:
+			refs["booking_id"] = x.ID.Hex()
+		case Payment:
+			refs["payment_id"] = x.ID.Hex()
+			refs["booking_id"] = x.BookingID.Hex()
+			refs["amount"] = strconv.FormatFloat(x.Amount, 'f', -1, 64)
+		}
+	}
+	return refs
+}
+
+// recordActivity adds an entry to the owner's activity feed
+func (h *Handlers) recordActivity(ctx context.Context, r Ruko, etype string, data map[string]interface{}, at time.Time) {
+	title, ok := activityTitles[etype]
+	if !ok {
+		return
+	}
+	category, _, _ := strings.Cut(etype, ".")
+	a := Activity{
+		OwnerID:   r.OwnerID,
+		RukoID:    r.ID,
+		RukoName:  r.Name,
+		Type:      etype,
+		Category:  category,
+		Title:     title + " - " + r.Name,
+		Data:      activityRefs(data),
+		CreatedAt: at,
+	}
+	if err := h.repo.Activities.Create(ctx, &a); err != nil {
+		log.Println("activity log error:", err)
+	}
+}
+
+// backfillActivities seeds the feed with past bookings the first time it runs
+func backfillActivities(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	col := db.Collection("activities")
+	if n, err := col.EstimatedDocumentCount(ctx); err != nil || n > 0 {
+		return
+	}
+	cur, err := db.Collection("bookings").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{"from": "ruko", "localField": "ruko_id", "foreignField": "_id", "as": "ruko"}}},
+		{{Key: "$unwind", Value: "$ruko"}},
+	})
+	if err != nil {
+		log.Println("activity backfill error:", err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	var docs []interface{}
+	for cur.Next(ctx) {
+		var b struct {
+			Booking `bson:",inline"`
+			Ruko    Ruko `bson:"ruko"`
+		}
+		if err := cur.Decode(&b); err != nil {
+			continue
+		}
+		docs = append(docs, Activity{
+			OwnerID:   b.Ruko.OwnerID,
+			RukoID:    b.Ruko.ID,
+			RukoName:  b.Ruko.Name,
+			Type:      "booking.created",
+			Category:  "booking",
+			Title:     activityTitles["booking.created"] + " - " + b.Ruko.Name,
+			Data:      map[string]string{"booking_id": b.ID.Hex()},
+			CreatedAt: b.CreatedAt,
+		})
+	}
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := col.InsertMany(ctx, docs); err != nil {
+		log.Println("activity backfill error:", err)
+		return
+	}
+	log.Printf("activity backfill: added %d bookings\n", len(docs))
+}
+
+// GetRecentActivities: GET /api/:ownerId/activities/recent?type=&ruko_id=&page=&limit=
+// type is a category (booking, payment, discount, review, ruko, rental, contract) or a full type like booking.created
+func (h *Handlers) GetRecentActivities(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+	filter := bson.M{"owner_id": ownerOID}
+	if t := c.Query("type"); t != "" {
+		if strings.Contains(t, ".") {
+			filter["type"] = t
+		} else {
+			filter["category"] = t
+		}
+	}
+	if v := c.Query("ruko_id"); v != "" {
+		rukoOID, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ruko_id"})
+			return
+		}
+		filter["ruko_id"] = rukoOID
+	}
+
+	ctx := context.Background()
+	total, err := h.repo.Activities.Count(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed count activities"})
+		return
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	out, err := h.repo.Activities.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list activities"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": page, "limit": limit, "total": total})
+}
+
+// helper: short description of changed ruko fields for the feed
+func changedFields(set bson.M) string {
+	var keys []string
+	for k := range set {
+		if k != "updated_at" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
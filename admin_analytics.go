@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// adminMetric computes one report of the admin dashboard for the date range
+type adminMetric func(ctx context.Context, db *mongo.Database, q incomeQuery, limit int) (interface{}, error)
+
+var adminMetrics = map[string]adminMetric{
+	"gmv":             gmvByPeriod,
+	"bookings":        bookingsByStatus,
+	"users":           newUsersByRole,
+	"top-cities":      topCities,
+	"top-owners":      topOwners,
+	"payment-methods": paymentMethodMix,
+	"rates":           platformRates,
+}
+
+// aggregate runs the pipeline and decodes every result into out
+func aggregate(ctx context.Context, col *mongo.Collection, pipeline mongo.Pipeline, out interface{}) error {
+	cur, err := col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, out)
+}
+
+// paymentsInRange matches received payments by payment date
+func paymentsInRange(q incomeQuery) bson.D {
+	return bson.D{{Key: "$match", Value: bson.M{
+		"status":       bson.M{"$in": incomePaymentStatuses},
+		"payment_date": bson.M{"$gte": q.From, "$lt": q.end()},
+	}}}
+}
+
+// createdInRange matches documents created in the range
+func createdInRange(q incomeQuery) bson.D {
+	return bson.D{{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": q.From, "$lt": q.end()}}}}
+}
+
+// gmvByPeriod: gross payments (net of refunds) per period
+func gmvByPeriod(ctx context.Context, db *mongo.Database, q incomeQuery, _ int) (interface{}, error) {
+	var rows []struct {
+		Period string  `bson:"_id"`
+		Amount float64 `bson:"amount"`
+		Count  int     `bson:"count"`
+	}
+	err := aggregate(ctx, db.Collection("payments"), mongo.Pipeline{
+		paymentsInRange(q),
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"$dateToString": bson.M{"format": mongoDateFormat(q.Granularity), "date": "$payment_date", "timezone": q.Loc.String()}},
+			"amount": bson.M{"$sum": netPaymentAmount},
+			"count":  bson.M{"$sum": 1},
+		}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	byPeriod := map[string]float64{}
+	counts := map[string]int{}
+	for _, row := range rows {
+		byPeriod[row.Period] = row.Amount
+		counts[row.Period] = row.Count
+	}
+	type point struct {
+		Period   string  `json:"period"`
+		Amount   float64 `json:"amount"`
+		Payments int     `json:"payments"`
+	}
+	series := []point{}
+	var total float64
+	for _, b := range q.buckets() {
+		series = append(series, point{Period: b, Amount: roundRupiah(byPeriod[b]), Payments: counts[b]})
+		total += byPeriod[b]
+	}
+	return gin.H{"total": roundRupiah(total), "series": series}, nil
+}
+
+// countByField groups documents created in the range by one field
+func countByField(ctx context.Context, col *mongo.Collection, q incomeQuery, field string) (map[string]int, int, error) {
+	var rows []struct {
+		Key   string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err := aggregate(ctx, col, mongo.Pipeline{
+		createdInRange(q),
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}},
+	}, &rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := map[string]int{}
+	total := 0
+	for _, row := range rows {
+		out[row.Key] = row.Count
+		total += row.Count
+	}
+	return out, total, nil
+}
+
+// bookingsByStatus: bookings created in the range by current status
+func bookingsByStatus(ctx context.Context, db *mongo.Database, q incomeQuery, _ int) (interface{}, error) {
+	byStatus, total, err := countByField(ctx, db.Collection("bookings"), q, "booking_status")
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"total": total, "by_status": byStatus}, nil
+}
+
+// newUsersByRole: registrations in the range by role
+func newUsersByRole(ctx context.Context, db *mongo.Database, q incomeQuery, _ int) (interface{}, error) {
+	byRole, total, err := countByField(ctx, db.Collection("users"), q, "role")
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"total": total, "by_role": byRole}, nil
+}
+
+// topCities: cities with the most bookings created in the range
+func topCities(ctx context.Context, db *mongo.Database, q incomeQuery, limit int) (interface{}, error) {
+	type city struct {
+		City      string `bson:"_id" json:"city"`
+		Bookings  int    `bson:"bookings" json:"bookings"`
+		Confirmed int    `bson:"confirmed" json:"confirmed"`
+	}
+	out := []city{}
+	err := aggregate(ctx, db.Collection("bookings"), mongo.Pipeline{
+		createdInRange(q),
+		{{Key: "$lookup", Value: bson.M{"from": "ruko", "localField": "ruko_id", "foreignField": "_id", "as": "ruko"}}},
+		{{Key: "$unwind", Value: "$ruko"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$ruko.city",
+			"bookings":  bson.M{"$sum": 1},
+			"confirmed": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$booking_status", "confirmed"}}, 1, 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "bookings", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}, &out)
+	return out, err
+}
+
+// topOwners: owners with the highest payments received in the range
+func topOwners(ctx context.Context, db *mongo.Database, q incomeQuery, limit int) (interface{}, error) {
+	var rows []struct {
+		OwnerID  primitive.ObjectID `bson:"_id"`
+		Amount   float64            `bson:"amount"`
+		Payments int                `bson:"payments"`
+		Owner    []User             `bson:"owner"`
+	}
+	err := aggregate(ctx, db.Collection("payments"), mongo.Pipeline{
+		paymentsInRange(q),
+		{{Key: "$lookup", Value: bson.M{"from": "bookings", "localField": "booking_id", "foreignField": "_id", "as": "booking"}}},
+		{{Key: "$unwind", Value: "$booking"}},
+		{{Key: "$lookup", Value: bson.M{"from": "ruko", "localField": "booking.ruko_id", "foreignField": "_id", "as": "ruko"}}},
+		{{Key: "$unwind", Value: "$ruko"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$ruko.owner_id",
+			"amount":   bson.M{"$sum": netPaymentAmount},
+			"payments": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "amount", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{"from": "users", "localField": "_id", "foreignField": "_id", "as": "owner"}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	out := []gin.H{}
+	for _, row := range rows {
+		name := ""
+		if len(row.Owner) > 0 {
+			name = row.Owner[0].Name
+		}
+		out = append(out, gin.H{"owner_id": row.OwnerID, "name": name, "amount": roundRupiah(row.Amount), "payments": row.Payments})
+	}
+	return out, nil
+}
+
+// paymentMethodMix: share of payment methods by count and amount
+func paymentMethodMix(ctx context.Context, db *mongo.Database, q incomeQuery, _ int) (interface{}, error) {
+	var rows []struct {
+		Method string  `bson:"_id"`
+		Count  int     `bson:"count"`
+		Amount float64 `bson:"amount"`
+	}
+	err := aggregate(ctx, db.Collection("payments"), mongo.Pipeline{
+		paymentsInRange(q),
+		{{Key: "$group", Value: bson.M{"_id": "$payment_method", "count": bson.M{"$sum": 1}, "amount": bson.M{"$sum": netPaymentAmount}}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	var amount float64
+	for _, row := range rows {
+		count += row.Count
+		amount += row.Amount
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Amount > rows[j].Amount })
+	out := []gin.H{}
+	for _, row := range rows {
+		out = append(out, gin.H{
+			"method":       row.Method,
+			"count":        row.Count,
+			"amount":       roundRupiah(row.Amount),
+			"count_share":  ratio(float64(row.Count), float64(count)),
+			"amount_share": ratio(row.Amount, amount),
+		})
+	}
+	return out, nil
+}
+
+// platformRates: cancellation (tenant withdrew, PUT /api/bookings/:id/cancel) and rejection
+// of bookings, and disputes (payments that had to be refunded, which is how disputes are settled) in the range
+func platformRates(ctx context.Context, db *mongo.Database, q incomeQuery, _ int) (interface{}, error) {
+	byStatus, created, err := countByField(ctx, db.Collection("bookings"), q, "booking_status")
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Payments int `bson:"payments"`
+		Refunded int `bson:"refunded"`
+	}
+	err = aggregate(ctx, db.Collection("payments"), mongo.Pipeline{
+		paymentsInRange(q),
+		{{Key: "$group", Value: bson.M{
+			"_id":      nil,
+			"payments": bson.M{"$sum": 1},
+			"refunded": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$refunded_amount", 0}}, 0}}, 1, 0}}},
+		}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+	var payments, refunded int
+	if len(rows) > 0 {
+		payments, refunded = rows[0].Payments, rows[0].Refunded
+	}
+	return gin.H{
+		"bookings":          created,
+		"cancelled":         byStatus["cancelled"],
+		"rejected":          byStatus["rejected"],
+		"cancellation_rate": ratio(float64(byStatus["cancelled"]), float64(created)),
+		"rejection_rate":    ratio(float64(byStatus["rejected"]), float64(created)),
+		"payments":          payments,
+		"refunded_payments": refunded,
+		"dispute_rate":      ratio(float64(refunded), float64(payments)),
+	}, nil
+}
+
+// GetAdminAnalytics: GET /api/analytics/:metric?from=&to=&granularity=&tz=&limit=
+// metric is one of gmv, bookings, users, top-cities, top-owners, payment-methods, rates or overview (all of them).
+// Results are cached for ANALYTICS_CACHE_SECONDS.
+func (h *Handlers) GetAdminAnalytics(c *gin.Context) {
+	name := c.Param("metric")
+	if _, ok := adminMetrics[name]; !ok && name != "overview" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown metric"})
+		return
+	}
+	q, err := parseIncomeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	key := fmt.Sprintf("admin:%s:%s:%s:%s:%s:%d", name, q.From.Format(incomeDateLayout), q.To.Format(incomeDateLayout), q.Granularity, q.Loc, limit)
+	if v, ok := h.cache.Get(key); ok {
+		c.Header("X-Cache", "HIT")
+		c.JSON(http.StatusOK, v)
+		return
+	}
+
+	ctx := context.Background()
+	var result gin.H
+	if name == "overview" {
+		result = gin.H{}
+		for n, metric := range adminMetrics {
+			v, err := metric(ctx, h.db, q, limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed compute " + n})
+				return
+			}
+			result[n] = v
+		}
+	} else {
+		v, err := adminMetrics[name](ctx, h.db, q, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed compute " + name})
+			return
+		}
+		result = gin.H{name: v}
+	}
+	result["from"] = q.From.Format(incomeDateLayout)
+	result["to"] = q.To.Format(incomeDateLayout)
+	result["granularity"] = q.Granularity
+
+	h.cache.Set(key, result)
+	c.Header("X-Cache", "MISS")
+	c.JSON(http.StatusOK, result)
+}
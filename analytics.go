@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// helper: remember when a booking was first accepted, used for time-to-accept
+func (h *Handlers) markBookingAccepted(ctx context.Context, bookingID primitive.ObjectID) error {
+	b, err := h.repo.Bookings.Get(ctx, bookingID)
+	if err != nil || b.AcceptedAt != nil {
+		return err
+	}
+	return h.repo.Bookings.Update(ctx, bookingID, bson.M{"accepted_at": time.Now()})
+}
+
+// occupancy of one bucket of the series
+type occupancyPoint struct {
+	Period       string  `json:"period"`
+	Days         int     `json:"days"`
+	OccupiedDays int     `json:"occupied_days"`
+	Rate         float64 `json:"rate"`
+}
+
+// bookingFunnel counts bookings created in the range by their current status
+type bookingFunnel struct {
+	Created           int     `json:"created"`
+	Waiting           int     `json:"waiting"`
+	AwaitingSignature int     `json:"awaiting_signature"`
+	Confirmed         int     `json:"confirmed"`
+	Rejected          int     `json:"rejected"`
+	Cancelled         int     `json:"cancelled"`
+	ConversionRate    float64 `json:"conversion_rate"` // confirmed / created
+}
+
+func (f *bookingFunnel) add(status string, n int) {
+	f.Created += n
+	switch status {
+	case "waiting":
+		f.Waiting += n
+	case "awaiting_signature":
+		f.AwaitingSignature += n
+	case "confirmed":
+		f.Confirmed += n
+	case "rejected":
+		f.Rejected += n
+	case "cancelled":
+		f.Cancelled += n
+	}
+}
+
+func (f *bookingFunnel) finish() {
+	f.ConversionRate = ratio(float64(f.Confirmed), float64(f.Created))
+}
+
+// RukoMetrics are the performance numbers of one ruko in the selected range
+type RukoMetrics struct {
+	RukoID            primitive.ObjectID `json:"ruko_id"`
+	Name              string             `json:"name"`
+	Days              int                `json:"days"`
+	OccupiedDays      int                `json:"occupied_days"`
+	VacancyDays       int                `json:"vacancy_days"`
+	OccupancyRate     float64            `json:"occupancy_rate"`
+	Leases            int                `json:"leases"`
+	AvgLeaseDays      float64            `json:"avg_lease_days"`
+	Bookings          bookingFunnel      `json:"bookings"`
+	AvgHoursToAccept  *float64           `json:"avg_hours_to_accept"`
+	CashRevenue       float64            `json:"cash_revenue"`
+	RecognizedRevenue float64            `json:"recognized_revenue"`
+	Occupancy         []occupancyPoint   `json:"occupancy"`
+	acceptHoursTotal  float64
+	acceptedBookings  int
+	occupiedByBucket  map[string]int
+	leaseDaysTotal    float64
+	daysByBucket      map[string]int
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return math.Round(a/b*10000) / 10000
+}
+
+// leaseInterval is a rental period from rental_history
+type leaseInterval struct {
+	RukoID    primitive.ObjectID `bson:"ruko_id"`
+	StartDate time.Time          `bson:"start_date"`
+	EndDate   time.Time          `bson:"end_date"`
+	Days      float64            `bson:"days"`
+}
+
+// rentalIntervals loads the rentals overlapping the range with their length in days
+func rentalIntervals(ctx context.Context, db *mongo.Database, rukoIDs []primitive.ObjectID, q incomeQuery) ([]leaseInterval, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"ruko_id":    bson.M{"$in": rukoIDs},
+			"start_date": bson.M{"$lt": q.end()},
+			"end_date":   bson.M{"$gt": q.From},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"ruko_id":    1,
+			"start_date": 1,
+			"end_date":   1,
+			"days":       bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$end_date", "$start_date"}}, 86400000}},
+		}}},
+	}
+	cur, err := db.Collection("rental_history").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var out []leaseInterval
+	err = cur.All(ctx, &out)
+	return out, err
+}
+
+type statusCount struct {
+	Ruko   primitive.ObjectID
+	Status string
+	Count  int
+}
+
+// bookingStatusCounts groups bookings created in the range by ruko and status
+func bookingStatusCounts(ctx context.Context, db *mongo.Database, rukoIDs []primitive.ObjectID, q incomeQuery) ([]statusCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"ruko_id":    bson.M{"$in": rukoIDs},
+			"created_at": bson.M{"$gte": q.From, "$lt": q.end()},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"ruko": "$ruko_id", "status": "$booking_status"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cur, err := db.Collection("bookings").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Ruko   primitive.ObjectID `bson:"ruko"`
+			Status string             `bson:"status"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := make([]statusCount, len(rows))
+	for i, row := range rows {
+		out[i] = statusCount{Ruko: row.ID.Ruko, Status: row.ID.Status, Count: row.Count}
+	}
+	return out, nil
+}
+
+// acceptDurations sums the hours between creation and acceptance of bookings accepted in the range
+func acceptDurations(ctx context.Context, db *mongo.Database, rukoIDs []primitive.ObjectID, q incomeQuery) (map[primitive.ObjectID][2]float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"ruko_id":     bson.M{"$in": rukoIDs},
+			"accepted_at": bson.M{"$gte": q.From, "$lt": q.end()},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$ruko_id",
+			"hours": bson.M{"$sum": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$accepted_at", "$created_at"}}, 3600000}}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cur, err := db.Collection("bookings").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Hours float64            `bson:"hours"`
+		Count int                `bson:"count"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := map[primitive.ObjectID][2]float64{}
+	for _, row := range rows {
+		out[row.ID] = [2]float64{row.Hours, float64(row.Count)}
+	}
+	return out, nil
+}
+
+// GetOwnerAnalytics: GET /api/:ownerId/analytics?from=&to=&granularity=&tz=&ruko_id=
+// occupancy, vacancy, lease length, booking conversion, time-to-accept and revenue per ruko
+func (h *Handlers) GetOwnerAnalytics(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	q, err := parseIncomeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rukos, rukoIDs, ok := h.ownerRukosFilter(c, ownerOID)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	metrics := map[primitive.ObjectID]*RukoMetrics{}
+	for _, r := range rukos {
+		metrics[r.ID] = &RukoMetrics{RukoID: r.ID, Name: r.Name, occupiedByBucket: map[string]int{}, daysByBucket: map[string]int{}}
+	}
+
+	leases, err := rentalIntervals(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate rentals"})
+		return
+	}
+	statuses, err := bookingStatusCounts(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate bookings"})
+		return
+	}
+	accepts, err := acceptDurations(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate bookings"})
+		return
+	}
+	cash, err := cashIncome(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate payments"})
+		return
+	}
+	recognized, err := recognizedIncome(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate payments"})
+		return
+	}
+
+	// occupied days: a day counts once even when rentals overlap
+	occupied := map[primitive.ObjectID]map[time.Time]bool{}
+	for _, l := range leases {
+		m := metrics[l.RukoID]
+		m.Leases++
+		m.leaseDaysTotal += l.Days
+		if occupied[l.RukoID] == nil {
+			occupied[l.RukoID] = map[time.Time]bool{}
+		}
+		for d := startOfDay(l.StartDate, q.Loc); d.Before(l.EndDate) && d.Before(q.end()); d = d.AddDate(0, 0, 1) {
+			if !d.Before(q.From) {
+				occupied[l.RukoID][d] = true
+			}
+		}
+	}
+	for _, row := range statuses {
+		metrics[row.Ruko].Bookings.add(row.Status, row.Count)
+	}
+
+	summary := RukoMetrics{occupiedByBucket: map[string]int{}, daysByBucket: map[string]int{}}
+	buckets := q.buckets()
+	out := []RukoMetrics{}
+	for _, r := range rukos {
+		m := metrics[r.ID]
+		// a ruko can't be vacant before it was listed
+		listed := q.From
+		if created := startOfDay(r.CreatedAt, q.Loc); !r.CreatedAt.IsZero() && created.After(listed) {
+			listed = created
+		}
+		for d := listed; d.Before(q.end()); d = d.AddDate(0, 0, 1) {
+			k := bucketKey(d, q.Granularity)
+			m.Days++
+			m.daysByBucket[k]++
+			if occupied[r.ID][d] {
+				m.OccupiedDays++
+				m.occupiedByBucket[k]++
+			}
+		}
+		m.VacancyDays = m.Days - m.OccupiedDays
+		m.OccupancyRate = ratio(float64(m.OccupiedDays), float64(m.Days))
+		if m.Leases > 0 {
+			m.AvgLeaseDays = math.Round(m.leaseDaysTotal/float64(m.Leases)*10) / 10
+		}
+		if a, ok := accepts[r.ID]; ok && a[1] > 0 {
+			avg := math.Round(a[0]/a[1]*10) / 10
+			m.AvgHoursToAccept = &avg
+			m.acceptHoursTotal, m.acceptedBookings = a[0], int(a[1])
+		}
+		for _, v := range cash[r.ID] {
+			m.CashRevenue += v
+		}
+		for _, v := range recognized[r.ID] {
+			m.RecognizedRevenue += v
+		}
+		m.CashRevenue, m.RecognizedRevenue = roundRupiah(m.CashRevenue), roundRupiah(m.RecognizedRevenue)
+		m.Bookings.finish()
+
+		m.Occupancy = make([]occupancyPoint, len(buckets))
+		for i, k := range buckets {
+			m.Occupancy[i] = occupancyPoint{Period: k, Days: m.daysByBucket[k], OccupiedDays: m.occupiedByBucket[k],
+				Rate: ratio(float64(m.occupiedByBucket[k]), float64(m.daysByBucket[k]))}
+			summary.daysByBucket[k] += m.daysByBucket[k]
+			summary.occupiedByBucket[k] += m.occupiedByBucket[k]
+		}
+
+		summary.Days += m.Days
+		summary.OccupiedDays += m.OccupiedDays
+		summary.Leases += m.Leases
+		summary.leaseDaysTotal += m.leaseDaysTotal
+		summary.acceptHoursTotal += m.acceptHoursTotal
+		summary.acceptedBookings += m.acceptedBookings
+		summary.CashRevenue += m.CashRevenue
+		summary.RecognizedRevenue += m.RecognizedRevenue
+		summary.Bookings.Created += m.Bookings.Created
+		summary.Bookings.Waiting += m.Bookings.Waiting
+		summary.Bookings.AwaitingSignature += m.Bookings.AwaitingSignature
+		summary.Bookings.Confirmed += m.Bookings.Confirmed
+		summary.Bookings.Rejected += m.Bookings.Rejected
+		summary.Bookings.Cancelled += m.Bookings.Cancelled
+		out = append(out, *m)
+	}
+
+	summary.VacancyDays = summary.Days - summary.OccupiedDays
+	summary.OccupancyRate = ratio(float64(summary.OccupiedDays), float64(summary.Days))
+	if summary.Leases > 0 {
+		summary.AvgLeaseDays = math.Round(summary.leaseDaysTotal/float64(summary.Leases)*10) / 10
+	}
+	if summary.acceptedBookings > 0 {
+		avg := math.Round(summary.acceptHoursTotal/float64(summary.acceptedBookings)*10) / 10
+		summary.AvgHoursToAccept = &avg
+	}
+	summary.Bookings.finish()
+	summary.Occupancy = make([]occupancyPoint, len(buckets))
+	for i, k := range buckets {
+		summary.Occupancy[i] = occupancyPoint{Period: k, Days: summary.daysByBucket[k], OccupiedDays: summary.occupiedByBucket[k],
+			Rate: ratio(float64(summary.occupiedByBucket[k]), float64(summary.daysByBucket[k]))}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":        q.From.Format(incomeDateLayout),
+		"to":          q.To.Format(incomeDateLayout),
+		"granularity": q.Granularity,
+		"timezone":    q.Loc.String(),
+		"summary":     summary,
+		"rukos":       out,
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// allowed facility tags
+var rukoFacilities = map[string]bool{
+	"parking":      true,
+	"water":        true, // PDAM / clean water
+	"internet":     true,
+	"ac":           true,
+	"cctv":         true,
+	"security":     true,
+	"generator":    true,
+	"loading_dock": true,
+	"pantry":       true,
+}
+
+var rukoZonings = map[string]bool{
+	"commercial":  true,
+	"mixed_use":   true,
+	"residential": true,
+	"industrial":  true,
+}
+
+// attribute ranges
+const (
+	maxRukoArea      = 100000 // m²
+	maxRukoFloors    = 20
+	maxRukoBathrooms = 50
+	maxRukoPowerVA   = 500000
+)
+
+// rukoAttributesInput is embedded in create/update input, nil = not sent
+type rukoAttributesInput struct {
+	LandArea     *float64  `json:"land_area"`
+	BuildingArea *float64  `json:"building_area"`
+	Floors       *int      `json:"floors"`
+	Bathrooms    *int      `json:"bathrooms"`
+	PowerVA      *int      `json:"power_va"`
+	Facilities   *[]string `json:"facilities"`
+	Zoning       *string   `json:"zoning"`
+}
+
+// apply copies sent attributes into r, and into set (if not nil) for $set updates
+func (in rukoAttributesInput) apply(r *Ruko, set bson.M) {
+	put := func(key string, v interface{}) {
+		if set != nil {
+			set[key] = v
+		}
+	}
+	if in.LandArea != nil {
+		r.LandArea = *in.LandArea
+		put("land_area", r.LandArea)
+	}
+	if in.BuildingArea != nil {
+		r.BuildingArea = *in.BuildingArea
+		put("building_area", r.BuildingArea)
+	}
+	if in.Floors != nil {
+		r.Floors = *in.Floors
+		put("floors", r.Floors)
+	}
+	if in.Bathrooms != nil {
+		r.Bathrooms = *in.Bathrooms
+		put("bathrooms", r.Bathrooms)
+	}
+	if in.PowerVA != nil {
+		r.PowerVA = *in.PowerVA
+		put("power_va", r.PowerVA)
+	}
+	if in.Facilities != nil {
+		r.Facilities = normalizeFacilities(*in.Facilities)
+		put("facilities", r.Facilities)
+	}
+	if in.Zoning != nil {
+		r.Zoning = strings.ToLower(strings.TrimSpace(*in.Zoning))
+		put("zoning", r.Zoning)
+	}
+}
+
+// lower-case, trim and dedupe facility tags
+func normalizeFacilities(tags []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+func validateRukoAttributes(r Ruko) error {
+	if r.LandArea < 0 || r.LandArea > maxRukoArea {
+		return fmt.Errorf("land_area must be between 0 and %d", maxRukoArea)
+	}
+	if r.BuildingArea < 0 || r.BuildingArea > maxRukoArea {
+		return fmt.Errorf("building_area must be between 0 and %d", maxRukoArea)
+	}
+	if r.Floors < 0 || r.Floors > maxRukoFloors {
+		return fmt.Errorf("floors must be between 0 and %d", maxRukoFloors)
+	}
+	if r.Bathrooms < 0 || r.Bathrooms > maxRukoBathrooms {
+		return fmt.Errorf("bathrooms must be between 0 and %d", maxRukoBathrooms)
+	}
+	if r.PowerVA < 0 || r.PowerVA > maxRukoPowerVA {
+		return fmt.Errorf("power_va must be between 0 and %d", maxRukoPowerVA)
+	}
+	for _, f := range r.Facilities {
+		if !rukoFacilities[f] {
+			return errors.New("unknown facility: " + f)
+		}
+	}
+	if r.Zoning != "" && !rukoZonings[r.Zoning] {
+		return errors.New("unknown zoning: " + r.Zoning)
+	}
+	return nil
+}
+
+// rukoListFilter builds the listing filter from query params, e.g.
+// ?min_building_area=100&min_floors=2&facilities=parking,internet&zoning=commercial
+func rukoListFilter(c *gin.Context) (bson.M, error) {
+	filter := notArchivedFilter()
+
+	rangeFilter := func(field, minKey, maxKey string, isInt bool) error {
+		cond := bson.M{}
+		for op, key := range map[string]string{"$gte": minKey, "$lte": maxKey} {
+			v := c.Query(key)
+			if key == "" || v == "" {
+				continue
+			}
+			if isInt {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					return errors.New("invalid " + key)
+				}
+				cond[op] = n
+			} else {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil || f < 0 {
+					return errors.New("invalid " + key)
+				}
+				cond[op] = f
+			}
+		}
+		if len(cond) > 0 {
+			filter[field] = cond
+		}
+		return nil
+	}
+	ranges := []struct {
+		field, min, max string
+		isInt           bool
+	}{
+		{"land_area", "min_land_area", "max_land_area", false},
+		{"building_area", "min_building_area", "max_building_area", false},
+		{"floors", "min_floors", "max_floors", true},
+		{"bathrooms", "min_bathrooms", "", true},
+		{"power_va", "min_power_va", "", true},
+	}
+	for _, r := range ranges {
+		if err := rangeFilter(r.field, r.min, r.max, r.isInt); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := c.Query("facilities"); v != "" {
+		tags := normalizeFacilities(strings.Split(v, ","))
+		for _, t := range tags {
+			if !rukoFacilities[t] {
+				return nil, errors.New("unknown facility: " + t)
+			}
+		}
+		if len(tags) > 0 {
+			filter["facilities"] = bson.M{"$all": tags}
+		}
+	}
+	if v := c.Query("zoning"); v != "" {
+		filter["zoning"] = strings.ToLower(v)
+	}
+	return filter, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditLoader loads the audited document by id
+type auditLoader func(ctx context.Context, id primitive.ObjectID) (interface{}, error)
+
+func loadFrom[T any](repo Repo[T]) auditLoader {
+	return func(ctx context.Context, id primitive.ObjectID) (interface{}, error) {
+		doc, err := repo.Get(ctx, id)
+		return doc, err
+	}
+}
+
+// repo of the document behind the first path segment of a route,
+// used to snapshot the target before and after the request
+var auditCollections = map[string]func(r Repositories) auditLoader{
+	"ruko":            func(r Repositories) auditLoader { return loadFrom[Ruko](r.Rukos) },
+	"bookings":        func(r Repositories) auditLoader { return loadFrom[Booking](r.Bookings) },
+	"payments":        func(r Repositories) auditLoader { return loadFrom[Payment](r.Payments) },
+	"discounts":       func(r Repositories) auditLoader { return loadFrom[Discount](r.Discounts) },
+	"payouts":         func(r Repositories) auditLoader { return loadFrom[PayoutBatch](r.PayoutBatches) },
+	"reviews":         func(r Repositories) auditLoader { return loadFrom[Review](r.Reviews) },
+	"invoices":        func(r Repositories) auditLoader { return loadFrom[Invoice](r.Invoices) },
+	"site-visits":     func(r Repositories) auditLoader { return loadFrom[SiteVisit](r.SiteVisits) },
+	"viewing-windows": func(r Repositories) auditLoader { return loadFrom[ViewingWindow](r.ViewingWindows) },
+	"saved-searches":  func(r Repositories) auditLoader { return loadFrom[SavedSearch](r.SavedSearches) },
+	"conversations":   func(r Repositories) auditLoader { return loadFrom[Conversation](r.Conversations) },
+	"notifications":   func(r Repositories) auditLoader { return loadFrom[Notification](r.Notifications) },
+	"offline-rentals": func(r Repositories) auditLoader { return loadFrom[RentalHistory](r.RentalHistory) },
+}
+
+// the audit log collection decodes nested documents as maps, so snapshots read well as JSON
+var auditCollectionOptions = options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+
+// fields never copied into the audit log
+var auditRedacted = map[string]bool{"password": true, "password_hash": true}
+
+// fields that change on every write and add nothing to a diff
+var auditIgnored = map[string]bool{"updated_at": true}
+
+func auditRetentionDays() int {
+	days := 365 // default
+	if v := os.Getenv("AUDIT_RETENTION_DAYS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+	return days
+}
+
+// ensureAuditIndexes creates the audit indexes; the TTL follows AUDIT_RETENTION_DAYS
+// and is updated with collMod when the setting changes
+func ensureAuditIndexes(ctx context.Context, db *mongo.Database) {
+	col := db.Collection("audit_logs")
+	_, _ = col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "request_id", Value: 1}}},
+	})
+	ttl := int32(auditRetentionDays() * 24 * 3600)
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(ttl),
+	})
+	if err == nil {
+		return
+	}
+	err = db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: "audit_logs"},
+		{Key: "index", Value: bson.M{"name": "created_at_ttl", "expireAfterSeconds": ttl}},
+	}).Err()
+	if err != nil {
+		log.Println("audit retention index error:", err)
+	}
+}
+
+// helper: request id from the client (X-Request-ID) or a new random one
+func requestID(c *gin.Context) string {
+	if v := c.GetHeader("X-Request-ID"); v != "" && len(v) <= 64 {
+		return v
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// auditTarget finds the audited document of a route, e.g. /api/ruko/:id/rented-offline is ruko :id
+func auditTarget(c *gin.Context) (targetType, param string) {
+	segments := strings.Split(strings.TrimPrefix(c.FullPath(), "/api/"), "/")
+	for i, s := range segments {
+		if s == "" || strings.HasPrefix(s, ":") {
+			continue
+		}
+		if targetType == "" {
+			targetType = s
+		}
+		if _, ok := auditCollections[s]; ok {
+			if i+1 < len(segments) && strings.HasPrefix(segments[i+1], ":") {
+				return s, segments[i+1][1:]
+			}
+			return s, ""
+		}
+	}
+	// no known collection: keep the first segment and the first route param
+	for _, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			return targetType, s[1:]
+		}
+	}
+	return targetType, ""
+}
+
+// setAuditTarget lets a create handler name the new document, so it is saved as "after"
+func setAuditTarget(c *gin.Context, id primitive.ObjectID) {
+	c.Set("audit_target_id", id.Hex())
+}
+
+// helper: load the audited document as stored
+func (h *Handlers) auditSnapshot(ctx context.Context, targetType, id string) bson.M {
+	loader, ok := auditCollections[targetType]
+	if !ok {
+		return nil
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil
+	}
+	found, err := loader(h.repo)(ctx, oid)
+	if err != nil {
+		return nil
+	}
+	doc, err := toBSONDoc(found)
+	if err != nil {
+		return nil
+	}
+	for k := range auditRedacted {
+		delete(doc, k)
+	}
+	return doc
+}
+
+// auditDiff returns the top-level fields that differ between two snapshots
+func auditDiff(before, after bson.M) map[string]AuditChange {
+	changes := map[string]AuditChange{}
+	for k, v := range after {
+		if auditIgnored[k] {
+			continue
+		}
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes[k] = AuditChange{From: before[k], To: v}
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok && !auditIgnored[k] {
+			changes[k] = AuditChange{From: v}
+		}
+	}
+	return changes
+}
+
+// AuditMiddleware records every mutating request (actor, target, before/after diff)
+// and tags all requests with an X-Request-ID
+func (h *Handlers) AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rid := requestID(c)
+		c.Set("request_id", rid)
+		c.Header("X-Request-ID", rid)
+
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+		ctx := context.Background()
+		targetType, param := auditTarget(c)
+		targetID := ""
+		if param != "" {
+			targetID = c.Param(param)
+		}
+		var before bson.M
+		if targetID != "" {
+			before = h.auditSnapshot(ctx, targetType, targetID)
+		}
+
+		c.Next()
+
+		if targetID == "" {
+			targetID = c.GetString("audit_target_id")
+		}
+		entry := AuditLog{
+			RequestID:  rid,
+			Role:       c.GetString("role"),
+			Action:     c.Request.Method + " " + c.FullPath(),
+			Path:       c.Request.URL.Path,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Status:     c.Writer.Status(),
+			IP:         c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			CreatedAt:  time.Now(),
+		}
+		if uid, err := GetUserIDFromContext(c); err == nil {
+			entry.ActorID = &uid
+		}
+		if len(c.Params) > 0 {
+			entry.Params = map[string]string{}
+			for _, p := range c.Params {
+				entry.Params[p.Key] = p.Value
+			}
+		}
+		if entry.Status < http.StatusBadRequest && targetID != "" {
+			after := h.auditSnapshot(ctx, targetType, targetID)
+			if before != nil || after != nil {
+				entry.Changes = auditDiff(before, after)
+			}
+		}
+		if err := h.repo.AuditLogs.Create(ctx, &entry); err != nil {
+			log.Println("audit log error:", err)
+		}
+	}
+}
+
+// ListAuditLogs: GET /api/audit-logs?actor_id=&target_type=&target_id=&action=&request_id=&status=&from=&to=&page=&limit=
+// action matches the start of "METHOD /api/route", e.g. action=PATCH /api/ruko
+func (h *Handlers) ListAuditLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	filter := bson.M{}
+	if v := c.Query("actor_id"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid actor_id"})
+			return
+		}
+		filter["actor_id"] = oid
+	}
+	for _, key := range []string{"target_type", "target_id", "request_id", "role"} {
+		if v := c.Query(key); v != "" {
+			filter[key] = v
+		}
+	}
+	if v := c.Query("action"); v != "" {
+		filter["action"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(v)}
+	}
+	if v := c.Query("status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+			return
+		}
+		filter["status"] = status
+	}
+	from, to, err := parseExportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created := bson.M{}
+	if from != nil {
+		created["$gte"] = *from
+	}
+	if to != nil {
+		created["$lt"] = *to
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	ctx := context.Background()
+	total, err := h.repo.AuditLogs.Count(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed count audit logs"})
+		return
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	out, err := h.repo.AuditLogs.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list audit logs"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": page, "limit": limit, "total": total})
+}
+
+// GetAuditLog: GET /api/audit-logs/:id
+func (h *Handlers) GetAuditLog(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	entry, err := h.repo.AuditLogs.Get(context.Background(), oid)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "audit log not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
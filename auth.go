@@ -27,11 +27,19 @@ func CheckPassword(hashed, pw string) bool {
 
 // JWT claims
 type MyClaims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	UserID  string `json:"user_id"`
+	Role    string `json:"role"`
+	Purpose string `json:"purpose,omitempty"` // "" for login tokens, streamTokenPurpose for ?token=
 	jwt.RegisteredClaims
 }
 
+// stream tokens are put in the URL by the browser (EventSource cannot send headers),
+// so they only open event streams and expire quickly
+const (
+	streamTokenPurpose = "stream"
+	streamTokenTTL     = time.Minute
+)
+
 func jwtSecret() []byte {
 	s := os.Getenv("JWT_SECRET")
 	if s == "" {
@@ -53,10 +61,20 @@ func jwtExpiry() time.Duration {
 }
 
 func GenerateToken(userID primitive.ObjectID, role string) (string, time.Time, error) {
-	exp := time.Now().Add(jwtExpiry())
+	return signToken(userID, role, "", jwtExpiry())
+}
+
+// GenerateStreamToken returns a short-lived token accepted by StreamAuthMiddleware
+func GenerateStreamToken(userID primitive.ObjectID, role string) (string, time.Time, error) {
+	return signToken(userID, role, streamTokenPurpose, streamTokenTTL)
+}
+
+func signToken(userID primitive.ObjectID, role, purpose string, ttl time.Duration) (string, time.Time, error) {
+	exp := time.Now().Add(ttl)
 	claims := MyClaims{
-		UserID: userID.Hex(),
-		Role:   role,
+		UserID:  userID.Hex(),
+		Role:    role,
+		Purpose: purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -80,26 +98,57 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid Authorization header"})
 			return
 		}
-		tokenStr := parts[1]
-		token, err := jwt.ParseWithClaims(tokenStr, &MyClaims{}, func(t *jwt.Token) (interface{}, error) {
-			return jwtSecret(), nil
-		})
-		if err != nil || !token.Valid {
+		claims, err := parseToken(parts[1], "")
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
 			return
 		}
-		claims, ok := token.Claims.(*MyClaims)
-		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid claims"})
+		// pass user id & role in context
+		c.Set("user_id", claims.UserID)
+		c.Set("role", claims.Role)
+		c.Next()
+	}
+}
+
+// StreamAuthMiddleware is AuthMiddleware for event streams: without an Authorization
+// header, a stream token from GenerateStreamToken is read from ?token=
+func StreamAuthMiddleware() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		tokenStr := c.Query("token")
+		if tokenStr == "" || c.GetHeader("Authorization") != "" {
+			auth(c)
+			return
+		}
+		claims, err := parseToken(tokenStr, streamTokenPurpose)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
 			return
 		}
-		// pass user id & role in context
 		c.Set("user_id", claims.UserID)
 		c.Set("role", claims.Role)
 		c.Next()
 	}
 }
 
+// helper: validate a token issued for purpose
+func parseToken(tokenStr, purpose string) (*MyClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &MyClaims{}, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*MyClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid claims")
+	}
+	if claims.Purpose != purpose {
+		return nil, errors.New("token cannot be used here")
+	}
+	return claims, nil
+}
+
 // Role guard middleware: only allow if role in allowedRoles
 func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
 	roleSet := make(map[string]bool)
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnalyticsCacheTTL = 5 * time.Minute
+	maxCacheEntries          = 500
+)
+
+// TTLCache keeps computed values in memory for a fixed time.
+// Each instance has its own copy, which is fine for aggregated reports.
+type TTLCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func NewTTLCache(ttl time.Duration) *TTLCache {
+	return &TTLCache{ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+// analyticsCacheFromEnv reads ANALYTICS_CACHE_SECONDS (default 300, 0 disables the cache)
+func analyticsCacheFromEnv() *TTLCache {
+	ttl := defaultAnalyticsCacheTTL
+	if v := os.Getenv("ANALYTICS_CACHE_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			ttl = time.Duration(parsed) * time.Second
+		}
+	}
+	return NewTTLCache(ttl)
+}
+
+func (c *TTLCache) Get(key string) (interface{}, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *TTLCache) Set(key string, value interface{}) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// still full: drop everything rather than grow without bound
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = map[string]cacheEntry{}
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
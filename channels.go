@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errSkipDelivery is returned when the user cannot be reached on a channel (no email/phone)
+var errSkipDelivery = errors.New("recipient has no address for this channel")
+
+// NotificationChannel delivers a notification outside the app (email, WhatsApp, SMS, ...)
+type NotificationChannel interface {
+	Name() string
+	Send(ctx context.Context, to User, n Notification) error
+}
+
+// channelsFromEnv builds the configured delivery channels
+//
+//	SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASS, SMTP_FROM   -> email
+//	WHATSAPP_WEBHOOK_URL, WHATSAPP_WEBHOOK_TOKEN            -> whatsapp
+//	SMS_WEBHOOK_URL, SMS_WEBHOOK_TOKEN                      -> sms
+//	NOTIFY_LOG=true                                         -> log (local dev)
+func channelsFromEnv() []NotificationChannel {
+	var out []NotificationChannel
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		out = append(out, &SMTPChannel{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASS"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	}
+	if url := os.Getenv("WHATSAPP_WEBHOOK_URL"); url != "" {
+		out = append(out, NewWebhookChannel("whatsapp", url, os.Getenv("WHATSAPP_WEBHOOK_TOKEN")))
+	}
+	if url := os.Getenv("SMS_WEBHOOK_URL"); url != "" {
+		out = append(out, NewWebhookChannel("sms", url, os.Getenv("SMS_WEBHOOK_TOKEN")))
+	}
+	if os.Getenv("NOTIFY_LOG") == "true" {
+		out = append(out, LogChannel{})
+	}
+	return out
+}
+
+// --- Email (SMTP) ---
+
+type SMTPChannel struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPChannel) Name() string { return "email" }
+
+func (s *SMTPChannel) Send(ctx context.Context, to User, n Notification) error {
+	if to.Email == "" {
+		return errSkipDelivery
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	from := s.From
+	if from == "" {
+		from = s.Username
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Title)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(n.Body)
+	msg.WriteString("\r\n")
+	return smtp.SendMail(s.Host+":"+s.Port, auth, from, []string{to.Email}, msg.Bytes())
+}
+
+// --- WhatsApp / SMS gateway (HTTP webhook) ---
+
+type WebhookChannel struct {
+	name   string
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func NewWebhookChannel(name, url, token string) *WebhookChannel {
+	return &WebhookChannel{name: name, URL: url, Token: token, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *WebhookChannel) Name() string { return w.name }
+
+func (w *WebhookChannel) Send(ctx context.Context, to User, n Notification) error {
+	if to.Phone == "" {
+		return errSkipDelivery
+	}
+	payload, err := json.Marshal(map[string]string{
+		"to":      to.Phone,
+		"type":    n.Type,
+		"message": n.Title + "\n" + n.Body,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook returned %d", w.name, resp.StatusCode)
+	}
+	return nil
+}
+
+// --- Local implementations ---
+
+// LogChannel only logs the notification (local development)
+type LogChannel struct{}
+
+func (LogChannel) Name() string { return "log" }
+
+func (LogChannel) Send(ctx context.Context, to User, n Notification) error {
+	log.Printf("[notify] to=%s type=%s title=%q", to.Email, n.Type, n.Title)
+	return nil
+}
+
+// FakeChannel records notifications in memory instead of sending them (tests)
+type FakeChannel struct {
+	ChannelName string
+	Err         error // returned by Send when set
+
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (f *FakeChannel) Name() string {
+	if f.ChannelName == "" {
+		return "fake"
+	}
+	return f.ChannelName
+}
+
+func (f *FakeChannel) Send(ctx context.Context, to User, n Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// Sent returns the notifications recorded so far
+func (f *FakeChannel) Sent() []Notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Notification(nil), f.sent...)
+}
+
+// helper: short error text for delivery records
+func deliveryError(err error) string {
+	s := err.Error()
+	if len(s) > 200 {
+		s = s[:200]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendToChannelsRecordsEachDelivery(t *testing.T) {
+	ok := &FakeChannel{ChannelName: "email"}
+	down := &FakeChannel{ChannelName: "whatsapp", Err: errors.New("gateway down")}
+	noPhone := &FakeChannel{ChannelName: "sms", Err: errSkipDelivery}
+	n := Notification{Type: "booking.created", Title: "Booking baru", Body: "Ada booking baru"}
+
+	deliveries := sendToChannels(context.Background(), []NotificationChannel{ok, down, noPhone}, User{Email: "a@example.com"}, n)
+
+	want := []struct{ channel, status string }{{"email", "sent"}, {"whatsapp", "failed"}, {"sms", "skipped"}}
+	if len(deliveries) != len(want) {
+		t.Fatalf("got %d deliveries, want %d", len(deliveries), len(want))
+	}
+	for i, w := range want {
+		d := deliveries[i]
+		if d.Channel != w.channel || d.Status != w.status {
+			t.Errorf("delivery %d = %s/%s, want %s/%s", i, d.Channel, d.Status, w.channel, w.status)
+		}
+		if d.At.IsZero() {
+			t.Errorf("delivery %d has no time", i)
+		}
+	}
+	if deliveries[1].Error != "gateway down" {
+		t.Errorf("failed delivery error = %q", deliveries[1].Error)
+	}
+	if sent := ok.Sent(); len(sent) != 1 || sent[0].Title != n.Title {
+		t.Errorf("email channel got %+v", sent)
+	}
+	if len(down.Sent()) != 0 {
+		t.Error("failing channel recorded a notification")
+	}
+}
+
+func TestWebhookChannelSend(t *testing.T) {
+	var got map[string]string
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	ch := NewWebhookChannel("whatsapp", srv.URL, "secret")
+	n := Notification{Type: "payment.confirmed", Title: "Pembayaran dikonfirmasi", Body: "Terima kasih"}
+	if err := ch.Send(context.Background(), User{Phone: "0812"}, n); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("authorization = %q", auth)
+	}
+	if got["to"] != "0812" || got["type"] != n.Type || got["message"] != n.Title+"\n"+n.Body {
+		t.Errorf("payload = %v", got)
+	}
+
+	if err := ch.Send(context.Background(), User{}, n); !errors.Is(err, errSkipDelivery) {
+		t.Errorf("send without phone = %v, want errSkipDelivery", err)
+	}
+}
+
+func TestWebhookChannelErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := NewWebhookChannel("sms", srv.URL, "").Send(context.Background(), User{Phone: "0812"}, Notification{})
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("err = %v, want webhook status error", err)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var contractTemplate = template.Must(template.New("contract").Funcs(pdfTemplateFuncs).Parse(`Nomor: {{.Number}}
+Versi: {{.Version}}
+Tanggal: {{date .Date}}
+
+Pada hari ini telah dibuat dan disepakati perjanjian sewa ruko antara:
+
+## PIHAK PERTAMA (Pemilik)
+Nama: {{.Owner.Name}}
+Email: {{.Owner.Email}}
+Telepon: {{.Owner.Phone}}
+Alamat: {{.Owner.Address}}
+
+## PIHAK KEDUA (Penyewa)
+Nama: {{.Tenant.Name}}
+Email: {{.Tenant.Email}}
+Telepon: {{.Tenant.Phone}}
+Alamat: {{.Tenant.Address}}
+
+## PASAL 1 - OBJEK SEWA
+Pihak Pertama menyewakan kepada Pihak Kedua sebuah ruko:
+Nama: {{.Ruko.Name}}
+Alamat: {{.Ruko.Address}}{{if .Ruko.City}}, {{.Ruko.City}}{{end}}
+{{- if .Ruko.BuildingArea}}
+Luas bangunan: {{.Ruko.BuildingArea}} m2{{end}}
+{{- if .Ruko.Floors}}
+Jumlah lantai: {{.Ruko.Floors}}{{end}}
+
+## PASAL 2 - JANGKA WAKTU
+Sewa berlaku sejak {{date .Booking.StartDate}} sampai dengan {{date .Booking.EndDate}}.
+
+## PASAL 3 - HARGA SEWA DAN PEMBAYARAN
+Harga sewa: {{rupiah .Ruko.Price}} per {{if eq .Ruko.RentalType "yearly"}}tahun{{else}}bulan{{end}}.
+Total yang harus dibayar (termasuk pajak dan diskon): {{rupiah .Booking.TotalPrice}}.
+Metode pembayaran: {{.Booking.PaymentMethod}}.
+
+## PASAL 4 - KEWAJIBAN PARA PIHAK
+Pihak Pertama menyerahkan ruko dalam keadaan baik dan layak pakai pada tanggal mulai sewa.
+Pihak Kedua wajib menjaga dan merawat ruko, serta tidak mengalihkan sewa kepada pihak lain tanpa persetujuan tertulis Pihak Pertama.
+Biaya listrik, air dan internet selama masa sewa ditanggung oleh Pihak Kedua.
+
+## PASAL 5 - BERAKHIRNYA PERJANJIAN
+Pada akhir masa sewa Pihak Kedua wajib mengembalikan ruko dalam keadaan seperti semula.
+Perpanjangan sewa dilakukan dengan perjanjian baru atau perubahan perjanjian ini.
+
+Booking ID: {{.Booking.ID.Hex}}
+`))
+
+// contractData is what the template is filled with
+type contractData struct {
+	Number  string
+	Version int
+	Date    time.Time
+	Owner   User
+	Tenant  User
+	Ruko    Ruko
+	Booking Booking
+}
+
+// dataHash covers every field printed in the contract, a new version is only made when it changes
+func (d contractData) dataHash() string {
+	owner, tenant := d.Owner, d.Tenant
+	b, _ := json.Marshal(map[string]interface{}{
+		"owner":  []string{owner.Name, owner.Email, owner.Phone, owner.Address},
+		"tenant": []string{tenant.Name, tenant.Email, tenant.Phone, tenant.Address},
+		"ruko": []interface{}{d.Ruko.Name, d.Ruko.Address, d.Ruko.City, d.Ruko.BuildingArea,
+			d.Ruko.Floors, d.Ruko.Price, d.Ruko.RentalType},
+		"booking": []interface{}{d.Booking.StartDate, d.Booking.EndDate, d.Booking.TotalPrice, d.Booking.PaymentMethod},
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// helper: load everything printed in the contract of a booking
+func (h *Handlers) loadContractData(ctx context.Context, bookingID primitive.ObjectID) (contractData, error) {
+	var d contractData
+	var err error
+	if d.Booking, err = h.repo.Bookings.Get(ctx, bookingID); err != nil {
+		return d, fmt.Errorf("booking not found: %w", err)
+	}
+	if d.Ruko, err = h.repo.Rukos.Get(ctx, d.Booking.RukoID); err != nil {
+		return d, fmt.Errorf("ruko not found: %w", err)
+	}
+	if d.Owner, err = h.repo.Users.Get(ctx, d.Ruko.OwnerID); err != nil {
+		return d, fmt.Errorf("owner not found: %w", err)
+	}
+	if d.Tenant, err = h.repo.Users.Get(ctx, d.Booking.TenantID); err != nil {
+		return d, fmt.Errorf("tenant not found: %w", err)
+	}
+	return d, nil
+}
+
+// latestContract returns the newest contract version of a booking
+func (h *Handlers) latestContract(ctx context.Context, bookingID primitive.ObjectID) (*Contract, error) {
+	found, err := h.repo.Contracts.Find(ctx, bson.M{"booking_id": bookingID},
+		options.Find().SetSort(bson.D{{Key: "version", Value: -1}}).SetLimit(1))
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+// generateContract renders and stores the rental agreement of a booking.
+// When the booking data did not change since the latest version, that version is returned.
+func (h *Handlers) generateContract(ctx context.Context, bookingID primitive.ObjectID) (*Contract, error) {
+	d, err := h.loadContractData(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := h.latestContract(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	dataHash := d.dataHash()
+	if latest != nil && latest.DataHash == dataHash {
+		return latest, nil
+	}
+
+	d.Version = 1
+	if latest != nil {
+		d.Version = latest.Version + 1
+	}
+	d.Date = time.Now()
+	d.Number = fmt.Sprintf("PSR/%s/%s", d.Date.Format("2006"), bookingID.Hex())
+
+	var text bytes.Buffer
+	if err := contractTemplate.Execute(&text, d); err != nil {
+		return nil, err
+	}
+	pdfBytes, err := renderTextPDF("PERJANJIAN SEWA RUKO", text.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// the id is part of the file key, so a request that loses the race for this version
+	// cannot overwrite the file of the contract that was stored
+	id := primitive.NewObjectID()
+	ct := Contract{
+		ID:          id,
+		BookingID:   bookingID,
+		RukoID:      d.Ruko.ID,
+		OwnerID:     d.Ruko.OwnerID,
+		TenantID:    d.Booking.TenantID,
+		Version:     d.Version,
+		FileKey:     fmt.Sprintf("contracts/%s/v%d-%s.pdf", bookingID.Hex(), d.Version, id.Hex()),
+		DataHash:    dataHash,
+		ContentHash: sha256Hex(pdfBytes),
+		CreatedAt:   d.Date,
+	}
+	if err := h.documents.Save(ctx, ct.FileKey, bytes.NewReader(pdfBytes)); err != nil {
+		return nil, err
+	}
+	if err := h.repo.Contracts.Create(ctx, &ct); err != nil {
+		_ = h.documents.Delete(ctx, ct.FileKey)
+		if err == ErrDuplicate {
+			// generated concurrently, use that version
+			return h.latestContract(ctx, bookingID)
+		}
+		return nil, err
+	}
+	return &ct, nil
+}
+
+// helper: generate contract without failing the request
+func (h *Handlers) ensureContract(ctx context.Context, bookingID primitive.ObjectID) {
+	if _, err := h.generateContract(ctx, bookingID); err != nil {
+		log.Println("generate contract error:", err)
+	}
+}
+
+// moveContractFiles moves contracts saved by older versions in the public upload
+// directory to the private document storage
+func moveContractFiles(public, private Storage) {
+	from, ok1 := public.(*LocalStorage)
+	to, ok2 := private.(*LocalStorage)
+	if !ok1 || !ok2 {
+		return
+	}
+	root := filepath.Join(from.Dir, "contracts")
+	moved := 0
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(from.Dir, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(to.Dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(p, dst); err != nil {
+			return err
+		}
+		moved++
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println("move contract files error:", err)
+	}
+	if moved > 0 {
+		log.Printf("moved %d contract files to private storage\n", moved)
+	}
+}
+
+// helper: load booking by :id for its tenant, the ruko owner or an admin
+func (h *Handlers) loadBookingForParty(c *gin.Context) (Booking, Ruko, bool) {
+	var b Booking
+	var r Ruko
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return b, r, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return b, r, false
+	}
+	ctx := context.Background()
+	if b, err = h.repo.Bookings.Get(ctx, oid); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return b, r, false
+	}
+	if r, err = h.repo.Rukos.Get(ctx, b.RukoID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return b, r, false
+	}
+	if uid != b.TenantID && uid != r.OwnerID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return b, r, false
+	}
+	return b, r, true
+}
+
+// helper: contract of the booking selected by ?version=N, latest version by default
+func (h *Handlers) contractVersion(c *gin.Context, bookingID primitive.ObjectID) (*Contract, bool) {
+	ctx := context.Background()
+	if v := c.Query("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+			return nil, false
+		}
+		found, err := h.repo.Contracts.FindOne(ctx, bson.M{"booking_id": bookingID, "version": version})
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "contract version not found"})
+			return nil, false
+		}
+		return &found, true
+	}
+	ct, err := h.latestContract(ctx, bookingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load contract"})
+		return nil, false
+	}
+	if ct == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "contract not generated yet, booking is not accepted"})
+		return nil, false
+	}
+	return ct, true
+}
+
+// GetBookingContract: GET /api/bookings/:id/contract?version=N (pdf, latest version by default)
+func (h *Handlers) GetBookingContract(c *gin.Context) {
+	b, _, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	ct, ok := h.contractVersion(c, b.ID)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+
+	f, err := h.documents.Open(ctx, ct.FileKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "contract file not available"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed read contract"})
+		return
+	}
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="contract-%s-v%d.pdf"`, b.ID.Hex(), ct.Version))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// ListBookingContracts: GET /api/bookings/:id/contracts (all versions, newest first)
+func (h *Handlers) ListBookingContracts(c *gin.Context) {
+	b, _, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	out, err := h.repo.Contracts.Find(context.Background(), bson.M{"booking_id": b.ID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list contracts"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// RegenerateBookingContract: POST /api/bookings/:id/contract
+// owner/admin, creates a new version after the booking or parties' data was amended
+func (h *Handlers) RegenerateBookingContract(c *gin.Context) {
+	b, r, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	uid, _ := GetUserIDFromContext(c)
+	if uid != r.OwnerID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the owner can regenerate the contract"})
+		return
+	}
+	if b.BookingStatus != "awaiting_signature" && b.BookingStatus != "confirmed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "booking is not accepted"})
+		return
+	}
+	ctx := context.Background()
+	prev, err := h.latestContract(ctx, b.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load contract"})
+		return
+	}
+	ct, err := h.generateContract(ctx, b.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed generate contract"})
+		return
+	}
+	// an amended contract has to be signed again by both parties
+	if prev != nil && prev.Version != ct.Version {
+		_ = h.repo.Bookings.Update(ctx, b.ID, bson.M{"booking_status": "awaiting_signature", "updated_at": time.Now()})
+		h.notify(ctx, b.TenantID, "contract.amended",
+			"Kontrak diperbarui",
+			fmt.Sprintf("Kontrak sewa diperbarui ke versi %d, silakan tanda tangani kembali", ct.Version),
+			map[string]string{"booking_id": b.ID.Hex(), "contract_id": ct.ID.Hex()})
+	}
+	c.JSON(http.StatusOK, ct)
+}
@@ -16,9 +16,8 @@ func loadEnv() {
 	_ = godotenv.Load()
 }
 
-func ctxBackground() context.Context {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	return ctx
+func ctxBackground() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 10*time.Second)
 }
 
 func InitMongo() (*mongo.Client, *mongo.Database) {
@@ -47,7 +46,11 @@ func InitMongo() (*mongo.Client, *mongo.Database) {
 	db := client.Database(dbName)
 
 	// create indexes if needed (example)
+	migrateRukoLocations(db)
 	ensureIndexes(db)
+	backfillPaymentLedger(db)
+	backfillActivities(db)
+	failStaleImportJobs(db)
 
 	return client, db
 }
@@ -62,4 +65,147 @@ func ensureIndexes(db *mongo.Database) {
 		Options: options.Index().SetUnique(true),
 	}
 	_, _ = users.Indexes().CreateOne(ctx, mod)
+
+	// date-range scans of the admin analytics
+	_, _ = users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "created_at", Value: 1}},
+	})
+	_, _ = db.Collection("bookings").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "created_at", Value: 1}},
+	})
+	_, _ = db.Collection("payments").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "payment_date", Value: 1}},
+	})
+
+	// one review per rental
+	reviews := db.Collection("reviews")
+	_, _ = reviews.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "rental_history_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "ruko_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+	})
+
+	_, _ = db.Collection("favorites").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "ruko_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	_, _ = db.Collection("saved_searches").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	_, _ = db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	_, _ = db.Collection("conversations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "ruko_id", Value: 1}, {Key: "tenant_id", Value: 1}, {Key: "booking_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "last_message_at", Value: -1}}},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "last_message_at", Value: -1}}},
+	})
+	_, _ = db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "_id", Value: -1}},
+	})
+
+	_, _ = db.Collection("viewing_windows").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "ruko_id", Value: 1}, {Key: "start", Value: 1}},
+	})
+	_, _ = db.Collection("site_visits").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "status", Value: 1}, {Key: "start", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "start", Value: -1}}},
+	})
+
+	_, _ = db.Collection("contracts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "booking_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	_, _ = db.Collection("invoices").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// one live invoice per installment, so concurrent requests cannot issue a booking's invoice twice
+		// (partial $in filters need MongoDB 6.0+)
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "installment", Value: 1}}, Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": bson.M{"$in": bson.A{"unpaid", "paid"}}})},
+	})
+	_, _ = db.Collection("receipts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}}},
+	})
+
+	_, _ = db.Collection("ledger").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	_, _ = db.Collection("payout_batches").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "items.owner_id", Value: 1}}},
+	})
+
+	_, _ = db.Collection("ruko").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "external_ref", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"external_ref": bson.M{"$type": "string"}}),
+	})
+	_, _ = db.Collection("import_jobs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	_, _ = db.Collection("rental_history").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ruko_id", Value: 1}, {Key: "source", Value: 1}, {Key: "start_date", Value: 1}}},
+		{Keys: bson.D{{Key: "booking_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"booking_id": bson.M{"$exists": true}})},
+	})
+	_, _ = db.Collection("activities").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "ruko_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+
+	ensureAuditIndexes(ctx, db)
+	ensureIdempotencyIndexes(db)
+
+	// geo index for nearby / bounding box search
+	ruko := db.Collection("ruko")
+	geo := mongo.IndexModel{
+		Keys: bson.D{{Key: "location", Value: "2dsphere"}},
+	}
+	if _, err := ruko.Indexes().CreateOne(ctx, geo); err != nil {
+		log.Println("create ruko location index error:", err)
+	}
+}
+
+// migrateRukoLocations fills the GeoJSON location for rukos created before
+// the field existed, using their latitude/longitude.
+func migrateRukoLocations(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	col := db.Collection("ruko")
+	cur, err := col.Find(ctx, bson.M{
+		"location":  bson.M{"$exists": false},
+		"latitude":  bson.M{"$exists": true},
+		"longitude": bson.M{"$exists": true},
+	})
+	if err != nil {
+		log.Println("ruko location migration error:", err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	migrated := 0
+	for cur.Next(ctx) {
+		var r Ruko
+		if err := cur.Decode(&r); err != nil {
+			continue
+		}
+		if !validLatLng(r.Latitude, r.Longitude) {
+			continue
+		}
+		_, err := col.UpdateByID(ctx, r.ID, bson.M{"$set": bson.M{"location": NewGeoPoint(r.Latitude, r.Longitude)}})
+		if err == nil {
+			migrated++
+		}
+	}
+	if migrated > 0 {
+		log.Printf("Migrated location for %d ruko\n", migrated)
+	}
 }
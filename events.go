@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event pushed to the owner dashboard
+type Event struct {
+	Type    string                 `json:"type"` // booking.created, booking.accepted, payment.confirmed, ruko.availability, ...
+	OwnerID primitive.ObjectID     `json:"owner_id"`
+	RukoID  primitive.ObjectID     `json:"ruko_id"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	At      time.Time              `json:"at"`
+}
+
+// EventBus delivers events to subscribers of one owner.
+// MemoryEventBus works inside a single process; a Mongo change stream
+// implementation can replace it when running several instances.
+type EventBus interface {
+	Publish(ev Event)
+	Subscribe(ownerID primitive.ObjectID) (<-chan Event, func())
+}
+
+const eventBufferSize = 16
+
+type MemoryEventBus struct {
+	mu   sync.RWMutex
+	subs map[primitive.ObjectID]map[chan Event]struct{}
+}
+
+func NewMemoryEventBus() *MemoryEventBus {
+	return &MemoryEventBus{subs: map[primitive.ObjectID]map[chan Event]struct{}{}}
+}
+
+func (b *MemoryEventBus) Publish(ev Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs[ev.OwnerID] {
+		select {
+		case ch <- ev:
+		default:
+			// slow subscriber, drop the event instead of blocking the request
+		}
+	}
+}
+
+func (b *MemoryEventBus) Subscribe(ownerID primitive.ObjectID) (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	if b.subs[ownerID] == nil {
+		b.subs[ownerID] = map[chan Event]struct{}{}
+	}
+	b.subs[ownerID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs[ownerID], ch)
+			if len(b.subs[ownerID]) == 0 {
+				delete(b.subs, ownerID)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// helper: publish an event for the owner of the ruko and add it to the activity feed
+func (h *Handlers) publishRukoEvent(ctx context.Context, rukoID primitive.ObjectID, etype string, data map[string]interface{}) {
+	r, err := h.repo.Rukos.Get(ctx, rukoID)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	h.recordActivity(ctx, r, etype, data, now)
+	if h.events == nil {
+		return
+	}
+	h.events.Publish(Event{Type: etype, OwnerID: r.OwnerID, RukoID: rukoID, Data: data, At: now})
+}
+
+// helper: publish availability change of a ruko
+func (h *Handlers) publishAvailability(ctx context.Context, rukoID primitive.ObjectID, available bool) {
+	h.publishRukoEvent(ctx, rukoID, "ruko.availability", map[string]interface{}{"is_available": available})
+}
+
+const sseHeartbeat = 25 * time.Second
+
+// CreateEventsToken: POST /api/:ownerId/events/token
+// short-lived token for new EventSource("/api/:ownerId/events?token=..."), browsers cannot
+// send the Authorization header on an event stream
+func (h *Handlers) CreateEventsToken(c *gin.Context) {
+	if _, ok := ownerParam(c); !ok {
+		return
+	}
+	uid, _ := GetUserIDFromContext(c)
+	token, exp, err := GenerateStreamToken(uid, c.GetString("role"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed generate token"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "expires_at": exp})
+}
+
+// OwnerEvents: GET /api/:ownerId/events (Server-Sent Events)
+// authenticated with the Authorization header or ?token= from CreateEventsToken
+func (h *Handlers) OwnerEvents(c *gin.Context) {
+	ownerOID, err := primitive.ObjectIDFromHex(c.Param("ownerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	if c.GetString("role") != "admin" && uid != ownerOID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not your dashboard"})
+		return
+	}
+
+	events, unsubscribe := h.events.Subscribe(ownerOID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	c.SSEvent("ready", gin.H{"owner_id": ownerOID.Hex()})
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Type, ev)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
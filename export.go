@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rowWriter writes an export row by row
+type rowWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// exportCell converts a value to what spreadsheets display well
+func exportCell(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		// text starting like a formula is quoted, so a ruko or tenant name cannot run in the spreadsheet
+		if x != "" && strings.ContainsRune("=+-@\t\r", rune(x[0])) {
+			return "'" + x
+		}
+		return x
+	case primitive.ObjectID:
+		if x.IsZero() {
+			return ""
+		}
+		return x.Hex()
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		return x.Format("2006-01-02 15:04")
+	case nil:
+		return ""
+	}
+	return v
+}
+
+type csvRowWriter struct {
+	w    *csv.Writer
+	rows int
+}
+
+func (cw *csvRowWriter) Write(row []interface{}) error {
+	rec := make([]string, len(row))
+	for i, v := range row {
+		switch x := exportCell(v).(type) {
+		case string:
+			rec[i] = x
+		case float64:
+			rec[i] = strconv.FormatFloat(x, 'f', -1, 64)
+		default:
+			rec[i] = fmt.Sprint(x)
+		}
+	}
+	if err := cw.w.Write(rec); err != nil {
+		return err
+	}
+	// push rows to the client regularly instead of buffering the whole file
+	cw.rows++
+	if cw.rows%500 == 0 {
+		cw.w.Flush()
+	}
+	return cw.w.Error()
+}
+
+func (cw *csvRowWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// xlsxRowWriter uses the excelize stream writer, which keeps rows in a temp file
+// instead of memory; the workbook is written to the response on Close
+type xlsxRowWriter struct {
+	f   *excelize.File
+	sw  *excelize.StreamWriter
+	out io.Writer
+	row int
+}
+
+func newXLSXRowWriter(out io.Writer, sheet string) (*xlsxRowWriter, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxRowWriter{f: f, sw: sw, out: out}, nil
+}
+
+func (xw *xlsxRowWriter) Write(row []interface{}) error {
+	xw.row++
+	cell, err := excelize.CoordinatesToCellName(1, xw.row)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		values[i] = exportCell(v)
+	}
+	return xw.sw.SetRow(cell, values)
+}
+
+func (xw *xlsxRowWriter) Close() error {
+	defer xw.f.Close()
+	if err := xw.sw.Flush(); err != nil {
+		return err
+	}
+	return xw.f.Write(xw.out)
+}
+
+// exportDataset streams one dataset of an owner
+type exportDataset struct {
+	header []string
+	// open runs the query; rows are written while iterating the cursor
+	open func(ctx context.Context, h *Handlers, f exportFilter) (*mongo.Cursor, error)
+	row  func(cur *mongo.Cursor, f exportFilter) ([]interface{}, error)
+}
+
+// exportFilter holds the parsed query of an export request
+type exportFilter struct {
+	RukoIDs []primitive.ObjectID
+	Rukos   map[primitive.ObjectID]Ruko
+	From    *time.Time
+	To      *time.Time // exclusive
+	Status  string
+}
+
+func (f exportFilter) dateMatch(field string, m bson.M) {
+	r := bson.M{}
+	if f.From != nil {
+		r["$gte"] = *f.From
+	}
+	if f.To != nil {
+		r["$lt"] = *f.To
+	}
+	if len(r) > 0 {
+		m[field] = r
+	}
+}
+
+func (f exportFilter) rukoName(id primitive.ObjectID) string {
+	return f.Rukos[id].Name
+}
+
+// tenantLookup adds the tenant name of each document
+var tenantLookup = []bson.D{
+	{{Key: "$lookup", Value: bson.M{
+		"from":         "users",
+		"localField":   "tenant_id",
+		"foreignField": "_id",
+		"as":           "tenant",
+		"pipeline":     bson.A{bson.M{"$project": bson.M{"name": 1}}},
+	}}},
+	{{Key: "$set", Value: bson.M{"tenant_name": bson.M{"$ifNull": bson.A{bson.M{"$first": "$tenant.name"}, ""}}}}},
+}
+
+var exportDatasets = map[string]exportDataset{
+	"bookings": {
+		header: []string{"booking_id", "ruko_id", "ruko_name", "tenant_id", "tenant_name", "start_date", "end_date",
+			"total_price", "booking_status", "payment_status", "payment_method", "created_at"},
+		open: func(ctx context.Context, h *Handlers, f exportFilter) (*mongo.Cursor, error) {
+			match := bson.M{"ruko_id": bson.M{"$in": f.RukoIDs}}
+			f.dateMatch("created_at", match)
+			if f.Status != "" {
+				match["booking_status"] = f.Status
+			}
+			pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}, {{Key: "$sort", Value: bson.M{"created_at": 1}}}}
+			return h.db.Collection("bookings").Aggregate(ctx, append(pipeline, tenantLookup...))
+		},
+		row: func(cur *mongo.Cursor, f exportFilter) ([]interface{}, error) {
+			var b struct {
+				Booking    `bson:",inline"`
+				TenantName string `bson:"tenant_name"`
+			}
+			if err := cur.Decode(&b); err != nil {
+				return nil, err
+			}
+			return []interface{}{b.ID, b.RukoID, f.rukoName(b.RukoID), b.TenantID, b.TenantName, b.StartDate, b.EndDate,
+				b.TotalPrice, b.BookingStatus, b.PaymentStatus, b.PaymentMethod, b.CreatedAt}, nil
+		},
+	},
+	"payments": {
+		header: []string{"payment_id", "booking_id", "ruko_id", "ruko_name", "payment_method", "amount", "refunded_amount",
+			"status", "payment_date"},
+		open: func(ctx context.Context, h *Handlers, f exportFilter) (*mongo.Cursor, error) {
+			// payments only know their booking: match the owner's bookings first so the
+			// lookup runs on the owner's payments instead of every payment
+			bookings, err := h.repo.Bookings.Find(ctx, bson.M{"ruko_id": bson.M{"$in": f.RukoIDs}},
+				options.Find().SetProjection(bson.M{"_id": 1}))
+			if err != nil {
+				return nil, err
+			}
+			bookingIDs := make([]primitive.ObjectID, len(bookings))
+			for i, b := range bookings {
+				bookingIDs[i] = b.ID
+			}
+			match := bson.M{"booking_id": bson.M{"$in": bookingIDs}}
+			f.dateMatch("payment_date", match)
+			if f.Status != "" {
+				match["status"] = f.Status
+			}
+			return h.db.Collection("payments").Aggregate(ctx, mongo.Pipeline{
+				{{Key: "$match", Value: match}},
+				{{Key: "$lookup", Value: bson.M{"from": "bookings", "localField": "booking_id", "foreignField": "_id", "as": "booking",
+					"pipeline": bson.A{bson.M{"$project": bson.M{"ruko_id": 1}}}}}},
+				{{Key: "$set", Value: bson.M{"ruko_id": bson.M{"$first": "$booking.ruko_id"}}}},
+				{{Key: "$project", Value: bson.M{"booking": 0}}},
+				{{Key: "$sort", Value: bson.M{"payment_date": 1}}},
+			})
+		},
+		row: func(cur *mongo.Cursor, f exportFilter) ([]interface{}, error) {
+			var p struct {
+				Payment `bson:",inline"`
+				RukoID  primitive.ObjectID `bson:"ruko_id"`
+			}
+			if err := cur.Decode(&p); err != nil {
+				return nil, err
+			}
+			return []interface{}{p.ID, p.BookingID, p.RukoID, f.rukoName(p.RukoID), p.PaymentMethod, p.Amount, p.RefundedAmount,
+				p.Status, p.PaymentDate}, nil
+		},
+	},
+	"rental-history": {
+		header: []string{"rental_id", "ruko_id", "ruko_name", "tenant_id", "tenant_name", "start_date", "end_date",
+			"total_paid", "payment_method", "created_at"},
+		open: func(ctx context.Context, h *Handlers, f exportFilter) (*mongo.Cursor, error) {
+			match := bson.M{"ruko_id": bson.M{"$in": f.RukoIDs}}
+			f.dateMatch("start_date", match)
+			if f.Status != "" {
+				match["payment_method"] = f.Status
+			}
+			pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}, {{Key: "$sort", Value: bson.M{"start_date": 1}}}}
+			return h.db.Collection("rental_history").Aggregate(ctx, append(pipeline, tenantLookup...))
+		},
+		row: func(cur *mongo.Cursor, f exportFilter) ([]interface{}, error) {
+			var r struct {
+				RentalHistory `bson:",inline"`
+				TenantName    string `bson:"tenant_name"`
+			}
+			if err := cur.Decode(&r); err != nil {
+				return nil, err
+			}
+			return []interface{}{r.ID, r.RukoID, f.rukoName(r.RukoID), r.TenantID, r.TenantName, r.StartDate, r.EndDate,
+				r.TotalPaid, r.PaymentMethod, r.CreatedAt}, nil
+		},
+	},
+}
+
+// parseExportRange reads the optional ?from=YYYY-MM-DD&to=YYYY-MM-DD (to inclusive)
+func parseExportRange(c *gin.Context) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(incomeDateLayout, v)
+		if err != nil {
+			return nil, nil, errors.New("invalid from, use YYYY-MM-DD")
+		}
+		from = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(incomeDateLayout, v)
+		if err != nil {
+			return nil, nil, errors.New("invalid to, use YYYY-MM-DD")
+		}
+		t = t.AddDate(0, 0, 1)
+		to = &t
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		return nil, nil, errors.New("to must not be before from")
+	}
+	return from, to, nil
+}
+
+// helper: start the download with the right headers and writer
+func startExport(c *gin.Context, name, format string) (rowWriter, error) {
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == "xlsx" {
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		return newXLSXRowWriter(c.Writer, name)
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	return &csvRowWriter{w: csv.NewWriter(c.Writer)}, nil
+}
+
+func headerRow(cols []string) []interface{} {
+	row := make([]interface{}, len(cols))
+	for i, col := range cols {
+		row[i] = col
+	}
+	return row
+}
+
+// ExportOwnerData: GET /api/:ownerId/export/:dataset?format=csv|xlsx&from=&to=&status=&ruko_id=
+// dataset is bookings, payments, rental-history or income (income takes granularity/tz like GetIncomeData).
+// status filters booking_status / payment status (payment method for rental-history).
+func (h *Handlers) ExportOwnerData(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	name := c.Param("dataset")
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+	if name == "income" {
+		h.exportIncome(c, ownerOID, format)
+		return
+	}
+	ds, found := exportDatasets[name]
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown dataset"})
+		return
+	}
+	from, to, err := parseExportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rukos, rukoIDs, ok := h.ownerRukosFilter(c, ownerOID)
+	if !ok {
+		return
+	}
+	f := exportFilter{RukoIDs: rukoIDs, Rukos: map[primitive.ObjectID]Ruko{}, From: from, To: to, Status: c.Query("status")}
+	for _, r := range rukos {
+		f.Rukos[r.ID] = r
+	}
+
+	ctx := c.Request.Context()
+	cur, err := ds.open(ctx, h, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed query " + name})
+		return
+	}
+	defer cur.Close(ctx)
+
+	w, err := startExport(c, name, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create export"})
+		return
+	}
+	c.Status(http.StatusOK)
+	// the response has started, errors from here on can only be logged
+	if err := w.Write(headerRow(ds.header)); err != nil {
+		log.Println("export error:", err)
+		return
+	}
+	for cur.Next(ctx) {
+		row, err := ds.row(cur, f)
+		if err != nil {
+			log.Println("export decode error:", err)
+			continue
+		}
+		if err := w.Write(row); err != nil {
+			log.Println("export error:", err)
+			return
+		}
+	}
+	if err := cur.Err(); err != nil {
+		log.Println("export cursor error:", err)
+	}
+	if err := w.Close(); err != nil {
+		log.Println("export error:", err)
+	}
+}
+
+// exportIncome writes the cash and recognized income per ruko and period
+func (h *Handlers) exportIncome(c *gin.Context, ownerOID primitive.ObjectID, format string) {
+	q, err := parseIncomeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rukos, rukoIDs, ok := h.ownerRukosFilter(c, ownerOID)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	cash, err := cashIncome(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate payments"})
+		return
+	}
+	recognized, err := recognizedIncome(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate payments"})
+		return
+	}
+
+	w, err := startExport(c, "income", format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create export"})
+		return
+	}
+	c.Status(http.StatusOK)
+	rows := [][]interface{}{headerRow([]string{"period", "ruko_id", "ruko_name", "cash", "recognized"})}
+	for _, b := range q.buckets() {
+		for _, r := range rukos {
+			rows = append(rows, []interface{}{b, r.ID, r.Name, roundRupiah(cash[r.ID][b]), roundRupiah(recognized[r.ID][b])})
+		}
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			log.Println("export error:", err)
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Println("export error:", err)
+	}
+}
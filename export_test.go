@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestCSVExportQuotesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	w := &csvRowWriter{w: csv.NewWriter(&buf)}
+	row := []interface{}{"=HYPERLINK(\"http://x\")", "+62812", "-1", "@SUM(A1)", "Ruko Sudirman", -2.5}
+	if err := w.Write(row); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := csv.NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"'=HYPERLINK(\"http://x\")", "'+62812", "'-1", "'@SUM(A1)", "Ruko Sudirman", "-2.5"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cell %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
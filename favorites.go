@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// --- Favorites ---
+
+// ListFavorites: GET /api/favorites (rukos saved by current user)
+func (h *Handlers) ListFavorites(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	favs, err := h.repo.Favorites.Find(ctx, bson.M{"user_id": uid}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list favorites"})
+		return
+	}
+	ids := make([]primitive.ObjectID, len(favs))
+	for i, f := range favs {
+		ids[i] = f.RukoID
+	}
+	rukos, err := h.repo.Rukos.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list favorites"})
+		return
+	}
+	// keep favorites order
+	byID := make(map[primitive.ObjectID]Ruko, len(rukos))
+	for _, r := range rukos {
+		byID[r.ID] = r
+	}
+	out := []Ruko{}
+	for _, id := range ids {
+		if r, ok := byID[id]; ok {
+			out = append(out, r)
+		}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// AddFavorite: POST /api/favorites {"ruko_id": "..."}
+func (h *Handlers) AddFavorite(c *gin.Context) {
+	var in struct {
+		RukoID string `json:"ruko_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rukoOID, err := primitive.ObjectIDFromHex(in.RukoID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ruko_id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx := context.Background()
+	if n, _ := h.repo.Rukos.Count(ctx, bson.M{"_id": rukoOID}); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return
+	}
+	fav := Favorite{UserID: uid, RukoID: rukoOID, CreatedAt: time.Now()}
+	err = h.repo.Favorites.Create(ctx, &fav)
+	if err == ErrDuplicate {
+		c.JSON(http.StatusOK, gin.H{"message": "already in favorites"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed add favorite"})
+		return
+	}
+	c.JSON(http.StatusCreated, fav)
+}
+
+// RemoveFavorite: DELETE /api/favorites/:rukoId
+func (h *Handlers) RemoveFavorite(c *gin.Context) {
+	rukoOID, err := primitive.ObjectIDFromHex(c.Param("rukoId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ruko id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	err = h.repo.Favorites.Delete(context.Background(), bson.M{"user_id": uid, "ruko_id": rukoOID})
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "favorite not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed remove favorite"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "favorite removed"})
+}
+
+// --- Saved searches ---
+
+type savedSearchInput struct {
+	Name          string  `json:"name" binding:"required"`
+	City          string  `json:"city"`
+	MinPrice      float64 `json:"min_price"`
+	MaxPrice      float64 `json:"max_price"`
+	RentalType    string  `json:"rental_type"`
+	AlertsEnabled *bool   `json:"alerts_enabled"` // default true
+}
+
+func (in savedSearchInput) validate() error {
+	if in.MinPrice < 0 || in.MaxPrice < 0 {
+		return errors.New("price cannot be negative")
+	}
+	if in.MaxPrice > 0 && in.MinPrice > in.MaxPrice {
+		return errors.New("min_price cannot be greater than max_price")
+	}
+	if in.RentalType != "" && in.RentalType != "monthly" && in.RentalType != "yearly" {
+		return errors.New("rental_type must be monthly or yearly")
+	}
+	return nil
+}
+
+func (in savedSearchInput) apply(s *SavedSearch) {
+	s.Name = in.Name
+	s.City = strings.ToLower(strings.TrimSpace(in.City))
+	s.MinPrice = in.MinPrice
+	s.MaxPrice = in.MaxPrice
+	s.RentalType = in.RentalType
+	s.AlertsEnabled = in.AlertsEnabled == nil || *in.AlertsEnabled
+}
+
+// ListSavedSearches: GET /api/saved-searches
+func (h *Handlers) ListSavedSearches(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	out, err := h.repo.SavedSearches.Find(context.Background(), bson.M{"user_id": uid})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list saved searches"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// CreateSavedSearch: POST /api/saved-searches
+func (h *Handlers) CreateSavedSearch(c *gin.Context) {
+	var in savedSearchInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := in.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	now := time.Now()
+	s := SavedSearch{UserID: uid, CreatedAt: now, UpdatedAt: now}
+	in.apply(&s)
+	if err := h.repo.SavedSearches.Create(context.Background(), &s); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create saved search"})
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+// UpdateSavedSearch: PUT /api/saved-searches/:id
+func (h *Handlers) UpdateSavedSearch(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var in savedSearchInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := in.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx := context.Background()
+	s, err := h.repo.SavedSearches.FindOne(ctx, bson.M{"_id": oid, "user_id": uid})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "saved search not found"})
+		return
+	}
+	in.apply(&s)
+	s.UpdatedAt = time.Now()
+	err = h.repo.SavedSearches.Update(ctx, oid, bson.M{
+		"name":           s.Name,
+		"city":           s.City,
+		"min_price":      s.MinPrice,
+		"max_price":      s.MaxPrice,
+		"rental_type":    s.RentalType,
+		"alerts_enabled": s.AlertsEnabled,
+		"updated_at":     s.UpdatedAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update saved search"})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// DeleteSavedSearch: DELETE /api/saved-searches/:id
+func (h *Handlers) DeleteSavedSearch(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	err = h.repo.SavedSearches.Delete(context.Background(), bson.M{"_id": oid, "user_id": uid})
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "saved search not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed delete saved search"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "saved search deleted"})
+}
+
+// --- Matcher ---
+
+// matchSavedSearches queues an alert for every saved search matching a new or newly available ruko
+func (h *Handlers) matchSavedSearches(ctx context.Context, r Ruko) {
+	empty := []interface{}{nil, ""}
+	filter := bson.M{
+		"alerts_enabled": true,
+		"user_id":        bson.M{"$ne": r.OwnerID},
+		"$and": []bson.M{
+			{"city": bson.M{"$in": append(empty, strings.ToLower(strings.TrimSpace(r.City)))}},
+			{"rental_type": bson.M{"$in": append(empty, r.RentalType)}},
+			{"$or": []bson.M{{"min_price": bson.M{"$in": []interface{}{nil, 0}}}, {"min_price": bson.M{"$lte": r.Price}}}},
+			{"$or": []bson.M{{"max_price": bson.M{"$in": []interface{}{nil, 0}}}, {"max_price": bson.M{"$gte": r.Price}}}},
+		},
+	}
+	searches, err := h.repo.SavedSearches.Find(ctx, filter)
+	if err != nil {
+		log.Println("saved search matcher error:", err)
+		return
+	}
+	notified := map[primitive.ObjectID]bool{} // one alert per user even if several searches match
+	for _, s := range searches {
+		if notified[s.UserID] {
+			continue
+		}
+		notified[s.UserID] = true
+		h.notify(ctx, s.UserID, "saved_search.match",
+			"Ruko baru sesuai pencarian Anda",
+			fmt.Sprintf("%s di %s cocok dengan pencarian %q", r.Name, r.City, s.Name),
+			map[string]string{"ruko_id": r.ID.Hex(), "saved_search_id": s.ID.Hex()})
+	}
+}
+
+// notifyFavoritesAvailable alerts users who saved the ruko that it is available again
+func (h *Handlers) notifyFavoritesAvailable(ctx context.Context, r Ruko) {
+	favs, err := h.repo.Favorites.Find(ctx, bson.M{"ruko_id": r.ID})
+	if err != nil {
+		log.Println("favorites lookup error:", err)
+		return
+	}
+	for _, f := range favs {
+		h.notify(ctx, f.UserID, "favorite.available",
+			"Ruko favorit tersedia",
+			r.Name+" sekarang tersedia untuk disewa",
+			map[string]string{"ruko_id": r.ID.Hex()})
+	}
+}
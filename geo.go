@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultNearbyRadiusKm = 5.0
+	maxNearbyRadiusKm     = 100.0
+	defaultGeoLimit       = 50
+	maxGeoLimit           = 200
+)
+
+// RukoWithDistance is a ruko returned by the nearby search
+type RukoWithDistance struct {
+	Ruko       `bson:",inline"`
+	DistanceKm float64 `bson:"distance_km" json:"distance_km"`
+}
+
+func validLatLng(lat, lng float64) bool {
+	if lat == 0 && lng == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// helper: parse required float query param
+func queryFloat(c *gin.Context, key string) (float64, bool) {
+	v := c.Query(key)
+	if v == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+func geoLimit(c *gin.Context) int {
+	limit := defaultGeoLimit
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > maxGeoLimit {
+		limit = maxGeoLimit
+	}
+	return limit
+}
+
+// NearbyRuko: GET /api/ruko/nearby?lat=&lng=&radius_km=
+// returns rukos sorted by distance (closest first)
+func (h *Handlers) NearbyRuko(c *gin.Context) {
+	lat, okLat := queryFloat(c, "lat")
+	lng, okLng := queryFloat(c, "lng")
+	if !okLat || !okLng || !validLatLng(lat, lng) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid lat and lng are required"})
+		return
+	}
+	radius := defaultNearbyRadiusKm
+	if v := c.Query("radius_km"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid radius_km"})
+			return
+		}
+		radius = r
+	}
+	if radius > maxNearbyRadiusKm {
+		radius = maxNearbyRadiusKm
+	}
+
+	pipeline := []bson.M{
+		{"$geoNear": bson.M{
+			"near":               NewGeoPoint(lat, lng),
+			"distanceField":      "distance_km",
+			"distanceMultiplier": 0.001,
+			"maxDistance":        radius * 1000,
+			"spherical":          true,
+			"query":              notArchivedFilter(),
+		}},
+		{"$limit": geoLimit(c)},
+	}
+	cur, err := h.db.Collection("ruko").Aggregate(context.Background(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search nearby ruko"})
+		return
+	}
+	defer cur.Close(context.Background())
+	out := []RukoWithDistance{}
+	if err := cur.All(context.Background(), &out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// RukoWithinBounds: GET /api/ruko/within?min_lat=&min_lng=&max_lat=&max_lng=
+// bounding box search for the map view
+func (h *Handlers) RukoWithinBounds(c *gin.Context) {
+	minLat, ok1 := queryFloat(c, "min_lat")
+	minLng, ok2 := queryFloat(c, "min_lng")
+	maxLat, ok3 := queryFloat(c, "max_lat")
+	maxLng, ok4 := queryFloat(c, "max_lng")
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_lat, min_lng, max_lat and max_lng are required"})
+		return
+	}
+	if minLat < -90 || maxLat > 90 || minLng < -180 || maxLng > 180 || minLat >= maxLat || minLng >= maxLng {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bounding box"})
+		return
+	}
+
+	box := bson.M{
+		"type": "Polygon",
+		"coordinates": [][][]float64{{
+			{minLng, minLat},
+			{maxLng, minLat},
+			{maxLng, maxLat},
+			{minLng, maxLat},
+			{minLng, minLat},
+		}},
+	}
+	filter := notArchivedFilter()
+	filter["location"] = bson.M{"$geoWithin": bson.M{"$geometry": box}}
+	cur, err := h.db.Collection("ruko").Find(context.Background(), filter, options.Find().SetLimit(int64(geoLimit(c))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search ruko"})
+		return
+	}
+	defer cur.Close(context.Background())
+	out := []Ruko{}
+	if err := cur.All(context.Background(), &out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -16,11 +16,32 @@ import (
 
 // Handlers container
 type Handlers struct {
-	db *mongo.Database
+	db        *mongo.Database
+	repo      Repositories
+	storage   Storage
+	documents Storage // private files, e.g. signed contracts
+	notifier  *Notifier
+	events    EventBus
+	cache     *TTLCache
 }
 
 func NewHandlers(db *mongo.Database) *Handlers {
-	return &Handlers{db: db}
+	return NewHandlersWithRepositories(db, NewMongoRepositories(db))
+}
+
+// NewHandlersWithRepositories lets tests run the handlers on NewMemoryRepositories().
+// db may be nil: everything but the reports built from aggregations (income, analytics,
+// exports), the geo search and the bulk import works on the repos alone
+func NewHandlersWithRepositories(db *mongo.Database, repo Repositories) *Handlers {
+	return &Handlers{
+		db:        db,
+		repo:      repo,
+		storage:   NewStorageFromEnv(),
+		documents: NewDocumentStorageFromEnv(),
+		notifier:  NewNotifier(repo, channelsFromEnv()...),
+		events:    NewMemoryEventBus(),
+		cache:     analyticsCacheFromEnv(),
+	}
 }
 
 // middleware/json
@@ -56,12 +77,10 @@ func (h *Handlers) CreateUser(c *gin.Context) {
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
-	res, err := h.db.Collection("users").InsertOne(context.Background(), user)
-	if err != nil {
+	if err := h.repo.Users.Create(context.Background(), &user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create user: " + err.Error()})
 		return
 	}
-	user.ID = res.InsertedID.(primitive.ObjectID)
 	user.Password = "" // hide
 	c.JSON(http.StatusCreated, user)
 }
@@ -74,8 +93,8 @@ func (h *Handlers) GetUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	var user User
-	if err := h.db.Collection("users").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&user); err != nil {
+	user, err := h.repo.Users.Get(context.Background(), oid)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
@@ -87,27 +106,17 @@ func (h *Handlers) ListUsers(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := h.db.Collection("users").Find(ctx, bson.M{})
+	users, err := h.repo.Users.Find(ctx, bson.M{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var users []User
-	if err := cursor.All(ctx, &users); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode users"})
-		return
-	}
 
 	c.JSON(http.StatusOK, users)
 }
 
 // CreateRuko
 func (h *Handlers) CreateRuko(c *gin.Context) {
-	collections, _ := h.db.ListCollectionNames(context.Background(), bson.D{})
-	log.Println("Collections:", collections)
-
 	var in struct {
 		OwnerID         string  `json:"owner_id" binding:"required"`
 		Name            string  `json:"name" binding:"required"`
@@ -120,6 +129,7 @@ func (h *Handlers) CreateRuko(c *gin.Context) {
 		DiscountPercent float64 `json:"discount_percent"`
 		RentalType      string  `json:"rental_type" binding:"required"`
 		Image           string  `json:"image"`
+		rukoAttributesInput
 	}
 	if err := c.ShouldBindJSON(&in); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -130,6 +140,10 @@ func (h *Handlers) CreateRuko(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner_id"})
 		return
 	}
+	if err := validateRukoFields(in.Price, in.DiscountPercent, in.RentalType, in.Latitude, in.Longitude); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	now := time.Now()
 	r := Ruko{
 		OwnerID:         oid,
@@ -148,27 +162,37 @@ func (h *Handlers) CreateRuko(c *gin.Context) {
 		CreatedAt:       now,
 		UpdatedAt:       now,
 	}
-	res, err := h.db.Collection("ruko").InsertOne(context.Background(), r)
-	if err != nil {
+	if validLatLng(in.Latitude, in.Longitude) {
+		r.Location = NewGeoPoint(in.Latitude, in.Longitude)
+	}
+	in.rukoAttributesInput.apply(&r, nil)
+	if err := validateRukoAttributes(r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.repo.Rukos.Create(context.Background(), &r); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create ruko"})
 		return
 	}
-	fmt.Println("Inserted Ruko ID:", res.InsertedID)
-	r.ID = res.InsertedID.(primitive.ObjectID)
+	setAuditTarget(c, r.ID)
+
+	// alert tenants with matching saved searches
+	h.matchSavedSearches(context.Background(), r)
+	h.publishRukoEvent(context.Background(), r.ID, "ruko.created", nil)
+
 	c.JSON(http.StatusCreated, r)
 }
 
 // ListRuko
 func (h *Handlers) ListRuko(c *gin.Context) {
-	cur, err := h.db.Collection("ruko").Find(context.Background(), bson.M{})
+	filter, err := rukoListFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list ruko"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer cur.Close(context.Background())
-	var results []Ruko
-	if err := cur.All(context.Background(), &results); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "read cursor error"})
+	results, err := h.repo.Rukos.Find(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list ruko"})
 		return
 	}
 	c.JSON(http.StatusOK, results)
@@ -182,31 +206,14 @@ func (h *Handlers) GetRuko(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	var r Ruko
-	if err := h.db.Collection("ruko").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&r); err != nil {
+	r, err := h.repo.Rukos.Get(context.Background(), oid)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
 		return
 	}
 	c.JSON(http.StatusOK, r)
 }
 
-// MarkRukoRentedOffline (owner marks ruko as rented offline)
-func (h *Handlers) MarkRukoRentedOffline(c *gin.Context) {
-	id := c.Param("id")
-	oid, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-	update := bson.M{"$set": bson.M{"rented_offline": true, "is_available": false, "updated_at": time.Now()}}
-	_, err = h.db.Collection("ruko").UpdateByID(context.Background(), oid, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update ruko"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "ruko marked as rented offline"})
-}
-
 // CreateBooking
 func (h *Handlers) CreateBooking(c *gin.Context) {
 	var in struct {
@@ -230,11 +237,25 @@ func (h *Handlers) CreateBooking(c *gin.Context) {
 	endDate, _ := time.Parse("2006-01-02", in.EndDateStr)
 
 	// ambil ruko
-	var r Ruko
-	if err := h.db.Collection("ruko").FindOne(context.Background(), bson.M{"_id": rukoOID}).Decode(&r); err != nil {
+	r, err := h.repo.Rukos.Get(context.Background(), rukoOID)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ruko not found"})
 		return
 	}
+	if r.Archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ruko is no longer listed"})
+		return
+	}
+	// the owner may have rented the period out offline
+	offline, err := h.offlineRentalConflict(context.Background(), rukoOID, startDate, endDate, primitive.NilObjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check rentals"})
+		return
+	}
+	if offline != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko is not available in that period"})
+		return
+	}
 
 	// HITUNG duration
 	months := calculateMonthsBetween(startDate, endDate)
@@ -252,25 +273,7 @@ func (h *Handlers) CreateBooking(c *gin.Context) {
 		}
 	}
 
-	// hitung subtotal
-	var subtotal float64
-	if r.RentalType == "monthly" {
-		subtotal = r.Price * float64(months)
-	} else {
-		subtotal = r.Price * float64(years)
-	}
-
-	// tax 10%
-	tax := subtotal * 0.10
-
-	// discount
-	var discountPercent float64 = 0
-	if strings.ToUpper(in.DiscountCode) == "PROMO10" {
-		discountPercent = 0.10
-	}
-
-	discountAmount := subtotal * discountPercent
-	total := subtotal + tax - discountAmount
+	pricing := calculateBookingPrice(r, startDate, endDate, in.DiscountCode)
 
 	now := time.Now()
 
@@ -279,7 +282,8 @@ func (h *Handlers) CreateBooking(c *gin.Context) {
 		TenantID:      tenantOID,
 		StartDate:     startDate,
 		EndDate:       endDate,
-		TotalPrice:    total,
+		TotalPrice:    pricing.Total,
+		Pricing:       &pricing,
 		PaymentStatus: "pending",
 		BookingStatus: "waiting",
 		PaymentMethod: in.PaymentMethod,
@@ -287,24 +291,53 @@ func (h *Handlers) CreateBooking(c *gin.Context) {
 		UpdatedAt:     now,
 	}
 
-	res, err := h.db.Collection("bookings").InsertOne(context.Background(), booking)
-	if err != nil {
+	if err := h.repo.Bookings.Create(context.Background(), &booking); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create booking"})
 		return
 	}
-	booking.ID = res.InsertedID.(primitive.ObjectID)
+	setAuditTarget(c, booking.ID)
 
 	// LOCK
-	_, _ = h.db.Collection("ruko").UpdateByID(context.Background(), rukoOID, bson.M{
-		"$set": bson.M{
-			"is_available": false,
-			"updated_at":   time.Now(),
-		},
+	_ = h.repo.Rukos.Update(context.Background(), rukoOID, bson.M{
+		"is_available": false,
+		"updated_at":   time.Now(),
 	})
 
+	h.ensureBookingInvoice(context.Background(), booking)
+
+	h.notify(context.Background(), r.OwnerID, "booking.created",
+		"Booking baru",
+		fmt.Sprintf("Ada booking baru untuk %s (%s s/d %s)", r.Name, startDate.Format("2006-01-02"), endDate.Format("2006-01-02")),
+		map[string]string{"booking_id": booking.ID.Hex(), "ruko_id": r.ID.Hex()})
+	h.publishRukoEvent(context.Background(), r.ID, "booking.created", map[string]interface{}{"booking": booking})
+	h.publishAvailability(context.Background(), r.ID, false)
+
 	c.JSON(http.StatusCreated, booking)
 }
 
+// bookingTaxRate is the tax charged on the rent subtotal
+const bookingTaxRate = 0.10
+
+// calculateBookingPrice returns the price breakdown of renting r between start and end
+func calculateBookingPrice(r Ruko, start, end time.Time, discountCode string) BookingPricing {
+	p := BookingPricing{Unit: "month", Quantity: calculateMonthsBetween(start, end), UnitPrice: r.Price, TaxRate: bookingTaxRate}
+	if r.RentalType == "yearly" {
+		p.Unit = "year"
+		p.Quantity = calculateYearsBetween(start, end)
+	}
+	p.Subtotal = r.Price * float64(p.Quantity)
+	p.Tax = p.Subtotal * p.TaxRate
+
+	// discount
+	if strings.ToUpper(discountCode) == "PROMO10" {
+		p.DiscountCode = "PROMO10"
+		p.DiscountPercent = 0.10
+	}
+	p.Discount = p.Subtotal * p.DiscountPercent
+	p.Total = p.Subtotal + p.Tax - p.Discount
+	return p
+}
+
 func calculateYearsBetween(a, b time.Time) int {
 	ay, _, _ := a.Date()
 	by, _, _ := b.Date()
@@ -329,8 +362,8 @@ func calculateMonthsBetween(a, b time.Time) int {
 func (h *Handlers) GetBooking(c *gin.Context) {
 	id := c.Param("id")
 	oid, _ := primitive.ObjectIDFromHex(id)
-	var b Booking
-	if err := h.db.Collection("bookings").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&b); err != nil {
+	b, err := h.repo.Bookings.Get(context.Background(), oid)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
 		return
 	}
@@ -346,24 +379,117 @@ func (h *Handlers) ListBookings(c *gin.Context) {
 			filter["tenant_id"] = oid
 		}
 	}
-	cur, err := h.db.Collection("bookings").Find(context.Background(), filter)
+	out, err := h.repo.Bookings.Find(context.Background(), filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list bookings"})
 		return
 	}
-	defer cur.Close(context.Background())
-	var out []Booking
-	if err := cur.All(context.Background(), &out); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "read cursor failed"})
-		return
-	}
 	c.JSON(http.StatusOK, out)
 }
 
+// confirmBookingPaid applies a confirmed payment inside a transaction: the booking waits for
+// signatures (unless the contract is already signed) and is paid once nothing is outstanding,
+// the rental history is created or updated, the ruko is taken off the market and the
+// payment (if any) is stored and posted to the ledger
+func (h *Handlers) confirmBookingPaid(sc mongo.SessionContext, b Booking, set bson.M, historyMethod string, p *Payment) error {
+	now := time.Now()
+	if p != nil {
+		p.ID = primitive.NilObjectID
+		if err := h.repo.Payments.Create(sc, p); err != nil {
+			return fmt.Errorf("insert payment: %w", err)
+		}
+	}
+
+	// a contract both parties signed before the payment keeps the booking confirmed,
+	// signing again is not possible
+	set["booking_status"] = "awaiting_signature"
+	ct, err := h.latestContract(sc, b.ID)
+	if err != nil {
+		return fmt.Errorf("load contract: %w", err)
+	}
+	if ct != nil && ct.fullySigned() {
+		set["booking_status"] = "confirmed"
+	}
+	paid, err := h.bookingPaidAmount(sc, b.ID)
+	if err != nil {
+		return fmt.Errorf("load payments: %w", err)
+	}
+	// installments leave the booking partially paid until nothing is outstanding
+	// (p is nil when the invoices were already settled); 1 rupiah covers invoice rounding
+	set["payment_status"] = "partial"
+	if p == nil || paid+1 >= b.TotalPrice {
+		set["payment_status"] = "paid"
+	}
+	set["updated_at"] = now
+	if err := h.repo.Bookings.Update(sc, b.ID, set); err != nil {
+		return fmt.Errorf("update booking: %w", err)
+	}
+	if err := h.markBookingAccepted(sc, b.ID); err != nil {
+		return fmt.Errorf("update booking: %w", err)
+	}
+
+	// one rental history per booking, later installments only update the amount paid
+	bookingID := b.ID
+	rHistory, err := h.repo.RentalHistory.FindOne(sc, bson.M{"$or": []bson.M{
+		{"booking_id": b.ID},
+		// entries created before booking_id was stored
+		{"booking_id": bson.M{"$exists": false}, "source": bson.M{"$exists": false}, "ruko_id": b.RukoID, "tenant_id": b.TenantID, "start_date": b.StartDate},
+	}})
+	switch {
+	case err == ErrNotFound:
+		rHistory = RentalHistory{
+			RukoID:        b.RukoID,
+			TenantID:      b.TenantID,
+			BookingID:     &bookingID,
+			StartDate:     b.StartDate,
+			EndDate:       b.EndDate,
+			TotalPaid:     paid,
+			PaymentMethod: historyMethod,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := h.repo.RentalHistory.Create(sc, &rHistory); err != nil {
+			return fmt.Errorf("insert rental history: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("load rental history: %w", err)
+	default:
+		err := h.repo.RentalHistory.Update(sc, rHistory.ID, bson.M{"booking_id": bookingID, "total_paid": paid, "updated_at": now})
+		if err != nil {
+			return fmt.Errorf("update rental history: %w", err)
+		}
+	}
+	if err := h.repo.Rukos.Update(sc, b.RukoID, bson.M{"is_available": false, "updated_at": now}); err != nil {
+		return fmt.Errorf("update ruko: %w", err)
+	}
+	if p != nil && p.Status == "confirmed" {
+		if err := recordPaymentLedger(sc, h.repo, *p); err != nil {
+			return fmt.Errorf("post ledger: %w", err)
+		}
+	}
+	return nil
+}
+
+// helper: confirmed payments of a booking, net of refunds
+func (h *Handlers) bookingPaidAmount(ctx context.Context, bookingID primitive.ObjectID) (float64, error) {
+	payments, err := h.repo.Payments.Find(ctx, bson.M{"booking_id": bookingID, "status": bson.M{"$in": incomePaymentStatuses}})
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, p := range payments {
+		total += p.Amount - p.RefundedAmount
+	}
+	return total, nil
+}
+
 // ConfirmBookingOffline (owner/admin verifies payment & confirms booking)
 func (h *Handlers) ConfirmBookingOffline(c *gin.Context) {
-	id := c.Param("id")
-	bookingOID, _ := primitive.ObjectIDFromHex(id)
+	bookingOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
 	var in struct {
 		VerifierID string `json:"verifier_id" binding:"required"`
 	}
@@ -371,36 +497,67 @@ func (h *Handlers) ConfirmBookingOffline(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	verifierOID, _ := primitive.ObjectIDFromHex(in.VerifierID)
+	verifierOID, err := primitive.ObjectIDFromHex(in.VerifierID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid verifier_id"})
+		return
+	}
+
+	ctx := context.Background()
+	booking, err := h.repo.Bookings.Get(ctx, bookingOID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if booking.PaymentStatus == "paid" {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking is already paid"})
+		return
+	}
 
-	// update booking status
-	_, err := h.db.Collection("bookings").UpdateByID(context.Background(), bookingOID, bson.M{
-		"$set": bson.M{"booking_status": "confirmed", "payment_status": "paid", "offline_verified_by": verifierOID, "updated_at": time.Now()},
+	// the cash payment covers what is still open on the invoices
+	h.ensureBookingInvoice(ctx, booking)
+	amount, err := h.outstandingAmount(ctx, bookingOID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load invoices"})
+		return
+	}
+	var p *Payment
+	if amount > 0 {
+		now := time.Now()
+		p = &Payment{
+			BookingID:     bookingOID,
+			PaymentMethod: "cash",
+			Amount:        amount,
+			PaymentDate:   now,
+			Status:        "confirmed",
+			ConfirmedBy:   &verifierOID,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+	}
+	err = h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		return h.confirmBookingPaid(sc, booking, bson.M{"offline_verified_by": verifierOID}, "offline", p)
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update booking"})
+		log.Println("confirm booking offline error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed confirm booking, nothing was saved"})
 		return
 	}
 
-	// create rental_history entry
-	var booking Booking
-	_ = h.db.Collection("bookings").FindOne(context.Background(), bson.M{"_id": bookingOID}).Decode(&booking)
-	rHistory := RentalHistory{
-		RukoID:        booking.RukoID,
-		TenantID:      booking.TenantID,
-		StartDate:     booking.StartDate,
-		EndDate:       booking.EndDate,
-		TotalPaid:     booking.TotalPrice,
-		PaymentMethod: "offline",
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	// receipts and contract are idempotent and can be regenerated, so they run after the commit
+	if p != nil {
+		h.settleInvoices(ctx, booking, *p)
 	}
-	_, _ = h.db.Collection("rental_history").InsertOne(context.Background(), rHistory)
+	h.ensureContract(ctx, bookingOID)
 
-	// mark ruko as not available
-	_, _ = h.db.Collection("ruko").UpdateByID(context.Background(), booking.RukoID, bson.M{"$set": bson.M{"is_available": false, "updated_at": time.Now()}})
+	h.notify(ctx, booking.TenantID, "booking.verified",
+		"Pembayaran diverifikasi",
+		"Pembayaran offline Anda telah diverifikasi, silakan tanda tangani kontrak sewa",
+		map[string]string{"booking_id": bookingOID.Hex(), "ruko_id": booking.RukoID.Hex()})
+	h.publishRukoEvent(ctx, booking.RukoID, "booking.verified", map[string]interface{}{"booking_id": bookingOID.Hex()})
+	h.publishAvailability(ctx, booking.RukoID, false)
 
-	c.JSON(http.StatusOK, gin.H{"message": "booking confirmed offline and rental history created"})
+	c.JSON(http.StatusOK, gin.H{"message": "payment verified offline and rental history created, waiting for contract signatures"})
 }
 
 // CreatePayment
@@ -417,14 +574,33 @@ func (h *Handlers) CreatePayment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	bid, _ := primitive.ObjectIDFromHex(in.BookingID)
+	bid, err := primitive.ObjectIDFromHex(in.BookingID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking_id"})
+		return
+	}
 	now := time.Now()
 	var confirmedBy *primitive.ObjectID
 	if in.ConfirmedBy != "" {
-		oid, _ := primitive.ObjectIDFromHex(in.ConfirmedBy)
+		oid, err := primitive.ObjectIDFromHex(in.ConfirmedBy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid confirmed_by"})
+			return
+		}
 		confirmedBy = &oid
 	}
 
+	ctx := context.Background()
+	booking, err := h.repo.Bookings.Get(ctx, bid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
+	}
+	if booking.BookingStatus == "cancelled" || booking.BookingStatus == "rejected" {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking is " + booking.BookingStatus})
+		return
+	}
+
 	p := Payment{
 		BookingID:     bid,
 		PaymentMethod: in.PaymentMethod,
@@ -436,33 +612,38 @@ func (h *Handlers) CreatePayment(c *gin.Context) {
 		CreatedAt:     now,
 		UpdatedAt:     now,
 	}
-	res, err := h.db.Collection("payments").InsertOne(context.Background(), p)
+	if p.Status == "confirmed" {
+		err = h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+			return h.confirmBookingPaid(sc, booking, bson.M{}, in.PaymentMethod, &p)
+		})
+	} else {
+		err = h.repo.Payments.Create(ctx, &p)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create payment"})
+		log.Println("create payment error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create payment, nothing was saved"})
 		return
 	}
-	p.ID = res.InsertedID.(primitive.ObjectID)
+	setAuditTarget(c, p.ID)
 
-	// If confirmed, update booking payment_status
 	if p.Status == "confirmed" {
-		_, _ = h.db.Collection("bookings").UpdateByID(context.Background(), bid, bson.M{"$set": bson.M{"payment_status": "paid", "booking_status": "confirmed", "updated_at": time.Now()}})
-		// create rental history and mark ruko unavailable
-		var booking Booking
-		_ = h.db.Collection("bookings").FindOne(context.Background(), bson.M{"_id": bid}).Decode(&booking)
-		rHistory := RentalHistory{
-			RukoID:        booking.RukoID,
-			TenantID:      booking.TenantID,
-			StartDate:     booking.StartDate,
-			EndDate:       booking.EndDate,
-			TotalPaid:     booking.TotalPrice,
-			PaymentMethod: in.PaymentMethod,
-			CreatedAt:     time.Now(),
-			UpdatedAt:     time.Now(),
-		}
-		_, _ = h.db.Collection("rental_history").InsertOne(context.Background(), rHistory)
-		_, _ = h.db.Collection("ruko").UpdateByID(context.Background(), booking.RukoID, bson.M{"$set": bson.M{"is_available": false, "updated_at": time.Now()}})
+		h.settleInvoices(ctx, booking, p)
+		h.ensureContract(ctx, bid)
+
+		h.notify(ctx, booking.TenantID, "payment.confirmed",
+			"Pembayaran dikonfirmasi",
+			fmt.Sprintf("Pembayaran sebesar %.0f telah dikonfirmasi", p.Amount),
+			map[string]string{"booking_id": bid.Hex(), "payment_id": p.ID.Hex()})
+		h.publishRukoEvent(ctx, booking.RukoID, "payment.confirmed", map[string]interface{}{"payment": p})
+		h.publishAvailability(ctx, booking.RukoID, false)
 	}
 
+	h.notifyRukoOwner(ctx, booking.RukoID, "payment.created",
+		"Pembayaran baru",
+		fmt.Sprintf("Pembayaran %s sebesar %.0f diterima (status: %s)", p.PaymentMethod, p.Amount, p.Status),
+		map[string]string{"booking_id": bid.Hex(), "payment_id": p.ID.Hex()})
+	h.publishRukoEvent(ctx, booking.RukoID, "payment.created", map[string]interface{}{"payment": p})
+
 	c.JSON(http.StatusCreated, p)
 }
 
@@ -470,8 +651,8 @@ func (h *Handlers) CreatePayment(c *gin.Context) {
 func (h *Handlers) GetPayment(c *gin.Context) {
 	id := c.Param("id")
 	oid, _ := primitive.ObjectIDFromHex(id)
-	var p Payment
-	if err := h.db.Collection("payments").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&p); err != nil {
+	p, err := h.repo.Payments.Get(context.Background(), oid)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
 		return
 	}
@@ -513,13 +694,14 @@ func (h *Handlers) CreateDiscount(c *gin.Context) {
 		UpdatedAt: time.Now(),
 	}
 
-	res, err := h.db.Collection("discounts").InsertOne(context.Background(), d)
-	if err != nil {
+	if err := h.repo.Discounts.Create(context.Background(), &d); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create discount"})
 		return
 	}
 
-	d.ID = res.InsertedID.(primitive.ObjectID)
+	setAuditTarget(c, d.ID)
+	h.publishRukoEvent(context.Background(), d.RukoID, "discount.created",
+		map[string]interface{}{"discount_id": d.ID.Hex(), "percent": d.Percent})
 	c.JSON(http.StatusCreated, d)
 }
 
@@ -532,18 +714,11 @@ func (h *Handlers) ListDiscounts(c *gin.Context) {
 		"active":   true,
 	}
 
-	cur, err := h.db.Collection("discounts").Find(context.Background(), filter)
+	out, err := h.repo.Discounts.Find(context.Background(), filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list discounts"})
 		return
 	}
-	defer cur.Close(context.Background())
-
-	var out []Discount
-	if err := cur.All(context.Background(), &out); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "read fail"})
-		return
-	}
 	c.JSON(http.StatusOK, out)
 }
 
@@ -556,17 +731,11 @@ func (h *Handlers) ListRentalHistory(c *gin.Context) {
 			filter["tenant_id"] = oid
 		}
 	}
-	cur, err := h.db.Collection("rental_history").Find(context.Background(), filter)
+	out, err := h.repo.RentalHistory.Find(context.Background(), filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list rental history"})
 		return
 	}
-	defer cur.Close(context.Background())
-	var out []RentalHistory
-	if err := cur.All(context.Background(), &out); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cursor read fail"})
-		return
-	}
 	c.JSON(http.StatusOK, out)
 }
 
@@ -600,21 +769,18 @@ func (h *Handlers) Register(c *gin.Context) {
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
-	res, err := h.db.Collection("users").InsertOne(context.Background(), user)
-	if err != nil {
+	if err := h.repo.Users.Create(context.Background(), &user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create user: " + err.Error()})
 		return
 	}
-	uid := res.InsertedID.(primitive.ObjectID)
 
 	// generate token
-	token, exp, err := GenerateToken(uid, user.Role)
+	token, exp, err := GenerateToken(user.ID, user.Role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed generate token"})
 		return
 	}
 	// hide password
-	user.ID = uid
 	user.Password = ""
 	c.JSON(http.StatusCreated, gin.H{
 		"user":         user,
@@ -633,8 +799,8 @@ func (h *Handlers) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var user User
-	if err := h.db.Collection("users").FindOne(context.Background(), bson.M{"email": in.Email}).Decode(&user); err != nil {
+	user, err := h.repo.Users.FindOne(context.Background(), bson.M{"email": in.Email})
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
@@ -657,34 +823,34 @@ func (h *Handlers) Login(c *gin.Context) {
 
 // --- Owner Stats ---
 func (h *Handlers) GetOwnerStats(c *gin.Context) {
-	ownerId := c.Param("ownerId")
-	oid, _ := primitive.ObjectIDFromHex(ownerId)
+	oid, ok := ownerParam(c)
+	if !ok {
+		return
+	}
 
 	// contoh: total rukos, total bookings, total income
-	totalRukos, _ := h.db.Collection("ruko").CountDocuments(context.Background(), bson.M{"owner_id": oid})
-	totalBookings, _ := h.db.Collection("bookings").CountDocuments(context.Background(), bson.M{"ruko_id": bson.M{"$in": getOwnerRukoIDs(h, oid)}})
+	totalRukos, _ := h.repo.Rukos.Count(context.Background(), bson.M{"owner_id": oid})
+	totalBookings, _ := h.repo.Bookings.Count(context.Background(), bson.M{"ruko_id": bson.M{"$in": getOwnerRukoIDs(h, oid)}})
 
-	// total income
-	cursor, _ := h.db.Collection("bookings").Find(context.Background(), bson.M{"ruko_id": bson.M{"$in": getOwnerRukoIDs(h, oid)}, "booking_status": "confirmed"})
-	var bookings []Booking
-	cursor.All(context.Background(), &bookings)
-	var totalIncome float64
-	for _, b := range bookings {
-		totalIncome += b.TotalPrice
+	// total income: owner share of the payments recorded in the ledger
+	balance, err := ownerBalance(context.Background(), h.repo, oid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load balance"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"total_rukos":    totalRukos,
-		"total_bookings": totalBookings,
-		"total_income":   totalIncome,
+		"total_rukos":     totalRukos,
+		"total_bookings":  totalBookings,
+		"total_income":    balance.TotalIncome,
+		"platform_fee":    balance.PlatformFee,
+		"payable_balance": balance.Payable,
 	})
 }
 
 // helper: get ruko IDs owned by owner
 func getOwnerRukoIDs(h *Handlers, ownerOID primitive.ObjectID) []primitive.ObjectID {
-	cursor, _ := h.db.Collection("ruko").Find(context.Background(), bson.M{"owner_id": ownerOID})
-	var rukos []Ruko
-	cursor.All(context.Background(), &rukos)
+	rukos, _ := h.repo.Rukos.Find(context.Background(), bson.M{"owner_id": ownerOID})
 	ids := make([]primitive.ObjectID, len(rukos))
 	for i, r := range rukos {
 		ids[i] = r.ID
@@ -696,9 +862,7 @@ func getOwnerRukoIDs(h *Handlers, ownerOID primitive.ObjectID) []primitive.Objec
 func (h *Handlers) GetOwnerRukos(c *gin.Context) {
 	ownerId := c.Param("ownerId")
 	oid, _ := primitive.ObjectIDFromHex(ownerId)
-	cursor, _ := h.db.Collection("ruko").Find(context.Background(), bson.M{"owner_id": oid})
-	var rukos []Ruko
-	cursor.All(context.Background(), &rukos)
+	rukos, _ := h.repo.Rukos.Find(context.Background(), bson.M{"owner_id": oid})
 	c.JSON(http.StatusOK, rukos)
 }
 
@@ -707,9 +871,7 @@ func (h *Handlers) GetPendingBookings(c *gin.Context) {
 	ownerId := c.Param("ownerId")
 	oid, _ := primitive.ObjectIDFromHex(ownerId)
 	rukoIDs := getOwnerRukoIDs(h, oid)
-	cursor, _ := h.db.Collection("bookings").Find(context.Background(), bson.M{"ruko_id": bson.M{"$in": rukoIDs}, "booking_status": "waiting"})
-	var bookings []Booking
-	cursor.All(context.Background(), &bookings)
+	bookings, _ := h.repo.Bookings.Find(context.Background(), bson.M{"ruko_id": bson.M{"$in": rukoIDs}, "booking_status": "waiting"})
 	c.JSON(http.StatusOK, bookings)
 }
 
@@ -718,85 +880,145 @@ func (h *Handlers) GetAllBookings(c *gin.Context) {
 	ownerId := c.Param("ownerId")
 	oid, _ := primitive.ObjectIDFromHex(ownerId)
 	rukoIDs := getOwnerRukoIDs(h, oid)
-	cursor, _ := h.db.Collection("bookings").Find(context.Background(), bson.M{"ruko_id": bson.M{"$in": rukoIDs}})
-	var bookings []Booking
-	cursor.All(context.Background(), &bookings)
+	bookings, _ := h.repo.Bookings.Find(context.Background(), bson.M{"ruko_id": bson.M{"$in": rukoIDs}})
 	c.JSON(http.StatusOK, bookings)
 }
 
-// --- Accept Booking ---
+// helper: load booking :id and make sure the current user owns its ruko (admin can access all)
+func (h *Handlers) findOwnedBooking(c *gin.Context) (Booking, bool) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return Booking{}, false
+	}
+	ctx := context.Background()
+	b, err := h.repo.Bookings.Get(ctx, oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return b, false
+	}
+	if c.GetString("role") == "admin" {
+		return b, true
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return b, false
+	}
+	r, err := h.repo.Rukos.Get(ctx, b.RukoID)
+	if err != nil || r.OwnerID != uid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not the owner of this ruko"})
+		return b, false
+	}
+	return b, true
+}
+
+// AcceptBooking: PUT /api/bookings/:id/accept (owner of the ruko, only while the booking is waiting)
 func (h *Handlers) AcceptBooking(c *gin.Context) {
-	bookingId := c.Param("id")
-	oid, _ := primitive.ObjectIDFromHex(bookingId)
-	_, err := h.db.Collection("bookings").UpdateOne(context.Background(), bson.M{"_id": oid},
-		bson.M{"$set": bson.M{"booking_status": "confirmed", "updated_at": time.Now()}})
+	b, ok := h.findOwnedBooking(c)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	err := h.repo.Bookings.UpdateWhere(ctx, bson.M{"_id": b.ID, "booking_status": "waiting"},
+		bson.M{"booking_status": "awaiting_signature", "updated_at": time.Now()})
+	if err == ErrNotFound {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking cannot be accepted in status " + b.BookingStatus})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept booking"})
 		return
 	}
+	if err := h.markBookingAccepted(ctx, b.ID); err != nil {
+		log.Println("mark booking accepted error:", err)
+	}
+	h.ensureContract(ctx, b.ID)
+
+	h.notify(ctx, b.TenantID, "booking.accepted",
+		"Booking diterima",
+		"Booking Anda telah diterima oleh pemilik ruko, silakan tanda tangani kontrak sewa",
+		map[string]string{"booking_id": b.ID.Hex(), "ruko_id": b.RukoID.Hex()})
+	h.publishRukoEvent(ctx, b.RukoID, "booking.accepted", map[string]interface{}{"booking_id": b.ID.Hex()})
 	c.JSON(http.StatusOK, gin.H{"message": "booking accepted"})
 }
 
-// --- Reject Booking ---
+// RejectBooking: PUT /api/bookings/:id/reject (owner of the ruko, only while the booking is waiting)
 func (h *Handlers) RejectBooking(c *gin.Context) {
-	bookingId := c.Param("id")
-	oid, _ := primitive.ObjectIDFromHex(bookingId)
-	_, err := h.db.Collection("bookings").UpdateOne(context.Background(), bson.M{"_id": oid},
-		bson.M{"$set": bson.M{"booking_status": "rejected", "updated_at": time.Now()}})
+	b, ok := h.findOwnedBooking(c)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	now := time.Now()
+	err := h.repo.Bookings.UpdateWhere(ctx, bson.M{"_id": b.ID, "booking_status": "waiting"},
+		bson.M{"booking_status": "rejected", "rejected_at": now, "updated_at": now})
+	if err == ErrNotFound {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking cannot be rejected in status " + b.BookingStatus})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reject booking"})
 		return
 	}
+
+	h.notify(ctx, b.TenantID, "booking.rejected",
+		"Booking ditolak",
+		"Maaf, booking Anda ditolak oleh pemilik ruko",
+		map[string]string{"booking_id": b.ID.Hex(), "ruko_id": b.RukoID.Hex()})
+	h.publishRukoEvent(ctx, b.RukoID, "booking.rejected", map[string]interface{}{"booking_id": b.ID.Hex()})
+	// booking no longer holds the ruko
+	h.releaseRukoIfFree(ctx, b.RukoID)
 	c.JSON(http.StatusOK, gin.H{"message": "booking rejected"})
 }
 
-// --- Income Data ---
-func (h *Handlers) GetIncomeData(c *gin.Context) {
-	ownerId := c.Param("ownerId")
-	period := c.Query("period") // e.g., "2025-11" (YYYY-MM)
-	oid, _ := primitive.ObjectIDFromHex(ownerId)
-	rukoIDs := getOwnerRukoIDs(h, oid)
-
-	// ambil bookings sesuai period
-	cursor, _ := h.db.Collection("bookings").Find(context.Background(), bson.M{
-		"ruko_id":        bson.M{"$in": rukoIDs},
-		"booking_status": "confirmed",
-		"start_date":     bson.M{"$gte": parsePeriodStart(period)},
-		"end_date":       bson.M{"$lte": parsePeriodEnd(period)},
-	})
-	var bookings []Booking
-	cursor.All(context.Background(), &bookings)
-	var total float64
-	for _, b := range bookings {
-		total += b.TotalPrice
+// CancelBooking: PUT /api/bookings/:id/cancel
+// the tenant (or an admin) withdraws a booking before anything is paid; paid bookings go through a refund
+func (h *Handlers) CancelBooking(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"income": total})
-}
-
-func parsePeriodStart(period string) time.Time {
-	t, _ := time.Parse("2006-01", period)
-	return t
-}
-
-func parsePeriodEnd(period string) time.Time {
-	t, _ := time.Parse("2006-01", period)
-	return t.AddDate(0, 1, -1)
-}
-
-// --- Recent Activities ---
-func (h *Handlers) GetRecentActivities(c *gin.Context) {
-	ownerId := c.Param("ownerId")
-	oid, _ := primitive.ObjectIDFromHex(ownerId)
-	rukoIDs := getOwnerRukoIDs(h, oid)
-
-	cursor, _ := h.db.Collection("bookings").Find(context.Background(), bson.M{"ruko_id": bson.M{"$in": rukoIDs}}, nil)
-	var bookings []Booking
-	cursor.All(context.Background(), &bookings)
-
-	// contoh 5 aktivitas terakhir
-	activities := bookings
-	if len(activities) > 5 {
-		activities = activities[len(activities)-5:]
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx := context.Background()
+	b, err := h.repo.Bookings.Get(ctx, oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		return
 	}
-	c.JSON(http.StatusOK, activities)
+	if c.GetString("role") != "admin" && b.TenantID != uid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not your booking"})
+		return
+	}
+	if b.BookingStatus != "waiting" && b.BookingStatus != "awaiting_signature" {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking cannot be cancelled in status " + b.BookingStatus})
+		return
+	}
+	payments, err := h.repo.Payments.Count(ctx, bson.M{"booking_id": oid, "status": bson.M{"$in": append([]string{"pending"}, incomePaymentStatuses...)}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check payments"})
+		return
+	}
+	if payments > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking already has payments, ask for a refund instead"})
+		return
+	}
+	now := time.Now()
+	if err := h.repo.Bookings.Update(ctx, oid, bson.M{"booking_status": "cancelled", "cancelled_at": now, "updated_at": now}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel booking"})
+		return
+	}
+
+	h.notifyRukoOwner(ctx, b.RukoID, "booking.cancelled", "Booking dibatalkan",
+		"Penyewa membatalkan booking",
+		map[string]string{"booking_id": oid.Hex(), "ruko_id": b.RukoID.Hex()})
+	h.publishRukoEvent(ctx, b.RukoID, "booking.cancelled", map[string]interface{}{"booking_id": oid.Hex()})
+	// booking no longer holds the ruko
+	h.releaseRukoIfFree(ctx, b.RukoID)
+	c.JSON(http.StatusOK, gin.H{"message": "booking cancelled"})
 }
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testServer runs the real routes on NewMemoryRepositories(), without Mongo
+type testServer struct {
+	t      *testing.T
+	h      *Handlers
+	router *gin.Engine
+	owner  primitive.ObjectID
+	tenant primitive.ObjectID
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWith(t, nil, NewMemoryRepositories())
+}
+
+func newTestServerWith(t *testing.T, db *mongo.Database, repo Repositories) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("UPLOAD_DIR", t.TempDir())
+	t.Setenv("DOCUMENT_DIR", t.TempDir())
+	s := &testServer{t: t, h: NewHandlersWithRepositories(db, repo), router: gin.New()}
+	SetupRoutes(s.router, s.h)
+	s.owner = s.createUser("owner@example.com", "owner")
+	s.tenant = s.createUser("tenant@example.com", "tenant")
+	return s
+}
+
+func (s *testServer) createUser(email, role string) primitive.ObjectID {
+	s.t.Helper()
+	u := User{Name: role, Email: email, Role: role, CreatedAt: time.Now()}
+	if err := s.h.repo.Users.Create(context.Background(), &u); err != nil {
+		s.t.Fatalf("create user: %v", err)
+	}
+	return u.ID
+}
+
+// do sends body as JSON with a login token of user and decodes the response into out (if given)
+func (s *testServer) do(method, path string, user primitive.ObjectID, body, out interface{}) int {
+	s.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if !user.IsZero() {
+		u, err := s.h.repo.Users.Get(context.Background(), user)
+		if err != nil {
+			s.t.Fatalf("load user: %v", err)
+		}
+		token, _, err := GenerateToken(u.ID, u.Role)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if out != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decode %s: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func (s *testServer) createRuko() Ruko {
+	s.t.Helper()
+	var r Ruko
+	code := s.do(http.MethodPost, "/api/ruko", s.owner, gin.H{
+		"owner_id":    s.owner.Hex(),
+		"name":        "Ruko Sudirman",
+		"city":        "Jakarta",
+		"price":       1000000,
+		"rental_type": "monthly",
+	}, &r)
+	if code != http.StatusCreated {
+		s.t.Fatalf("create ruko: status %d", code)
+	}
+	return r
+}
+
+// createBooking books r for January - March 2030 (3 months, 3.3M with tax)
+func (s *testServer) createBooking(r Ruko) Booking {
+	s.t.Helper()
+	var b Booking
+	code := s.do(http.MethodPost, "/api/bookings", s.tenant, gin.H{
+		"ruko_id":        r.ID.Hex(),
+		"tenant_id":      s.tenant.Hex(),
+		"start_date":     "2030-01-01",
+		"end_date":       "2030-04-01",
+		"payment_method": "online",
+	}, &b)
+	if code != http.StatusCreated {
+		s.t.Fatalf("create booking: status %d", code)
+	}
+	return b
+}
+
+func (s *testServer) booking(id primitive.ObjectID) Booking {
+	s.t.Helper()
+	b, err := s.h.repo.Bookings.Get(context.Background(), id)
+	if err != nil {
+		s.t.Fatalf("load booking: %v", err)
+	}
+	return b
+}
+
+func (s *testServer) rentalHistory(bookingID primitive.ObjectID) []RentalHistory {
+	s.t.Helper()
+	out, err := s.h.repo.RentalHistory.Find(context.Background(), bson.M{"booking_id": bookingID})
+	if err != nil {
+		s.t.Fatalf("load rental history: %v", err)
+	}
+	return out
+}
+
+func TestRukoHandlers(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	if r.ID.IsZero() || !r.IsAvailable {
+		t.Fatalf("created ruko = %+v", r)
+	}
+
+	var got Ruko
+	if code := s.do(http.MethodGet, "/api/ruko/"+r.ID.Hex(), primitive.NilObjectID, nil, &got); code != http.StatusOK {
+		t.Fatalf("get ruko: status %d", code)
+	}
+	if got.Name != "Ruko Sudirman" || got.OwnerID != s.owner {
+		t.Errorf("get ruko = %+v", got)
+	}
+
+	var list []Ruko
+	if code := s.do(http.MethodGet, "/api/ruko", primitive.NilObjectID, nil, &list); code != http.StatusOK {
+		t.Fatalf("list ruko: status %d", code)
+	}
+	if len(list) != 1 || list[0].ID != r.ID {
+		t.Errorf("list ruko = %+v", list)
+	}
+
+	if code := s.do(http.MethodPost, "/api/ruko", s.tenant, gin.H{"owner_id": s.tenant.Hex(), "name": "x", "price": 1, "rental_type": "monthly"}, nil); code != http.StatusForbidden {
+		t.Errorf("tenant create ruko: status %d, want 403", code)
+	}
+	if code := s.do(http.MethodPost, "/api/ruko", s.owner, gin.H{"owner_id": s.owner.Hex(), "name": "x", "price": -1, "rental_type": "monthly"}, nil); code != http.StatusBadRequest {
+		t.Errorf("negative price: status %d, want 400", code)
+	}
+}
+
+func TestUpdateArchiveRestoreRuko(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	path := "/api/ruko/" + r.ID.Hex()
+
+	var got Ruko
+	code := s.do(http.MethodPut, path, s.owner, gin.H{"name": "Ruko Thamrin", "price": 2000000, "rental_type": "yearly"}, &got)
+	if code != http.StatusOK || got.Name != "Ruko Thamrin" || got.RentalType != "yearly" {
+		t.Fatalf("put ruko: status %d, %+v", code, got)
+	}
+	if code := s.do(http.MethodPut, path, s.owner, gin.H{"name": "x"}, nil); code != http.StatusBadRequest {
+		t.Errorf("put without price: status %d, want 400", code)
+	}
+	if code := s.do(http.MethodPatch, path, s.owner, gin.H{"city": "Bandung"}, &got); code != http.StatusOK {
+		t.Fatalf("patch ruko: status %d", code)
+	}
+	stored, _ := s.h.repo.Rukos.Get(context.Background(), r.ID)
+	if stored.City != "Bandung" || stored.Name != "Ruko Thamrin" || stored.Price != 2000000 {
+		t.Errorf("stored after patch = %+v", stored)
+	}
+	if code := s.do(http.MethodPatch, path, s.tenant, gin.H{"city": "Bogor"}, nil); code != http.StatusForbidden {
+		t.Errorf("tenant patch: status %d, want 403", code)
+	}
+
+	if code := s.do(http.MethodDelete, path, s.owner, nil, nil); code != http.StatusOK {
+		t.Fatalf("delete ruko: status %d", code)
+	}
+	stored, _ = s.h.repo.Rukos.Get(context.Background(), r.ID)
+	if !stored.Archived || stored.ArchivedAt == nil || stored.IsAvailable {
+		t.Errorf("stored after delete = %+v", stored)
+	}
+	if code := s.do(http.MethodPatch, path, s.owner, gin.H{"city": "Bogor"}, nil); code != http.StatusConflict {
+		t.Errorf("patch archived ruko: status %d, want 409", code)
+	}
+	if code := s.do(http.MethodPost, path+"/images", s.owner, nil, nil); code != http.StatusConflict {
+		t.Errorf("upload images to archived ruko: status %d, want 409", code)
+	}
+
+	if code := s.do(http.MethodPost, path+"/restore", s.owner, nil, nil); code != http.StatusOK {
+		t.Fatalf("restore ruko: status %d", code)
+	}
+	stored, _ = s.h.repo.Rukos.Get(context.Background(), r.ID)
+	if stored.Archived || stored.ArchivedAt != nil || !stored.IsAvailable {
+		t.Errorf("stored after restore = %+v", stored)
+	}
+	if code := s.do(http.MethodPost, path+"/restore", s.owner, nil, nil); code != http.StatusBadRequest {
+		t.Errorf("restore twice: status %d, want 400", code)
+	}
+}
+
+func TestCreateBooking(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	b := s.createBooking(r)
+
+	if b.BookingStatus != "waiting" || b.PaymentStatus != "pending" {
+		t.Errorf("booking status = %s/%s", b.BookingStatus, b.PaymentStatus)
+	}
+	if b.Pricing == nil || b.Pricing.Quantity != 3 || b.TotalPrice != 3300000 {
+		t.Errorf("pricing = %+v, total %.0f", b.Pricing, b.TotalPrice)
+	}
+	stored, _ := s.h.repo.Rukos.Get(context.Background(), r.ID)
+	if stored.IsAvailable {
+		t.Error("ruko is still available after the booking")
+	}
+
+	var list []Booking
+	s.do(http.MethodGet, "/api/bookings?tenant_id="+s.tenant.Hex(), s.tenant, nil, &list)
+	if len(list) != 1 || list[0].ID != b.ID {
+		t.Errorf("list bookings = %+v", list)
+	}
+}
+
+func TestCreateBookingOfflineConflict(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	rh := RentalHistory{
+		RukoID:     r.ID,
+		Source:     "offline",
+		TenantName: "Pak Budi",
+		StartDate:  time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := s.h.repo.RentalHistory.Create(context.Background(), &rh); err != nil {
+		t.Fatal(err)
+	}
+	code := s.do(http.MethodPost, "/api/bookings", s.tenant, gin.H{
+		"ruko_id":        r.ID.Hex(),
+		"tenant_id":      s.tenant.Hex(),
+		"start_date":     "2030-01-01",
+		"end_date":       "2030-04-01",
+		"payment_method": "online",
+	}, nil)
+	if code != http.StatusConflict {
+		t.Fatalf("booking over an offline rental: status %d, want 409", code)
+	}
+	if n, _ := s.h.repo.Bookings.Count(context.Background(), bson.M{}); n != 0 {
+		t.Errorf("%d bookings stored", n)
+	}
+}
+
+func TestCreatePaymentInstallments(t *testing.T) {
+	s := newTestServer(t)
+	b := s.createBooking(s.createRuko())
+
+	pay := func(amount float64) Payment {
+		t.Helper()
+		var p Payment
+		code := s.do(http.MethodPost, "/api/payments", s.tenant, gin.H{
+			"booking_id":     b.ID.Hex(),
+			"payment_method": "transfer",
+			"amount":         amount,
+			"status":         "confirmed",
+		}, &p)
+		if code != http.StatusCreated {
+			t.Fatalf("create payment: status %d", code)
+		}
+		return p
+	}
+
+	pay(1000000)
+	got := s.booking(b.ID)
+	if got.PaymentStatus != "partial" || got.BookingStatus != "awaiting_signature" || got.AcceptedAt == nil {
+		t.Errorf("after first installment: %s/%s accepted_at=%v", got.PaymentStatus, got.BookingStatus, got.AcceptedAt)
+	}
+	accepted := *got.AcceptedAt
+
+	p := pay(2300000)
+	got = s.booking(b.ID)
+	if got.PaymentStatus != "paid" {
+		t.Errorf("after last installment payment_status = %s, want paid", got.PaymentStatus)
+	}
+	if !got.AcceptedAt.Equal(accepted) {
+		t.Error("accepted_at changed on the second payment")
+	}
+	history := s.rentalHistory(b.ID)
+	if len(history) != 1 || history[0].TotalPaid != 3300000 {
+		t.Errorf("rental history = %+v, want one entry with 3300000 paid", history)
+	}
+
+	var stored Payment
+	if code := s.do(http.MethodGet, "/api/payments/"+p.ID.Hex(), s.tenant, nil, &stored); code != http.StatusOK || stored.Amount != 2300000 {
+		t.Errorf("get payment: status %d, %+v", code, stored)
+	}
+
+	ctx := context.Background()
+	invoices, _ := s.h.repo.Invoices.Find(ctx, bson.M{"booking_id": b.ID, "status": bson.M{"$ne": "void"}})
+	if len(invoices) == 0 {
+		t.Error("no invoice issued for the booking")
+	}
+	for _, inv := range invoices {
+		if inv.Status != "paid" {
+			t.Errorf("invoice %s is %s after the installments added up to the total", inv.Number, inv.Status)
+		}
+	}
+	if n, _ := s.h.repo.Ledger.Count(ctx, bson.M{"booking_id": b.ID, "type": "payment"}); n != 2 {
+		t.Errorf("%d payment ledger transactions, want 2", n)
+	}
+	if n, _ := s.h.repo.Activities.Count(ctx, bson.M{}); n == 0 {
+		t.Error("no activity recorded")
+	}
+}
+
+func TestCreatePaymentPending(t *testing.T) {
+	s := newTestServer(t)
+	b := s.createBooking(s.createRuko())
+
+	code := s.do(http.MethodPost, "/api/payments", s.tenant, gin.H{
+		"booking_id":     b.ID.Hex(),
+		"payment_method": "transfer",
+		"amount":         3300000,
+		"status":         "pending",
+	}, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("create payment: status %d", code)
+	}
+	if got := s.booking(b.ID); got.PaymentStatus != "pending" || got.BookingStatus != "waiting" {
+		t.Errorf("pending payment changed the booking to %s/%s", got.PaymentStatus, got.BookingStatus)
+	}
+	if len(s.rentalHistory(b.ID)) != 0 {
+		t.Error("pending payment created rental history")
+	}
+}
+
+func TestConfirmBookingOffline(t *testing.T) {
+	s := newTestServer(t)
+	b := s.createBooking(s.createRuko())
+	path := "/api/bookings/" + b.ID.Hex() + "/confirm-offline"
+	body := gin.H{"verifier_id": s.owner.Hex()}
+
+	if code := s.do(http.MethodPatch, path, s.owner, body, nil); code != http.StatusOK {
+		t.Fatalf("confirm offline: status %d", code)
+	}
+	got := s.booking(b.ID)
+	if got.PaymentStatus != "paid" || got.OfflineVerifiedBy == nil || *got.OfflineVerifiedBy != s.owner {
+		t.Errorf("booking = %s, verified by %v", got.PaymentStatus, got.OfflineVerifiedBy)
+	}
+	payments, _ := s.h.repo.Payments.Find(context.Background(), bson.M{"booking_id": b.ID})
+	if len(payments) != 1 || payments[0].Amount != b.TotalPrice || payments[0].PaymentMethod != "cash" {
+		t.Errorf("payments = %+v", payments)
+	}
+
+	if code := s.do(http.MethodPatch, path, s.owner, body, nil); code != http.StatusConflict {
+		t.Errorf("second confirm: status %d, want 409", code)
+	}
+	if n, _ := s.h.repo.Payments.Count(context.Background(), bson.M{"booking_id": b.ID}); n != 1 {
+		t.Errorf("%d payments after confirming twice", n)
+	}
+}
+
+func TestConfirmBookingOfflineAfterInstallment(t *testing.T) {
+	s := newTestServer(t)
+	b := s.createBooking(s.createRuko())
+	code := s.do(http.MethodPost, "/api/payments", s.tenant, gin.H{
+		"booking_id":     b.ID.Hex(),
+		"payment_method": "transfer",
+		"amount":         1000000,
+		"status":         "confirmed",
+	}, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("create payment: status %d", code)
+	}
+
+	path := "/api/bookings/" + b.ID.Hex() + "/confirm-offline"
+	if code := s.do(http.MethodPatch, path, s.owner, gin.H{"verifier_id": s.owner.Hex()}, nil); code != http.StatusOK {
+		t.Fatalf("confirm offline: status %d", code)
+	}
+	ctx := context.Background()
+	cash, err := s.h.repo.Payments.FindOne(ctx, bson.M{"booking_id": b.ID, "payment_method": "cash"})
+	if err != nil || cash.Amount != 2300000 {
+		t.Errorf("cash payment = %+v (%v), want the 2300000 still open", cash, err)
+	}
+	if got := s.booking(b.ID); got.PaymentStatus != "paid" {
+		t.Errorf("payment_status = %s, want paid", got.PaymentStatus)
+	}
+	if n, _ := s.h.repo.Invoices.Count(ctx, bson.M{"booking_id": b.ID, "status": "unpaid"}); n != 0 {
+		t.Errorf("%d invoices still unpaid", n)
+	}
+}
+
+func TestCancelBooking(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	b := s.createBooking(r)
+	path := "/api/bookings/" + b.ID.Hex() + "/cancel"
+
+	if code := s.do(http.MethodPut, path, s.createUser("other@example.com", "tenant"), nil, nil); code != http.StatusForbidden {
+		t.Errorf("cancel by another tenant: status %d, want 403", code)
+	}
+	if code := s.do(http.MethodPut, path, s.tenant, nil, nil); code != http.StatusOK {
+		t.Fatalf("cancel: status %d", code)
+	}
+	if got := s.booking(b.ID); got.BookingStatus != "cancelled" || got.CancelledAt == nil {
+		t.Errorf("booking = %s, cancelled_at %v", got.BookingStatus, got.CancelledAt)
+	}
+	if stored, _ := s.h.repo.Rukos.Get(context.Background(), r.ID); !stored.IsAvailable {
+		t.Error("ruko was not released")
+	}
+	code := s.do(http.MethodPost, "/api/payments", s.tenant, gin.H{
+		"booking_id": b.ID.Hex(), "payment_method": "transfer", "amount": 1, "status": "confirmed",
+	}, nil)
+	if code != http.StatusConflict {
+		t.Errorf("payment on a cancelled booking: status %d, want 409", code)
+	}
+}
+
+func TestAcceptBooking(t *testing.T) {
+	s := newTestServer(t)
+	b := s.createBooking(s.createRuko())
+	path := "/api/bookings/" + b.ID.Hex() + "/accept"
+
+	if code := s.do(http.MethodPut, path, s.tenant, nil, nil); code != http.StatusForbidden {
+		t.Errorf("accept by the tenant: status %d, want 403", code)
+	}
+	if code := s.do(http.MethodPut, path, s.createUser("other@example.com", "owner"), nil, nil); code != http.StatusForbidden {
+		t.Errorf("accept by another owner: status %d, want 403", code)
+	}
+	if got := s.booking(b.ID); got.BookingStatus != "waiting" {
+		t.Fatalf("refused accept changed the booking to %s", got.BookingStatus)
+	}
+	if code := s.do(http.MethodPut, path, s.owner, nil, nil); code != http.StatusOK {
+		t.Fatalf("accept: status %d", code)
+	}
+	if got := s.booking(b.ID); got.BookingStatus != "awaiting_signature" || got.AcceptedAt == nil {
+		t.Errorf("booking = %s, accepted_at %v", got.BookingStatus, got.AcceptedAt)
+	}
+	if code := s.do(http.MethodPut, path, s.owner, nil, nil); code != http.StatusConflict {
+		t.Errorf("second accept: status %d, want 409", code)
+	}
+
+	cancelled := s.createBooking(s.createRuko())
+	s.do(http.MethodPut, "/api/bookings/"+cancelled.ID.Hex()+"/cancel", s.tenant, nil, nil)
+	if code := s.do(http.MethodPut, "/api/bookings/"+cancelled.ID.Hex()+"/accept", s.owner, nil, nil); code != http.StatusConflict {
+		t.Errorf("accept a cancelled booking: status %d, want 409", code)
+	}
+	if got := s.booking(cancelled.ID); got.BookingStatus != "cancelled" {
+		t.Errorf("cancelled booking changed to %s", got.BookingStatus)
+	}
+}
+
+func TestRejectBooking(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	b := s.createBooking(r)
+	path := "/api/bookings/" + b.ID.Hex() + "/reject"
+
+	if code := s.do(http.MethodPut, path, s.tenant, nil, nil); code != http.StatusForbidden {
+		t.Errorf("reject by the tenant: status %d, want 403", code)
+	}
+	if code := s.do(http.MethodPut, path, s.createUser("other@example.com", "owner"), nil, nil); code != http.StatusForbidden {
+		t.Errorf("reject by another owner: status %d, want 403", code)
+	}
+	if code := s.do(http.MethodPut, path, s.owner, nil, nil); code != http.StatusOK {
+		t.Fatalf("reject: status %d", code)
+	}
+	if got := s.booking(b.ID); got.BookingStatus != "rejected" || got.RejectedAt == nil {
+		t.Errorf("booking = %s, rejected_at %v", got.BookingStatus, got.RejectedAt)
+	}
+	if stored, _ := s.h.repo.Rukos.Get(context.Background(), r.ID); !stored.IsAvailable {
+		t.Error("ruko was not released")
+	}
+
+	// a paid booking keeps the ruko
+	paid := s.createBooking(r)
+	if code := confirmedPayment(s, paid); code != http.StatusCreated {
+		t.Fatalf("payment: status %d", code)
+	}
+	if code := s.do(http.MethodPut, "/api/bookings/"+paid.ID.Hex()+"/reject", s.owner, nil, nil); code != http.StatusConflict {
+		t.Errorf("reject a paid booking: status %d, want 409", code)
+	}
+	if got := s.booking(paid.ID); got.BookingStatus != "awaiting_signature" {
+		t.Errorf("paid booking changed to %s", got.BookingStatus)
+	}
+	if stored, _ := s.h.repo.Rukos.Get(context.Background(), r.ID); stored.IsAvailable {
+		t.Error("ruko was released while a paid booking holds it")
+	}
+}
+
+func TestRefundPayment(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	b := s.createBooking(r)
+	if code := confirmedPayment(s, b); code != http.StatusCreated {
+		t.Fatalf("payment: status %d", code)
+	}
+	ctx := context.Background()
+	p, _ := s.h.repo.Payments.FindOne(ctx, bson.M{"booking_id": b.ID})
+	admin := s.createUser("admin@example.com", "admin")
+	path := "/api/payments/" + p.ID.Hex() + "/refund"
+
+	if code := s.do(http.MethodPost, path, admin, gin.H{"amount": 1000000, "reason": "diskon"}, nil); code != http.StatusOK {
+		t.Fatalf("partial refund: status %d", code)
+	}
+	if got := s.booking(b.ID); got.PaymentStatus != "partial" || got.BookingStatus != "awaiting_signature" {
+		t.Errorf("after partial refund booking = %s/%s", got.PaymentStatus, got.BookingStatus)
+	}
+	if h := s.rentalHistory(b.ID); len(h) != 1 || h[0].TotalPaid != 2300000 {
+		t.Errorf("rental history after partial refund = %+v", h)
+	}
+
+	if code := s.do(http.MethodPost, path, admin, gin.H{"reason": "batal"}, nil); code != http.StatusOK {
+		t.Fatalf("full refund: status %d", code)
+	}
+	got := s.booking(b.ID)
+	if got.PaymentStatus != "refunded" || got.BookingStatus != "cancelled" || got.CancelledAt == nil {
+		t.Errorf("after full refund booking = %s/%s", got.PaymentStatus, got.BookingStatus)
+	}
+	if h := s.rentalHistory(b.ID); len(h) != 1 || h[0].TotalPaid != 0 {
+		t.Errorf("rental history after full refund = %+v", h)
+	}
+	if n, _ := s.h.repo.Invoices.Count(ctx, bson.M{"booking_id": b.ID, "status": "paid"}); n != 0 {
+		t.Errorf("%d invoices still paid after the full refund", n)
+	}
+	if stored, _ := s.h.repo.Rukos.Get(ctx, r.ID); !stored.IsAvailable {
+		t.Error("ruko was not released after the full refund")
+	}
+}
+
+func TestAcceptSiteVisitConflict(t *testing.T) {
+	s := newTestServer(t)
+	r := s.createRuko()
+	day := time.Now().Add(48 * time.Hour).UTC().Truncate(24 * time.Hour)
+	at := func(hour int) string { return day.Add(time.Duration(hour) * time.Hour).Format(time.RFC3339) }
+	if code := s.do(http.MethodPost, "/api/ruko/"+r.ID.Hex()+"/viewing-windows", s.owner, gin.H{"start": at(1), "end": at(8)}, nil); code != http.StatusCreated {
+		t.Fatalf("create viewing window: status %d", code)
+	}
+	request := func(tenant primitive.ObjectID) SiteVisit {
+		t.Helper()
+		var v SiteVisit
+		if code := s.do(http.MethodPost, "/api/ruko/"+r.ID.Hex()+"/site-visits", tenant, gin.H{"start": at(3)}, &v); code != http.StatusCreated {
+			t.Fatalf("request site visit: status %d", code)
+		}
+		return v
+	}
+	first := request(s.tenant)
+	second := request(s.createUser("tenant2@example.com", "tenant"))
+
+	if code := s.do(http.MethodPut, "/api/site-visits/"+first.ID.Hex()+"/accept", s.owner, nil, nil); code != http.StatusOK {
+		t.Fatalf("accept first visit: status %d", code)
+	}
+	if code := s.do(http.MethodPut, "/api/site-visits/"+second.ID.Hex()+"/accept", s.owner, nil, nil); code != http.StatusConflict {
+		t.Errorf("accept overlapping visit: status %d, want 409", code)
+	}
+	if code := s.do(http.MethodPut, "/api/site-visits/"+first.ID.Hex()+"/accept", s.owner, nil, nil); code != http.StatusBadRequest {
+		t.Errorf("accept twice: status %d, want 400", code)
+	}
+
+	n, err := s.h.repo.Notifications.FindOne(context.Background(), bson.M{"user_id": s.tenant, "type": "site_visit.accepted"})
+	want := formatVisitTime(first.Start)
+	if err != nil || !strings.HasSuffix(want, "WIB") || !strings.Contains(n.Body, want) {
+		t.Errorf("accepted notification = %q (%v), want the time as %q", n.Body, err, want)
+	}
+}
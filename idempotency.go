@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxIdempotencyKeyLen   = 255
+	maxIdempotentBodyBytes = 1 << 20 // responses bigger than this are not kept for replay
+	maxIdempotentRequest   = 1 << 20 // the request body is buffered to hash it, file uploads are not supported
+	idempotencyStaleAfter  = 5 * time.Minute
+)
+
+func idempotencyTTL() time.Duration {
+	hours := 24 // default
+	if v := os.Getenv("IDEMPOTENCY_TTL_HOURS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			hours = parsed
+		}
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// idempotencyWriter keeps a copy of the response so it can be replayed
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes POST requests with an Idempotency-Key header safe to retry:
+// the first response is stored per user and key, a retry with the same body gets the
+// stored response back, and a reused key with a different body is rejected.
+// Must run after AuthMiddleware, keys are scoped to the user. Bodies over 1MB with a key get 413.
+// Only mounted on POST /bookings and POST /payments.
+func (h *Handlers) IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentRequest))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body is too large to use with Idempotency-Key"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		ctx := context.Background()
+		now := time.Now()
+		rec := IdempotencyRecord{
+			UserID:      c.GetString("user_id"),
+			Key:         key,
+			RequestHash: hash,
+			Status:      "processing",
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyTTL()),
+		}
+		err = h.repo.Idempotency.Create(ctx, &rec)
+		if err == ErrDuplicate {
+			h.replayIdempotent(c, rec)
+			return
+		}
+		if err != nil {
+			log.Println("idempotency error:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed store idempotency key"})
+			return
+		}
+		w := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		// server errors and oversized responses are not kept, the client may retry with the same key
+		if status >= http.StatusInternalServerError || w.body.Len() > maxIdempotentBodyBytes {
+			if err := h.repo.Idempotency.Delete(ctx, bson.M{"_id": rec.ID}); err != nil {
+				log.Println("idempotency error:", err)
+			}
+			return
+		}
+		err = h.repo.Idempotency.Update(ctx, rec.ID, bson.M{
+			"status":        "completed",
+			"response_code": status,
+			"content_type":  w.Header().Get("Content-Type"),
+			"response_body": w.body.Bytes(),
+		})
+		if err != nil {
+			log.Println("idempotency error:", err)
+		}
+	}
+}
+
+// replayIdempotent answers a retry from the stored record
+func (h *Handlers) replayIdempotent(c *gin.Context, rec IdempotencyRecord) {
+	stored, err := h.repo.Idempotency.FindOne(context.Background(), bson.M{"user_id": rec.UserID, "key": rec.Key})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed load idempotency key"})
+		return
+	}
+	if stored.RequestHash != rec.RequestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if stored.Status != "completed" {
+		// the first request never finished (e.g. the server restarted), free the key for the next retry
+		if time.Since(stored.CreatedAt) > idempotencyStaleAfter {
+			_ = h.repo.Idempotency.Delete(context.Background(), bson.M{"_id": stored.ID, "status": "processing"})
+		}
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(stored.ResponseCode, stored.ContentType, stored.ResponseBody)
+	c.Abort()
+}
+
+// ensureIdempotencyIndexes: one record per user and key, removed when expired
+// (the unique index is what makes a retry replay instead of running twice, so it is required)
+func ensureIdempotencyIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	keys := db.Collection("idempotency_keys")
+	_, err := keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal("create idempotency key index error:", err)
+	}
+	if _, err := keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil {
+		log.Println("create idempotency expiry index error:", err)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxImagesPerRuko = 10
+	thumbMaxWidth    = 400
+	maxImagePixels   = 24000000 // checked before decoding, a small file can declare huge dimensions (6000x4000)
+)
+
+// imageDecodeSlot lets one upload decode at a time, a decoded image takes 4 bytes per pixel
+var imageDecodeSlot = make(chan struct{}, 1)
+
+// errRukoChanged is returned when the ruko was updated since it was read
+var errRukoChanged = errors.New("ruko was changed")
+
+// allowed image types (sniffed from content, not from the client header)
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+func maxUploadBytes() int64 {
+	mb := 5 // default
+	if v := os.Getenv("UPLOAD_MAX_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			mb = parsed
+		}
+	}
+	return int64(mb) << 20
+}
+
+// makeThumbnail scales img down to thumbMaxWidth (keeping aspect ratio) and encodes it as jpeg
+func makeThumbnail(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbMaxWidth {
+		h = h * thumbMaxWidth / w
+		w = thumbMaxWidth
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + y*b.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + x*b.Dx()/w
+			dst.Set(x, y, img.At(sx, sy))
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var errInvalidImage = errors.New("invalid image")
+
+// decodeThumbnail decodes an image and makes its thumbnail, holding the decode slot
+func decodeThumbnail(data []byte) ([]byte, error) {
+	imageDecodeSlot <- struct{}{}
+	defer func() { <-imageDecodeSlot }()
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+	return makeThumbnail(decoded)
+}
+
+// keep ruko.image (cover url) in sync with the gallery
+func coverURL(images []RukoImage) string {
+	for _, img := range images {
+		if img.IsCover {
+			return img.URL
+		}
+	}
+	return ""
+}
+
+func (h *Handlers) saveRukoImages(ctx context.Context, r Ruko, images []RukoImage) error {
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Order < images[j].Order })
+	for i := range images {
+		images[i].Order = i
+	}
+	if len(images) > 0 && coverURL(images) == "" {
+		images[0].IsCover = true
+	}
+	// updated_at is the version: a concurrent change to the gallery makes this write fail
+	// instead of dropping the other request's images
+	err := h.repo.Rukos.UpdateWhere(ctx, bson.M{"_id": r.ID, "updated_at": r.UpdatedAt}, bson.M{
+		"images":     images,
+		"image":      coverURL(images),
+		"updated_at": time.Now(),
+	})
+	if err == ErrNotFound {
+		return errRukoChanged
+	}
+	return err
+}
+
+// helper: response for a failed saveRukoImages
+func imagesSaveFailed(c *gin.Context, err error) {
+	if err == errRukoChanged {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko images were changed by another request, try again"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update ruko images"})
+}
+
+type pendingImage struct {
+	img         RukoImage
+	data, thumb []byte
+}
+
+// UploadRukoImages: POST /api/ruko/:id/images (multipart, field "images", multiple files)
+func (h *Handlers) UploadRukoImages(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	if r.Archived {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko is archived, restore it first"})
+		return
+	}
+	maxSize := maxUploadBytes()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize*maxImagesPerRuko+(1<<20))
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form: " + err.Error()})
+		return
+	}
+	files := form.File["images"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no images uploaded (field: images)"})
+		return
+	}
+	if len(r.Images)+len(files) > maxImagesPerRuko {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max %d images per ruko", maxImagesPerRuko)})
+		return
+	}
+
+	// validate everything first, nothing is stored if one file is bad
+	var pending []pendingImage
+	for i, fh := range files {
+		if fh.Size > maxSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: file too large (max %d MB)", fh.Filename, maxSize>>20)})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fh.Filename + ": cannot read file"})
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+		f.Close()
+		if err != nil || int64(len(data)) > maxSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fh.Filename + ": cannot read file or file too large"})
+			return
+		}
+		contentType := http.DetectContentType(data)
+		ext, allowed := allowedImageTypes[contentType]
+		if !allowed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fh.Filename + ": unsupported file type " + contentType})
+			return
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fh.Filename + ": invalid image"})
+			return
+		}
+		if cfg.Width < 1 || cfg.Height < 1 || int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: image dimensions %dx%d are too large", fh.Filename, cfg.Width, cfg.Height)})
+			return
+		}
+		thumb, err := decodeThumbnail(data)
+		if errors.Is(err, errInvalidImage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fh.Filename + ": invalid image"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create thumbnail"})
+			return
+		}
+
+		imgID := primitive.NewObjectID()
+		key := fmt.Sprintf("ruko/%s/%s%s", r.ID.Hex(), imgID.Hex(), ext)
+		thumbKey := fmt.Sprintf("ruko/%s/%s_thumb.jpg", r.ID.Hex(), imgID.Hex())
+		pending = append(pending, pendingImage{
+			img: RukoImage{
+				ID:          imgID,
+				Key:         key,
+				ThumbKey:    thumbKey,
+				URL:         h.storage.URL(key),
+				ThumbURL:    h.storage.URL(thumbKey),
+				ContentType: contentType,
+				Size:        int64(len(data)),
+				Order:       len(r.Images) + i,
+				CreatedAt:   time.Now(),
+			},
+			data:  data,
+			thumb: thumb,
+		})
+	}
+
+	ctx := context.Background()
+	var saved []RukoImage
+	for _, p := range pending {
+		err := h.storage.Save(ctx, p.img.Key, bytes.NewReader(p.data))
+		if err == nil {
+			err = h.storage.Save(ctx, p.img.ThumbKey, bytes.NewReader(p.thumb))
+		}
+		if err != nil {
+			log.Println("save image error:", err)
+			h.deleteImageFiles(ctx, append(saved, p.img))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed store image"})
+			return
+		}
+		saved = append(saved, p.img)
+	}
+
+	images := append(r.Images, saved...)
+	if err := h.saveRukoImages(ctx, r, images); err != nil {
+		h.deleteImageFiles(ctx, saved)
+		imagesSaveFailed(c, err)
+		return
+	}
+	h.publishRukoEvent(ctx, r.ID, "ruko.images", map[string]interface{}{"added": len(saved)})
+	c.JSON(http.StatusCreated, images)
+}
+
+func (h *Handlers) deleteImageFiles(ctx context.Context, images []RukoImage) {
+	for _, img := range images {
+		_ = h.storage.Delete(ctx, img.Key)
+		_ = h.storage.Delete(ctx, img.ThumbKey)
+	}
+}
+
+// DeleteRukoImage: DELETE /api/ruko/:id/images/:imageId
+func (h *Handlers) DeleteRukoImage(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	imgID, err := primitive.ObjectIDFromHex(c.Param("imageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image id"})
+		return
+	}
+	var removed *RukoImage
+	images := make([]RukoImage, 0, len(r.Images))
+	for i := range r.Images {
+		if r.Images[i].ID == imgID {
+			removed = &r.Images[i]
+			continue
+		}
+		images = append(images, r.Images[i])
+	}
+	if removed == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if err := h.saveRukoImages(context.Background(), r, images); err != nil {
+		imagesSaveFailed(c, err)
+		return
+	}
+	h.deleteImageFiles(context.Background(), []RukoImage{*removed})
+	h.publishRukoEvent(context.Background(), r.ID, "ruko.images", map[string]interface{}{"removed": 1})
+	c.JSON(http.StatusOK, images)
+}
+
+// ReorderRukoImages: PUT /api/ruko/:id/images/order  {"image_ids": [...]}
+func (h *Handlers) ReorderRukoImages(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	var in struct {
+		ImageIDs []string `json:"image_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(in.ImageIDs) != len(r.Images) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must contain every image of the ruko"})
+		return
+	}
+	position := make(map[primitive.ObjectID]int, len(in.ImageIDs))
+	for i, id := range in.ImageIDs {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image id " + id})
+			return
+		}
+		position[oid] = i
+	}
+	images := r.Images
+	for i := range images {
+		pos, found := position[images[i].ID]
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must contain every image of the ruko"})
+			return
+		}
+		images[i].Order = pos
+	}
+	if err := h.saveRukoImages(context.Background(), r, images); err != nil {
+		imagesSaveFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// SetRukoCoverImage: PATCH /api/ruko/:id/images/:imageId/cover
+func (h *Handlers) SetRukoCoverImage(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	imgID, err := primitive.ObjectIDFromHex(c.Param("imageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image id"})
+		return
+	}
+	found := false
+	for i := range r.Images {
+		r.Images[i].IsCover = r.Images[i].ID == imgID
+		found = found || r.Images[i].IsCover
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if err := h.saveRukoImages(context.Background(), r, r.Images); err != nil {
+		imagesSaveFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, r.Images)
+}
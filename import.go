@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	importMaxRows       = 5000
+	importProgressEvery = 25 // rows between progress updates of a job
+	importXlsxExpansion = 10 // how much larger than the upload an unzipped xlsx may get
+)
+
+// columns accepted in an import file; external_ref, name, price and rental_type are required
+var importColumns = map[string]string{
+	"external_ref":     "string",
+	"name":             "string",
+	"description":      "string",
+	"address":          "string",
+	"city":             "string",
+	"rental_type":      "string",
+	"zoning":           "string",
+	"price":            "float",
+	"discount_percent": "float",
+	"latitude":         "float",
+	"longitude":        "float",
+	"land_area":        "float",
+	"building_area":    "float",
+	"floors":           "int",
+	"bathrooms":        "int",
+	"power_va":         "int",
+	"facilities":       "list", // separated by ; or ,
+}
+
+var importRequiredColumns = []string{"external_ref", "name", "price", "rental_type"}
+
+func importMaxBytes() int64 {
+	mb := 10 // default
+	if v := os.Getenv("IMPORT_MAX_MB"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			mb = parsed
+		}
+	}
+	return int64(mb) << 20
+}
+
+// importRow is a validated row, ready to be upserted
+type importRow struct {
+	Line int
+	Ruko Ruko
+	Set  bson.M // only the columns filled in the file, empty cells keep the stored value
+}
+
+// readImportFile returns all records of a .csv or .xlsx upload (first sheet)
+func readImportFile(fh *multipart.FileHeader) ([][]string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, errors.New("cannot read file")
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(fh.Filename)) {
+	case ".csv":
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		var records [][]string
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid csv: %v", err)
+			}
+			if len(records) > importMaxRows {
+				return nil, fmt.Errorf("max %d rows per import", importMaxRows)
+			}
+			records = append(records, rec)
+		}
+		if len(records) > 0 && len(records[0]) > 0 {
+			records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff") // excel adds a BOM
+		}
+		return records, nil
+	case ".xlsx":
+		// bound the unzipped size, a small zip bomb would otherwise be expanded in memory
+		maxSize := importMaxBytes()
+		x, err := excelize.OpenReader(f, excelize.Options{
+			UnzipSizeLimit:    maxSize * importXlsxExpansion,
+			UnzipXMLSizeLimit: maxSize,
+		})
+		if err != nil {
+			return nil, errors.New("invalid xlsx file")
+		}
+		defer x.Close()
+		sheets := x.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("xlsx file has no sheet")
+		}
+		rows, err := x.Rows(sheets[0])
+		if err != nil {
+			return nil, errors.New("invalid xlsx file")
+		}
+		defer rows.Close()
+		var records [][]string
+		for rows.Next() {
+			rec, err := rows.Columns()
+			if err != nil {
+				return nil, errors.New("invalid xlsx file")
+			}
+			if len(records) > importMaxRows {
+				return nil, fmt.Errorf("max %d rows per import", importMaxRows)
+			}
+			records = append(records, rec)
+		}
+		return records, nil
+	}
+	return nil, errors.New("file must be .csv or .xlsx")
+}
+
+// parseImportHeader maps column index to column name
+func parseImportHeader(header []string) ([]string, error) {
+	cols := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(h))
+		if name == "" {
+			continue
+		}
+		if _, ok := importColumns[name]; !ok {
+			return nil, errors.New("unknown column: " + name)
+		}
+		if seen[name] {
+			return nil, errors.New("duplicate column: " + name)
+		}
+		seen[name] = true
+		cols[i] = name
+	}
+	for _, req := range importRequiredColumns {
+		if !seen[req] {
+			return nil, errors.New("missing column: " + req)
+		}
+	}
+	return cols, nil
+}
+
+// parseImportRow converts one record, returning the row errors if it is invalid
+func parseImportRow(line int, cols []string, rec []string) (importRow, []ImportRowError) {
+	row := importRow{Line: line, Set: bson.M{}}
+	r := &row.Ruko
+	var errs []ImportRowError
+	fail := func(field, msg string) {
+		errs = append(errs, ImportRowError{Row: line, Field: field, Error: msg})
+	}
+	hasLat, hasLng := false, false
+
+	for i, col := range cols {
+		if col == "" || i >= len(rec) {
+			continue
+		}
+		v := strings.TrimSpace(rec[i])
+		if v == "" {
+			continue
+		}
+		var val interface{}
+		switch importColumns[col] {
+		case "string":
+			val = v
+		case "float":
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				fail(col, "invalid number")
+				continue
+			}
+			val = f
+		case "int":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				fail(col, "invalid integer")
+				continue
+			}
+			val = n
+		case "list":
+			var items []string
+			for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == ',' }) {
+				if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+					items = append(items, s)
+				}
+			}
+			val = items
+		}
+		row.Set[col] = val
+
+		switch col {
+		case "external_ref":
+			r.ExternalRef = v
+		case "name":
+			r.Name = v
+		case "description":
+			r.Description = v
+		case "address":
+			r.Address = v
+		case "city":
+			r.City = v
+		case "rental_type":
+			r.RentalType = v
+		case "zoning":
+			r.Zoning = v
+		case "price":
+			r.Price = val.(float64)
+		case "discount_percent":
+			r.DiscountPercent = val.(float64)
+		case "latitude":
+			r.Latitude, hasLat = val.(float64), true
+		case "longitude":
+			r.Longitude, hasLng = val.(float64), true
+		case "land_area":
+			r.LandArea = val.(float64)
+		case "building_area":
+			r.BuildingArea = val.(float64)
+		case "floors":
+			r.Floors = val.(int)
+		case "bathrooms":
+			r.Bathrooms = val.(int)
+		case "power_va":
+			r.PowerVA = val.(int)
+		case "facilities":
+			r.Facilities = val.([]string)
+		}
+	}
+	if len(errs) > 0 {
+		return row, errs
+	}
+
+	for _, req := range importRequiredColumns {
+		if _, ok := row.Set[req]; !ok {
+			fail(req, "required")
+		}
+	}
+	if len(errs) > 0 {
+		return row, errs
+	}
+	if hasLat != hasLng {
+		fail("latitude", "latitude and longitude must be filled together")
+	}
+	if err := validateRukoFields(r.Price, r.DiscountPercent, r.RentalType, r.Latitude, r.Longitude); err != nil {
+		fail("", err.Error())
+	}
+	if err := validateRukoAttributes(*r); err != nil {
+		fail("", err.Error())
+	}
+	if hasLat && hasLng && validLatLng(r.Latitude, r.Longitude) {
+		r.Location = NewGeoPoint(r.Latitude, r.Longitude)
+		row.Set["location"] = r.Location
+	}
+	return row, errs
+}
+
+// parseImportRecords validates every record, refs must be unique inside the file
+func parseImportRecords(records [][]string) ([]importRow, []ImportRowError, error) {
+	if len(records) == 0 {
+		return nil, nil, errors.New("file is empty")
+	}
+	cols, err := parseImportHeader(records[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []importRow
+	var errs []ImportRowError
+	refLine := map[string]int{}
+	for i, rec := range records[1:] {
+		line := i + 2
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue // blank line
+		}
+		row, rowErrs := parseImportRow(line, cols, rec)
+		if len(rowErrs) == 0 {
+			if first, dup := refLine[row.Ruko.ExternalRef]; dup {
+				rowErrs = append(rowErrs, ImportRowError{Row: line, Field: "external_ref",
+					Error: fmt.Sprintf("duplicate of row %d", first)})
+			} else {
+				refLine[row.Ruko.ExternalRef] = line
+			}
+		}
+		if len(rowErrs) > 0 {
+			errs = append(errs, rowErrs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// helper: refs of the rows that already exist for the owner, true when the ruko is archived
+func (h *Handlers) existingImportRefs(ctx context.Context, ownerOID primitive.ObjectID, rows []importRow) (map[string]bool, error) {
+	refs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		refs = append(refs, row.Ruko.ExternalRef)
+	}
+	out := map[string]bool{}
+	if len(refs) == 0 {
+		return out, nil
+	}
+	cur, err := h.db.Collection("ruko").Find(ctx, bson.M{"owner_id": ownerOID, "external_ref": bson.M{"$in": refs}},
+		options.Find().SetProjection(bson.M{"external_ref": 1, "archived": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var r Ruko
+		if err := cur.Decode(&r); err == nil {
+			out[r.ExternalRef] = r.Archived
+		}
+	}
+	return out, cur.Err()
+}
+
+// ImportRukos: POST /api/:ownerId/rukos/import?dry_run=true&skip_invalid=true
+// (multipart, field "file", .csv or .xlsx with a header row). Rows are upserted by
+// external_ref, so the same file can be imported again to update the rukos.
+// dry_run only returns the validation report; without skip_invalid any invalid row
+// rejects the whole file. The import itself runs in the background, poll the returned job.
+func (h *Handlers) ImportRukos(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	maxSize := importMaxBytes()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+(1<<20))
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file uploaded (field: file)"})
+		return
+	}
+	if fh.Size > maxSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file too large (max %d MB)", maxSize>>20)})
+		return
+	}
+	records, err := readImportFile(fh)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, rowErrs, err := parseImportRecords(records)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	existing, err := h.existingImportRefs(ctx, ownerOID, rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check existing rukos"})
+		return
+	}
+	// archived rukos are not updated, restore them first
+	valid := rows[:0]
+	for _, row := range rows {
+		archived, ok := existing[row.Ruko.ExternalRef]
+		if ok && archived {
+			rowErrs = append(rowErrs, ImportRowError{Row: row.Line, Field: "external_ref", Error: "ruko is archived, restore it first"})
+			delete(existing, row.Ruko.ExternalRef)
+			continue
+		}
+		valid = append(valid, row)
+	}
+	rows = valid
+	sort.Slice(rowErrs, func(i, j int) bool { return rowErrs[i].Row < rowErrs[j].Row })
+
+	invalid := map[int]bool{}
+	for _, e := range rowErrs {
+		invalid[e.Row] = true
+	}
+	if rowErrs == nil {
+		rowErrs = []ImportRowError{}
+	}
+	report := gin.H{
+		"valid":   len(rows),
+		"invalid": len(invalid),
+		"creates": len(rows) - len(existing),
+		"updates": len(existing),
+		"errors":  rowErrs,
+	}
+	if c.Query("dry_run") == "true" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	if len(rowErrs) > 0 && c.Query("skip_invalid") != "true" {
+		report["error"] = "file has invalid rows, fix them or use skip_invalid=true"
+		c.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no valid rows to import"})
+		return
+	}
+
+	job := ImportJob{
+		OwnerID:   ownerOID,
+		Filename:  fh.Filename,
+		Status:    "queued",
+		Total:     len(rows),
+		Errors:    rowErrs,
+		CreatedAt: time.Now(),
+	}
+	res, err := h.db.Collection("import_jobs").InsertOne(ctx, job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create import job"})
+		return
+	}
+	job.ID = res.InsertedID.(primitive.ObjectID)
+	go h.runRukoImport(job, rows)
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// runRukoImport upserts the rows of a job, saving the progress as it goes
+func (h *Handlers) runRukoImport(job ImportJob, rows []importRow) {
+	ctx := context.Background()
+	jobs := h.db.Collection("import_jobs")
+	started := time.Now()
+	job.Status, job.StartedAt = "running", &started
+	if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"status": job.Status, "started_at": started}}); err != nil {
+		log.Println("import job error:", err)
+	}
+	progress := func() bson.M {
+		return bson.M{"processed": job.Processed, "created": job.Created, "updated": job.Updated, "failed": job.Failed}
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Println("import job panic:", rec)
+			set := progress()
+			set["status"] = "failed"
+			set["finished_at"] = time.Now()
+			if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+				log.Println("import job error:", err)
+			}
+		}
+	}()
+
+	for _, row := range rows {
+		created, err := h.upsertImportRow(ctx, job.OwnerID, row)
+		job.Processed++
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			// archived since the file was checked, the upsert did not match it
+			job.Failed++
+			job.Errors = append(job.Errors, ImportRowError{Row: row.Line, Field: "external_ref", Error: "ruko is archived, restore it first"})
+		case err != nil:
+			log.Println("import row error:", err)
+			job.Failed++
+			job.Errors = append(job.Errors, ImportRowError{Row: row.Line, Error: "failed save ruko"})
+		case created:
+			job.Created++
+		default:
+			job.Updated++
+		}
+		if job.Processed%importProgressEvery == 0 {
+			if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": progress()}); err != nil {
+				log.Println("import job error:", err)
+			}
+		}
+	}
+
+	finished := time.Now()
+	set := progress()
+	set["status"] = "completed"
+	set["errors"] = job.Errors
+	set["finished_at"] = finished
+	if job.Created+job.Updated == 0 {
+		set["status"] = "failed"
+	}
+	if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+		log.Println("import job error:", err)
+	}
+	h.notify(ctx, job.OwnerID, "import.finished", "Impor ruko selesai",
+		fmt.Sprintf("%s: %d ruko baru, %d diperbarui, %d gagal.", job.Filename, job.Created, job.Updated, job.Failed),
+		map[string]string{"job_id": job.ID.Hex()})
+}
+
+// upsertImportRow creates or updates the owner's ruko with the row's external_ref.
+// Archived rukos are not matched, the insert then fails on the unique external_ref.
+func (h *Handlers) upsertImportRow(ctx context.Context, ownerOID primitive.ObjectID, row importRow) (bool, error) {
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	for k, v := range row.Set {
+		set[k] = v
+	}
+	update := bson.M{
+		"$set": set,
+		"$setOnInsert": bson.M{
+			"owner_id":       ownerOID,
+			"is_available":   true,
+			"rented_offline": false,
+			"created_at":     now,
+		},
+	}
+	filter := bson.M{"owner_id": ownerOID, "external_ref": row.Ruko.ExternalRef, "archived": bson.M{"$ne": true}}
+	res, err := h.db.Collection("ruko").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	if res.UpsertedID == nil {
+		if r, err := h.repo.Rukos.FindOne(ctx, filter); err == nil {
+			h.publishRukoEvent(ctx, r.ID, "ruko.updated", map[string]interface{}{"fields": changedFields(set), "source": "import"})
+		}
+		return false, nil
+	}
+	// new ruko: alert tenants with matching saved searches, like CreateRuko
+	r := row.Ruko
+	r.ID, _ = res.UpsertedID.(primitive.ObjectID)
+	r.OwnerID = ownerOID
+	r.IsAvailable = true
+	h.matchSavedSearches(ctx, r)
+	h.publishRukoEvent(ctx, r.ID, "ruko.created", map[string]interface{}{"source": "import"})
+	return true, nil
+}
+
+// failStaleImportJobs marks the jobs of a previous run as failed, their goroutine is gone
+func failStaleImportJobs(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := db.Collection("import_jobs").UpdateMany(ctx,
+		bson.M{"status": bson.M{"$in": bson.A{"queued", "running"}}},
+		bson.M{"$set": bson.M{"status": "failed", "finished_at": time.Now()}})
+	if err != nil {
+		log.Println("import job cleanup error:", err)
+		return
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("Marked %d interrupted import jobs as failed\n", res.ModifiedCount)
+	}
+}
+
+// ListImportJobs: GET /api/:ownerId/imports
+func (h *Handlers) ListImportJobs(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(50).
+		SetProjection(bson.M{"errors": 0})
+	cur, err := h.db.Collection("import_jobs").Find(ctx, bson.M{"owner_id": ownerOID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list import jobs"})
+		return
+	}
+	out := []ImportJob{}
+	if err := cur.All(ctx, &out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read cursor error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GetImportJob: GET /api/:ownerId/imports/:jobId (progress and row errors)
+func (h *Handlers) GetImportJob(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	jobOID, err := primitive.ObjectIDFromHex(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+	var job ImportJob
+	err = h.db.Collection("import_jobs").FindOne(context.Background(), bson.M{"_id": jobOID, "owner_id": ownerOID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load import job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
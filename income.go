@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// payment statuses that count as received money (refunded amounts are subtracted)
+var incomePaymentStatuses = []string{"confirmed", "refunded"}
+
+const (
+	maxIncomeRange   = 5 * 366 * 24 * time.Hour
+	maxDailyBuckets  = 400
+	incomeDateLayout = "2006-01-02"
+)
+
+// incomeQuery is the date range (both days inclusive) and bucket size of an income report
+type incomeQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string // day, week, month
+	Loc         *time.Location
+}
+
+// end is the exclusive upper bound of the range
+func (q incomeQuery) end() time.Time {
+	return q.To.AddDate(0, 0, 1)
+}
+
+// parseIncomeQuery reads ?from=&to=&granularity=&tz= or the legacy ?period=YYYY-MM.
+// Defaults to the last 12 months by month.
+func parseIncomeQuery(c *gin.Context) (incomeQuery, error) {
+	q := incomeQuery{Granularity: c.DefaultQuery("granularity", "month")}
+	loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+	if err != nil {
+		return q, errors.New("invalid tz")
+	}
+	q.Loc = loc
+	if q.Granularity != "day" && q.Granularity != "week" && q.Granularity != "month" {
+		return q, errors.New("granularity must be day, week or month")
+	}
+
+	if period := c.Query("period"); period != "" {
+		t, err := time.ParseInLocation("2006-01", period, loc)
+		if err != nil {
+			return q, errors.New("invalid period, use YYYY-MM")
+		}
+		q.From, q.To = t, t.AddDate(0, 1, -1)
+		return q, nil
+	}
+
+	now := time.Now().In(loc)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	q.From, q.To = monthStart.AddDate(0, -11, 0), monthStart.AddDate(0, 1, -1)
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.ParseInLocation(incomeDateLayout, v, loc); err != nil {
+			return q, errors.New("invalid from, use YYYY-MM-DD")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.ParseInLocation(incomeDateLayout, v, loc); err != nil {
+			return q, errors.New("invalid to, use YYYY-MM-DD")
+		}
+	}
+	if q.To.Before(q.From) {
+		return q, errors.New("to must not be before from")
+	}
+	if q.end().Sub(q.From) > maxIncomeRange {
+		return q, errors.New("date range is limited to 5 years")
+	}
+	if q.Granularity == "day" && q.end().Sub(q.From) > maxDailyBuckets*24*time.Hour {
+		return q, errors.New("daily granularity is limited to 400 days")
+	}
+	return q, nil
+}
+
+// bucketKey matches the $dateToString format of the granularity
+func bucketKey(t time.Time, granularity string) string {
+	switch granularity {
+	case "day":
+		return t.Format("2006-01-02")
+	case "week":
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+func mongoDateFormat(granularity string) string {
+	switch granularity {
+	case "day":
+		return "%Y-%m-%d"
+	case "week":
+		return "%G-W%V"
+	default:
+		return "%Y-%m"
+	}
+}
+
+// buckets lists every period key of the range in order
+func (q incomeQuery) buckets() []string {
+	var out []string
+	for d := q.From; d.Before(q.end()); d = d.AddDate(0, 0, 1) {
+		k := bucketKey(d, q.Granularity)
+		if len(out) == 0 || out[len(out)-1] != k {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// netPaymentAmount is the payment amount minus what was refunded
+var netPaymentAmount = bson.M{"$subtract": bson.A{"$amount", bson.M{"$ifNull": bson.A{"$refunded_amount", 0}}}}
+
+// cashIncome groups received payments by ruko and the period they were paid in
+func cashIncome(ctx context.Context, db *mongo.Database, rukoIDs []primitive.ObjectID, q incomeQuery) (map[primitive.ObjectID]map[string]float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"status":       bson.M{"$in": incomePaymentStatuses},
+			"payment_date": bson.M{"$gte": q.From, "$lt": q.end()},
+		}}},
+		{{Key: "$lookup", Value: bson.M{"from": "bookings", "localField": "booking_id", "foreignField": "_id", "as": "booking"}}},
+		{{Key: "$unwind", Value: "$booking"}},
+		{{Key: "$match", Value: bson.M{"booking.ruko_id": bson.M{"$in": rukoIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"ruko":   "$booking.ruko_id",
+				"period": bson.M{"$dateToString": bson.M{"format": mongoDateFormat(q.Granularity), "date": "$payment_date", "timezone": q.Loc.String()}},
+			},
+			"amount": bson.M{"$sum": netPaymentAmount},
+		}}},
+	}
+	cur, err := db.Collection("payments").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Ruko   primitive.ObjectID `bson:"ruko"`
+			Period string             `bson:"period"`
+		} `bson:"_id"`
+		Amount float64 `bson:"amount"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := map[primitive.ObjectID]map[string]float64{}
+	for _, row := range rows {
+		if out[row.ID.Ruko] == nil {
+			out[row.ID.Ruko] = map[string]float64{}
+		}
+		out[row.ID.Ruko][row.ID.Period] += row.Amount
+	}
+	return out, nil
+}
+
+// recognizedIncome spreads what was paid for each booking evenly over its days of
+// occupancy, so a 12-month lease contributes to every month it covers
+func recognizedIncome(ctx context.Context, db *mongo.Database, rukoIDs []primitive.ObjectID, q incomeQuery) (map[primitive.ObjectID]map[string]float64, error) {
+	// resolve the owner's bookings in the period first so only their payments are grouped
+	bcur, err := db.Collection("bookings").Find(ctx, bson.M{
+		"ruko_id":    bson.M{"$in": rukoIDs},
+		"start_date": bson.M{"$lt": q.end()},
+		"end_date":   bson.M{"$gt": q.From},
+	}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var bookings []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := bcur.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	bookingIDs := make([]primitive.ObjectID, len(bookings))
+	for i, b := range bookings {
+		bookingIDs[i] = b.ID
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"booking_id": bson.M{"$in": bookingIDs},
+			"status":     bson.M{"$in": incomePaymentStatuses},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$booking_id", "amount": bson.M{"$sum": netPaymentAmount}}}},
+		{{Key: "$lookup", Value: bson.M{"from": "bookings", "localField": "_id", "foreignField": "_id", "as": "booking"}}},
+		{{Key: "$unwind", Value: "$booking"}},
+		{{Key: "$project", Value: bson.M{
+			"amount":     1,
+			"ruko_id":    "$booking.ruko_id",
+			"start_date": "$booking.start_date",
+			"end_date":   "$booking.end_date",
+		}}},
+	}
+	cur, err := db.Collection("payments").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Amount    float64            `bson:"amount"`
+		RukoID    primitive.ObjectID `bson:"ruko_id"`
+		StartDate time.Time          `bson:"start_date"`
+		EndDate   time.Time          `bson:"end_date"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	out := map[primitive.ObjectID]map[string]float64{}
+	for _, row := range rows {
+		start := startOfDay(row.StartDate, q.Loc)
+		days := int(startOfDay(row.EndDate, q.Loc).Sub(start).Hours()/24 + 0.5)
+		if days < 1 {
+			days = 1
+		}
+		perDay := row.Amount / float64(days)
+		if out[row.RukoID] == nil {
+			out[row.RukoID] = map[string]float64{}
+		}
+		for d := 0; d < days; d++ {
+			day := start.AddDate(0, 0, d)
+			if day.Before(q.From) {
+				continue
+			}
+			if !day.Before(q.end()) {
+				break
+			}
+			out[row.RukoID][bucketKey(day, q.Granularity)] += perDay
+		}
+	}
+	return out, nil
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+type incomePoint struct {
+	Period string  `json:"period"`
+	Amount float64 `json:"amount"`
+}
+
+type rukoIncome struct {
+	RukoID primitive.ObjectID `json:"ruko_id"`
+	Name   string             `json:"name"`
+	Total  float64            `json:"total"`
+	Series []incomePoint      `json:"series"`
+}
+
+type incomeReport struct {
+	Total  float64       `json:"total"`
+	Series []incomePoint `json:"series"`
+	ByRuko []rukoIncome  `json:"by_ruko"`
+}
+
+// buildIncomeReport fills every bucket of the range, also those without income
+func buildIncomeReport(rukos []Ruko, amounts map[primitive.ObjectID]map[string]float64, buckets []string) incomeReport {
+	rep := incomeReport{Series: make([]incomePoint, len(buckets)), ByRuko: []rukoIncome{}}
+	for i, b := range buckets {
+		rep.Series[i].Period = b
+	}
+	for _, r := range rukos {
+		ri := rukoIncome{RukoID: r.ID, Name: r.Name, Series: make([]incomePoint, len(buckets))}
+		for i, b := range buckets {
+			amount := roundRupiah(amounts[r.ID][b])
+			ri.Series[i] = incomePoint{Period: b, Amount: amount}
+			ri.Total += amount
+			rep.Series[i].Amount += amount
+		}
+		rep.Total += ri.Total
+		rep.ByRuko = append(rep.ByRuko, ri)
+	}
+	return rep
+}
+
+// helper: rukos of the owner, or only ?ruko_id= when given
+func (h *Handlers) ownerRukosFilter(c *gin.Context, ownerOID primitive.ObjectID) ([]Ruko, []primitive.ObjectID, bool) {
+	filter := bson.M{"owner_id": ownerOID}
+	if v := c.Query("ruko_id"); v != "" {
+		rid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ruko_id"})
+			return nil, nil, false
+		}
+		filter["_id"] = rid
+	}
+	ctx := context.Background()
+	cur, err := h.db.Collection("ruko").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load rukos"})
+		return nil, nil, false
+	}
+	var rukos []Ruko
+	if err := cur.All(ctx, &rukos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read cursor error"})
+		return nil, nil, false
+	}
+	rukoIDs := make([]primitive.ObjectID, len(rukos))
+	for i, r := range rukos {
+		rukoIDs[i] = r.ID
+	}
+	return rukos, rukoIDs, true
+}
+
+// GetIncomeData: GET /api/:ownerId/income?from=YYYY-MM-DD&to=YYYY-MM-DD&granularity=day|week|month&tz=&ruko_id=
+// "cash" buckets confirmed payments by payment date, "recognized" spreads them over the
+// months of occupancy. ?period=YYYY-MM is still accepted for a single month.
+func (h *Handlers) GetIncomeData(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	q, err := parseIncomeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rukos, rukoIDs, ok := h.ownerRukosFilter(c, ownerOID)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+
+	cash, err := cashIncome(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate payments"})
+		return
+	}
+	recognized, err := recognizedIncome(ctx, h.db, rukoIDs, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate payments"})
+		return
+	}
+	buckets := q.buckets()
+	recognizedReport := buildIncomeReport(rukos, recognized, buckets)
+	c.JSON(http.StatusOK, gin.H{
+		"from":        q.From.Format(incomeDateLayout),
+		"to":          q.To.Format(incomeDateLayout),
+		"granularity": q.Granularity,
+		"timezone":    q.Loc.String(),
+		"income":      recognizedReport.Total,
+		"cash":        buildIncomeReport(rukos, cash, buckets),
+		"recognized":  recognizedReport,
+	})
+}
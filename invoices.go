@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultInvoiceDueDays = 7
+	maxInstallments       = 12
+)
+
+// invoiceDueDays reads INVOICE_DUE_DAYS (default 7)
+func invoiceDueDays() int {
+	if v := os.Getenv("INVOICE_DUE_DAYS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	return defaultInvoiceDueDays
+}
+
+// withTransaction runs fn in a transaction, retried by the driver on transient errors
+// (TransientTransactionError / UnknownTransactionCommitResult), so fn must be safe to run again
+func (h *Handlers) withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	return h.repo.Tx.WithTransaction(ctx, fn)
+}
+
+// nextSequence increments the named counter. Called inside the transaction that
+// inserts the numbered document, so an aborted insert does not leave a gap.
+func (h *Handlers) nextSequence(sc mongo.SessionContext, key string) (int64, error) {
+	return h.repo.Counters.Next(sc, key)
+}
+
+// documentNumber formats e.g. INV/1A2B3C/2025/00042
+func documentNumber(prefix string, ownerID primitive.ObjectID, seq int64, at time.Time) string {
+	hex := ownerID.Hex()
+	return fmt.Sprintf("%s/%s/%d/%05d", prefix, strings.ToUpper(hex[len(hex)-6:]), at.Year(), seq)
+}
+
+// bookingPricing returns the stored price breakdown, rebuilt from the ruko for bookings made before it was stored
+func bookingPricing(b Booking, r Ruko) BookingPricing {
+	if b.Pricing != nil {
+		return *b.Pricing
+	}
+	p := calculateBookingPrice(r, b.StartDate, b.EndDate, "")
+	if p.Total != b.TotalPrice {
+		p.Discount = p.Subtotal + p.Tax - b.TotalPrice
+		p.Total = b.TotalPrice
+	}
+	return p
+}
+
+func rentalUnitLabel(unit string) string {
+	if unit == "year" {
+		return "tahun"
+	}
+	return "bulan"
+}
+
+// roundRupiah rounds to whole rupiah
+func roundRupiah(v float64) float64 {
+	return math.Round(v)
+}
+
+// buildInvoices splits the booking price into n installments; the last one takes the rounding remainder
+func buildInvoices(b Booking, r Ruko, n int, now time.Time) []Invoice {
+	p := bookingPricing(b, r)
+	due := now.AddDate(0, 0, invoiceDueDays())
+	if b.StartDate.After(now) && b.StartDate.Before(due) {
+		due = b.StartDate
+	}
+	rent := fmt.Sprintf("Sewa ruko %s (%d %s)", r.Name, p.Quantity, rentalUnitLabel(p.Unit))
+
+	out := make([]Invoice, 0, n)
+	subtotalLeft, taxLeft, discountLeft := roundRupiah(p.Subtotal), roundRupiah(p.Tax), roundRupiah(p.Discount)
+	for i := 1; i <= n; i++ {
+		inv := Invoice{
+			OwnerID:          r.OwnerID,
+			TenantID:         b.TenantID,
+			BookingID:        b.ID,
+			RukoID:           r.ID,
+			Installment:      i,
+			InstallmentCount: n,
+			TaxRate:          p.TaxRate,
+			Status:           "unpaid",
+			DueDate:          due.AddDate(0, i-1, 0),
+			IssuedAt:         now,
+			UpdatedAt:        now,
+		}
+		if i < n {
+			inv.Subtotal = roundRupiah(p.Subtotal / float64(n))
+			inv.Tax = roundRupiah(p.Tax / float64(n))
+			inv.Discount = roundRupiah(p.Discount / float64(n))
+		} else {
+			inv.Subtotal, inv.Tax, inv.Discount = subtotalLeft, taxLeft, discountLeft
+		}
+		subtotalLeft -= inv.Subtotal
+		taxLeft -= inv.Tax
+		discountLeft -= inv.Discount
+		inv.Total = inv.Subtotal + inv.Tax - inv.Discount
+
+		if n == 1 {
+			inv.Items = []InvoiceItem{{Description: rent, Quantity: p.Quantity, UnitPrice: p.UnitPrice, Amount: p.Subtotal}}
+		} else {
+			inv.Items = []InvoiceItem{{Description: fmt.Sprintf("Angsuran %d/%d - %s", i, n, rent), Quantity: 1, UnitPrice: inv.Subtotal, Amount: inv.Subtotal}}
+		}
+		out = append(out, inv)
+	}
+	return out
+}
+
+// insertInvoices numbers and stores the invoices, must run inside a transaction
+func (h *Handlers) insertInvoices(sc mongo.SessionContext, invs []Invoice) error {
+	for i := range invs {
+		seq, err := h.nextSequence(sc, "invoice:"+invs[i].OwnerID.Hex())
+		if err != nil {
+			return err
+		}
+		invs[i].Number = documentNumber("INV", invs[i].OwnerID, seq, invs[i].IssuedAt)
+		if err := h.repo.Invoices.Create(sc, &invs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureBookingInvoice issues the invoice of a booking when it has none yet
+func (h *Handlers) ensureBookingInvoice(ctx context.Context, b Booking) {
+	n, err := h.repo.Invoices.Count(ctx, bson.M{"booking_id": b.ID, "status": bson.M{"$ne": "void"}})
+	if err != nil || n > 0 {
+		return
+	}
+	r, err := h.repo.Rukos.Get(ctx, b.RukoID)
+	if err != nil {
+		log.Println("issue invoice error:", err)
+		return
+	}
+	invs := buildInvoices(b, r, 1, time.Now())
+	err = h.withTransaction(ctx, func(sc mongo.SessionContext) error { return h.insertInvoices(sc, invs) })
+	// ErrDuplicate: a concurrent request issued it first
+	if err != nil && err != ErrDuplicate {
+		log.Println("issue invoice error:", err)
+	}
+}
+
+// settleInvoices marks the open invoices covered by what the booking has paid so far as
+// paid (oldest installment first) and issues a receipt for each of them, so installments
+// smaller than an invoice add up until they settle it
+func (h *Handlers) settleInvoices(ctx context.Context, b Booking, p Payment) []Receipt {
+	h.ensureBookingInvoice(ctx, b)
+
+	open, credit, err := h.openInvoices(ctx, b.ID)
+	if err != nil {
+		log.Println("settle invoices error:", err)
+		return nil
+	}
+
+	receipts := []Receipt{}
+	for _, inv := range open {
+		// tolerance covers rounding to whole rupiah
+		if credit+1 < inv.Total {
+			break
+		}
+		rc, err := h.issueReceipt(ctx, inv, p)
+		if err != nil {
+			log.Println("issue receipt error:", err)
+			break
+		}
+		if rc != nil {
+			receipts = append(receipts, *rc)
+		}
+		credit -= inv.Total
+	}
+	return receipts
+}
+
+// openInvoices returns the unpaid invoices of a booking, oldest installment first, and the
+// amount paid on the booking that the paid invoices do not account for yet
+func (h *Handlers) openInvoices(ctx context.Context, bookingID primitive.ObjectID) ([]Invoice, float64, error) {
+	invs, err := h.repo.Invoices.Find(ctx, bson.M{"booking_id": bookingID, "status": bson.M{"$in": []string{"unpaid", "paid"}}},
+		options.Find().SetSort(bson.D{{Key: "installment", Value: 1}}))
+	if err != nil {
+		return nil, 0, err
+	}
+	credit, err := h.bookingPaidAmount(ctx, bookingID)
+	if err != nil {
+		return nil, 0, err
+	}
+	open := []Invoice{}
+	for _, inv := range invs {
+		if inv.Status == "paid" {
+			credit -= inv.Total
+		} else {
+			open = append(open, inv)
+		}
+	}
+	return open, math.Max(credit, 0), nil
+}
+
+// outstandingAmount is what is left to pay on the unpaid invoices of a booking
+func (h *Handlers) outstandingAmount(ctx context.Context, bookingID primitive.ObjectID) (float64, error) {
+	open, credit, err := h.openInvoices(ctx, bookingID)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, inv := range open {
+		total += inv.Total
+	}
+	return math.Max(total-credit, 0), nil
+}
+
+var errInvoiceNotOpen = errors.New("invoice is not open")
+
+// issueReceipt marks the invoice paid and stores its receipt in one transaction
+func (h *Handlers) issueReceipt(ctx context.Context, inv Invoice, p Payment) (*Receipt, error) {
+	now := time.Now()
+	paidAt := p.PaymentDate
+	if paidAt.IsZero() {
+		paidAt = now
+	}
+	rc := Receipt{
+		OwnerID:       inv.OwnerID,
+		TenantID:      inv.TenantID,
+		BookingID:     inv.BookingID,
+		InvoiceID:     inv.ID,
+		InvoiceNumber: inv.Number,
+		PaymentID:     p.ID,
+		PaymentMethod: p.PaymentMethod,
+		Amount:        inv.Total,
+		PaidAt:        paidAt,
+		IssuedAt:      now,
+	}
+	err := h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		err := h.repo.Invoices.UpdateWhere(sc, bson.M{"_id": inv.ID, "status": "unpaid"}, bson.M{
+			"status":     "paid",
+			"payment_id": p.ID,
+			"paid_at":    paidAt,
+			"updated_at": now,
+		})
+		if err == ErrNotFound {
+			return errInvoiceNotOpen
+		}
+		if err != nil {
+			return err
+		}
+		seq, err := h.nextSequence(sc, "receipt:"+inv.OwnerID.Hex())
+		if err != nil {
+			return err
+		}
+		rc.Number = documentNumber("RCP", inv.OwnerID, seq, now)
+		return h.repo.Receipts.Create(sc, &rc)
+	})
+	if err == errInvoiceNotOpen {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// markOverdue reports unpaid invoices past their due date as "overdue"
+func markOverdue(invs []Invoice, now time.Time) {
+	for i := range invs {
+		if invs[i].Status == "unpaid" && invs[i].DueDate.Before(now) {
+			invs[i].Status = "overdue"
+		}
+	}
+}
+
+// ListInvoices: GET /api/invoices?status=unpaid|paid|void|overdue&page=&limit=
+// invoices issued by (owner) or addressed to (tenant) the current user
+func (h *Handlers) ListInvoices(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{{"owner_id": uid}, {"tenant_id": uid}}}
+	switch status := c.Query("status"); status {
+	case "":
+	case "overdue":
+		filter["status"] = "unpaid"
+		filter["due_date"] = bson.M{"$lt": now}
+	case "unpaid", "paid", "void":
+		filter["status"] = status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "issued_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	out, err := h.repo.Invoices.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list invoices"})
+		return
+	}
+	markOverdue(out, now)
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": page, "limit": limit})
+}
+
+// ListBookingInvoices: GET /api/bookings/:id/invoices
+func (h *Handlers) ListBookingInvoices(c *gin.Context) {
+	b, _, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	out, err := h.repo.Invoices.Find(context.Background(), bson.M{"booking_id": b.ID},
+		options.Find().SetSort(bson.D{{Key: "issued_at", Value: 1}, {Key: "installment", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list invoices"})
+		return
+	}
+	markOverdue(out, time.Now())
+	c.JSON(http.StatusOK, out)
+}
+
+// SplitBookingInvoice: POST /api/bookings/:id/invoices/split {"installments": 3}
+// owner/admin; voids the open invoices and issues one invoice per installment
+func (h *Handlers) SplitBookingInvoice(c *gin.Context) {
+	b, r, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	uid, _ := GetUserIDFromContext(c)
+	if uid != r.OwnerID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the owner can split invoices"})
+		return
+	}
+	var in struct {
+		Installments int `json:"installments" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Installments < 1 || in.Installments > maxInstallments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("installments must be between 1 and %d", maxInstallments)})
+		return
+	}
+	ctx := context.Background()
+	paid, err := h.repo.Invoices.Count(ctx, bson.M{"booking_id": b.ID, "status": "paid"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load invoices"})
+		return
+	}
+	if paid > 0 || b.PaymentStatus == "paid" || b.PaymentStatus == "partial" {
+		c.JSON(http.StatusConflict, gin.H{"error": "booking already has paid invoices"})
+		return
+	}
+
+	now := time.Now()
+	invs := buildInvoices(b, r, in.Installments, now)
+	err = h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		if _, err := h.repo.Invoices.UpdateMany(sc,
+			bson.M{"booking_id": b.ID, "status": "unpaid"},
+			bson.M{"status": "void", "updated_at": now}); err != nil {
+			return err
+		}
+		return h.insertInvoices(sc, invs)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed issue invoices"})
+		return
+	}
+	h.notify(ctx, b.TenantID, "invoice.issued",
+		"Tagihan diperbarui",
+		fmt.Sprintf("Tagihan booking Anda dibagi menjadi %d angsuran", in.Installments),
+		map[string]string{"booking_id": b.ID.Hex()})
+	c.JSON(http.StatusCreated, invs)
+}
+
+// helper: load invoice by :id for its owner, tenant or an admin
+func (h *Handlers) loadInvoiceForParty(c *gin.Context) (Invoice, bool) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return Invoice{}, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return Invoice{}, false
+	}
+	inv, err := h.repo.Invoices.Get(context.Background(), oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invoice not found"})
+		return inv, false
+	}
+	if uid != inv.OwnerID && uid != inv.TenantID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return inv, false
+	}
+	return inv, true
+}
+
+// GetInvoice: GET /api/invoices/:id
+func (h *Handlers) GetInvoice(c *gin.Context) {
+	inv, ok := h.loadInvoiceForParty(c)
+	if !ok {
+		return
+	}
+	invs := []Invoice{inv}
+	markOverdue(invs, time.Now())
+	c.JSON(http.StatusOK, invs[0])
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(pdfTemplateFuncs).Parse(`Nomor: {{.Invoice.Number}}
+Tanggal: {{date .Invoice.IssuedAt}}
+Jatuh tempo: {{date .Invoice.DueDate}}
+Status: {{.Invoice.Status}}
+{{- if gt .Invoice.InstallmentCount 1}}
+Angsuran: {{.Invoice.Installment}} dari {{.Invoice.InstallmentCount}}{{end}}
+
+## DARI
+{{.Owner.Name}}
+{{.Owner.Email}}
+
+## KEPADA
+{{.Tenant.Name}}
+{{.Tenant.Email}}
+
+## RINCIAN
+{{range .Invoice.Items}}{{.Description}}: {{.Quantity}} x {{rupiah .UnitPrice}} = {{rupiah .Amount}}
+{{end}}
+Subtotal: {{rupiah .Invoice.Subtotal}}
+Pajak ({{percent .Invoice.TaxRate}}): {{rupiah .Invoice.Tax}}
+{{- if .Invoice.Discount}}
+Diskon{{if .Booking.Pricing}}{{with .Booking.Pricing.DiscountCode}} ({{.}}){{end}}{{end}}: -{{rupiah .Invoice.Discount}}{{end}}
+Total: {{rupiah .Invoice.Total}}
+
+Periode sewa: {{date .Booking.StartDate}} - {{date .Booking.EndDate}}
+Booking ID: {{.Booking.ID.Hex}}
+`))
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(pdfTemplateFuncs).Parse(`Nomor: {{.Receipt.Number}}
+Tanggal: {{date .Receipt.IssuedAt}}
+
+Telah diterima dari {{.Tenant.Name}} pembayaran sebesar {{rupiah .Receipt.Amount}}
+untuk tagihan {{.Receipt.InvoiceNumber}}.
+
+Tanggal bayar: {{date .Receipt.PaidAt}}
+Metode pembayaran: {{.Receipt.PaymentMethod}}
+
+## PENERIMA
+{{.Owner.Name}}
+{{.Owner.Email}}
+
+Sewa ruko {{.Ruko.Name}}, {{date .Booking.StartDate}} - {{date .Booking.EndDate}}
+Booking ID: {{.Booking.ID.Hex}}
+`))
+
+// helper: render a document of a booking as pdf and write it to the response
+func (h *Handlers) writeBookingPDF(c *gin.Context, bookingID primitive.ObjectID, tpl *template.Template, title, filename string, extra map[string]interface{}) {
+	d, err := h.loadContractData(context.Background(), bookingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load booking data"})
+		return
+	}
+	data := map[string]interface{}{"Owner": d.Owner, "Tenant": d.Tenant, "Ruko": d.Ruko, "Booking": d.Booking}
+	for k, v := range extra {
+		data[k] = v
+	}
+	var text bytes.Buffer
+	if err := tpl.Execute(&text, data); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed render document"})
+		return
+	}
+	pdfBytes, err := renderTextPDF(title, text.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed render document"})
+		return
+	}
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/pdf", pdfBytes)
+}
+
+// GetInvoicePDF: GET /api/invoices/:id/pdf
+func (h *Handlers) GetInvoicePDF(c *gin.Context) {
+	inv, ok := h.loadInvoiceForParty(c)
+	if !ok {
+		return
+	}
+	invs := []Invoice{inv}
+	markOverdue(invs, time.Now())
+	filename := "invoice-" + strings.ReplaceAll(inv.Number, "/", "-") + ".pdf"
+	h.writeBookingPDF(c, inv.BookingID, invoiceTemplate, "INVOICE", filename, map[string]interface{}{"Invoice": invs[0]})
+}
+
+// ListBookingReceipts: GET /api/bookings/:id/receipts
+func (h *Handlers) ListBookingReceipts(c *gin.Context) {
+	b, _, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	out, err := h.repo.Receipts.Find(context.Background(), bson.M{"booking_id": b.ID},
+		options.Find().SetSort(bson.D{{Key: "issued_at", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list receipts"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// helper: load receipt by :id for its owner, tenant or an admin
+func (h *Handlers) loadReceiptForParty(c *gin.Context) (Receipt, bool) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return Receipt{}, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return Receipt{}, false
+	}
+	rc, err := h.repo.Receipts.Get(context.Background(), oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "receipt not found"})
+		return rc, false
+	}
+	if uid != rc.OwnerID && uid != rc.TenantID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return rc, false
+	}
+	return rc, true
+}
+
+// GetReceipt: GET /api/receipts/:id
+func (h *Handlers) GetReceipt(c *gin.Context) {
+	rc, ok := h.loadReceiptForParty(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, rc)
+}
+
+// GetReceiptPDF: GET /api/receipts/:id/pdf
+func (h *Handlers) GetReceiptPDF(c *gin.Context) {
+	rc, ok := h.loadReceiptForParty(c)
+	if !ok {
+		return
+	}
+	filename := "receipt-" + strings.ReplaceAll(rc.Number, "/", "-") + ".pdf"
+	h.writeBookingPDF(c, rc.BookingID, receiptTemplate, "KWITANSI", filename, map[string]interface{}{"Receipt": rc})
+}
@@ -0,0 +1,812 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ledger accounts
+const (
+	accountCash         = "cash"          // money held by the platform
+	accountPlatformFee  = "platform_fee"  // commission earned by the platform
+	accountOwnerPayable = "owner_payable" // what the platform owes the owners
+)
+
+const defaultCommissionPercent = 10.0
+
+// commissionPercent reads PLATFORM_COMMISSION_PERCENT (default 10)
+func commissionPercent() float64 {
+	if v := os.Getenv("PLATFORM_COMMISSION_PERCENT"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed >= 0 && parsed <= 100 {
+			return parsed
+		}
+	}
+	return defaultCommissionPercent
+}
+
+// postLedger stores a balanced transaction. Posting the same key twice is a no-op.
+func postLedger(ctx context.Context, ledger LedgerRepo, tx LedgerTransaction) error {
+	var debit, credit float64
+	for _, e := range tx.Entries {
+		debit += e.Debit
+		credit += e.Credit
+	}
+	if math.Abs(debit-credit) > 0.001 {
+		return fmt.Errorf("unbalanced ledger transaction %s: debit %.2f credit %.2f", tx.Key, debit, credit)
+	}
+	if tx.CreatedAt.IsZero() {
+		tx.CreatedAt = time.Now()
+	}
+	err := ledger.Create(ctx, &tx)
+	if err == ErrDuplicate {
+		return nil
+	}
+	return err
+}
+
+// paymentOwner returns the owner of the ruko a payment was made for
+func paymentOwner(ctx context.Context, repo Repositories, p Payment) (primitive.ObjectID, error) {
+	b, err := repo.Bookings.Get(ctx, p.BookingID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	r, err := repo.Rukos.Get(ctx, b.RukoID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return r.OwnerID, nil
+}
+
+// recordPaymentLedger posts a confirmed tenant payment: the platform receives the cash,
+// keeps its commission and owes the rest to the owner. Cash payments are collected by
+// the owner in person, so the owner's payable is reduced by the collected amount.
+func recordPaymentLedger(ctx context.Context, repo Repositories, p Payment) error {
+	ownerID, err := paymentOwner(ctx, repo, p)
+	if err != nil {
+		return err
+	}
+	fee := roundRupiah(p.Amount * commissionPercent() / 100)
+	paymentID, bookingID := p.ID, p.BookingID
+	err = postLedger(ctx, repo.Ledger, LedgerTransaction{
+		Key:       "payment:" + p.ID.Hex(),
+		Type:      "payment",
+		OwnerID:   ownerID,
+		BookingID: &bookingID,
+		PaymentID: &paymentID,
+		Entries: []LedgerEntry{
+			{Account: accountCash, Debit: p.Amount},
+			{Account: accountPlatformFee, Credit: fee},
+			{Account: accountOwnerPayable, Credit: p.Amount - fee},
+		},
+		Memo:      fmt.Sprintf("%s payment, commission %g%%", p.PaymentMethod, commissionPercent()),
+		CreatedAt: p.PaymentDate,
+	})
+	if err != nil || p.PaymentMethod != "cash" {
+		return err
+	}
+	return postLedger(ctx, repo.Ledger, LedgerTransaction{
+		Key:       "collection:" + p.ID.Hex(),
+		Type:      "owner_collection",
+		OwnerID:   ownerID,
+		BookingID: &bookingID,
+		PaymentID: &paymentID,
+		Entries: []LedgerEntry{
+			{Account: accountOwnerPayable, Debit: p.Amount},
+			{Account: accountCash, Credit: p.Amount},
+		},
+		Memo:      "cash collected by the owner",
+		CreatedAt: p.PaymentDate,
+	})
+}
+
+// backfillPaymentLedger posts confirmed payments made before the ledger existed
+func backfillPaymentLedger(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	recorded, err := db.Collection("ledger").Distinct(ctx, "payment_id", bson.M{"type": "payment"})
+	if err != nil {
+		log.Println("ledger backfill error:", err)
+		return
+	}
+	if recorded == nil {
+		recorded = []interface{}{}
+	}
+	cur, err := db.Collection("payments").Find(ctx, bson.M{
+		"status": "confirmed",
+		"_id":    bson.M{"$nin": recorded},
+	})
+	if err != nil {
+		log.Println("ledger backfill error:", err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	repo := NewMongoRepositories(db)
+	posted := 0
+	for cur.Next(ctx) {
+		var p Payment
+		if err := cur.Decode(&p); err != nil {
+			continue
+		}
+		if err := recordPaymentLedger(ctx, repo, p); err != nil {
+			log.Println("ledger backfill error:", err)
+			continue
+		}
+		posted++
+	}
+	if posted > 0 {
+		log.Printf("ledger backfill: posted %d payments\n", posted)
+	}
+}
+
+// OwnerBalance summarizes the ledger of one owner
+type OwnerBalance struct {
+	OwnerID       primitive.ObjectID `json:"owner_id"`
+	GrossPayments float64            `json:"gross_payments"` // tenant payments net of refunds
+	PlatformFee   float64            `json:"platform_fee"`
+	TotalIncome   float64            `json:"total_income"` // owner share of the payments net of refunds
+	PaidOut       float64            `json:"paid_out"`
+	Collected     float64            `json:"collected"`       // cash the owner received directly
+	Payable       float64            `json:"payable_balance"` // what the platform still owes the owner (negative: owner owes commission)
+	PendingPayout float64            `json:"pending_payout"`
+	Available     float64            `json:"available"`
+}
+
+// ownerBalances sums the ledger per owner; nil owners means all owners
+func ownerBalances(ctx context.Context, repo Repositories, owners []primitive.ObjectID) (map[primitive.ObjectID]*OwnerBalance, error) {
+	rows, err := repo.Ledger.Totals(ctx, owners)
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[primitive.ObjectID]*OwnerBalance{}
+	get := func(id primitive.ObjectID) *OwnerBalance {
+		if out[id] == nil {
+			out[id] = &OwnerBalance{OwnerID: id}
+		}
+		return out[id]
+	}
+	for _, id := range owners {
+		get(id)
+	}
+	for _, row := range rows {
+		b := get(row.OwnerID)
+		net := row.Credit - row.Debit
+		switch row.Account {
+		case accountOwnerPayable:
+			b.Payable += net
+			switch row.Type {
+			case "payment", "refund":
+				b.TotalIncome += net
+			case "payout":
+				b.PaidOut -= net
+			case "owner_collection":
+				b.Collected -= net
+			}
+		case accountPlatformFee:
+			b.PlatformFee += net
+		case accountCash:
+			if row.Type == "payment" || row.Type == "refund" {
+				b.GrossPayments -= net
+			}
+		}
+	}
+
+	pending, err := pendingPayouts(ctx, repo.PayoutBatches, owners)
+	if err != nil {
+		return nil, err
+	}
+	for id, amount := range pending {
+		get(id).PendingPayout = amount
+	}
+	for _, b := range out {
+		b.Available = b.Payable - b.PendingPayout
+	}
+	return out, nil
+}
+
+// pendingPayouts sums the unpaid items of open payout batches per owner
+// (only a handful of batches are open at a time)
+func pendingPayouts(ctx context.Context, batches PayoutBatchRepo, owners []primitive.ObjectID) (map[primitive.ObjectID]float64, error) {
+	filter := bson.M{"status": bson.M{"$in": []string{"pending", "processing"}}}
+	wanted := map[primitive.ObjectID]bool{}
+	if owners != nil {
+		filter["items.owner_id"] = bson.M{"$in": owners}
+		for _, id := range owners {
+			wanted[id] = true
+		}
+	}
+	open, err := batches.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	out := map[primitive.ObjectID]float64{}
+	for _, b := range open {
+		for _, it := range b.Items {
+			if it.Status == "pending" && (owners == nil || wanted[it.OwnerID]) {
+				out[it.OwnerID] += it.Amount
+			}
+		}
+	}
+	return out, nil
+}
+
+// ownerBalance returns the ledger summary of a single owner
+func ownerBalance(ctx context.Context, repo Repositories, ownerID primitive.ObjectID) (OwnerBalance, error) {
+	all, err := ownerBalances(ctx, repo, []primitive.ObjectID{ownerID})
+	if err != nil {
+		return OwnerBalance{OwnerID: ownerID}, err
+	}
+	return *all[ownerID], nil
+}
+
+// helper: parse :ownerId, owners can only see their own data
+func ownerParam(c *gin.Context) (primitive.ObjectID, bool) {
+	ownerOID, err := primitive.ObjectIDFromHex(c.Param("ownerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner id"})
+		return ownerOID, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return ownerOID, false
+	}
+	if c.GetString("role") != "admin" && uid != ownerOID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not your account"})
+		return ownerOID, false
+	}
+	return ownerOID, true
+}
+
+// GetOwnerBalance: GET /api/:ownerId/balance
+func (h *Handlers) GetOwnerBalance(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	b, err := ownerBalance(context.Background(), h.repo, ownerOID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load balance"})
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// GetOwnerLedger: GET /api/:ownerId/ledger?page=&limit=
+func (h *Handlers) GetOwnerLedger(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	out, err := h.repo.Ledger.Find(context.Background(), bson.M{"owner_id": ownerOID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list ledger"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": page, "limit": limit})
+}
+
+// GetOwnerPayouts: GET /api/:ownerId/payouts (only the owner's item of each batch)
+func (h *Handlers) GetOwnerPayouts(c *gin.Context) {
+	ownerOID, ok := ownerParam(c)
+	if !ok {
+		return
+	}
+	batches, err := h.repo.PayoutBatches.Find(context.Background(), bson.M{"items.owner_id": ownerOID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list payouts"})
+		return
+	}
+	out := []gin.H{}
+	for _, b := range batches {
+		for _, it := range b.Items {
+			if it.OwnerID == ownerOID {
+				out = append(out, gin.H{"batch_id": b.ID, "batch_status": b.Status, "created_at": b.CreatedAt, "payout": it})
+			}
+		}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GetLedgerBalances: GET /api/ledger/balances
+// account totals of the whole platform plus the balance of every owner
+func (h *Handlers) GetLedgerBalances(c *gin.Context) {
+	ctx := context.Background()
+	rows, err := h.repo.Ledger.Totals(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load ledger"})
+		return
+	}
+	type accountTotal struct {
+		Debit  float64 `json:"debit"`
+		Credit float64 `json:"credit"`
+	}
+	accounts := map[string]*accountTotal{}
+	var debit, credit float64
+	for _, row := range rows {
+		if accounts[row.Account] == nil {
+			accounts[row.Account] = &accountTotal{}
+		}
+		accounts[row.Account].Debit += row.Debit
+		accounts[row.Account].Credit += row.Credit
+		debit += row.Debit
+		credit += row.Credit
+	}
+
+	owners, err := ownerBalances(ctx, h.repo, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load owner balances"})
+		return
+	}
+	list := []OwnerBalance{}
+	for _, b := range owners {
+		list = append(list, *b)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"accounts": accounts,
+		"balanced": math.Abs(debit-credit) < 0.01,
+		"owners":   list,
+	})
+}
+
+// RefundPayment: POST /api/payments/:id/refund {"amount"?, "reason"}
+// reverses the payment proportionally from the owner's share and the platform fee
+func (h *Handlers) RefundPayment(c *gin.Context) {
+	pid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var in struct {
+		Amount float64 `json:"amount"` // full remaining amount when empty
+		Reason string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := context.Background()
+	p, err := h.repo.Payments.Get(ctx, pid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	if p.Status != "confirmed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only confirmed payments can be refunded"})
+		return
+	}
+	refundable := p.Amount - p.RefundedAmount
+	if in.Amount == 0 {
+		in.Amount = refundable
+	}
+	if in.Amount <= 0 || in.Amount > refundable+0.001 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("amount must be between 0 and %.0f", refundable)})
+		return
+	}
+
+	original, err := h.repo.Ledger.FindOne(ctx, bson.M{"key": "payment:" + pid.Hex()})
+	if err != nil {
+		if err := recordPaymentLedger(ctx, h.repo, p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "payment is not in the ledger"})
+			return
+		}
+		original, _ = h.repo.Ledger.FindOne(ctx, bson.M{"key": "payment:" + pid.Hex()})
+	}
+	var fee float64
+	for _, e := range original.Entries {
+		if e.Account == accountPlatformFee {
+			fee = e.Credit
+		}
+	}
+	feeShare := roundRupiah(fee * in.Amount / p.Amount)
+
+	// the payment update only matches the refunded amount read above, so concurrent refunds cannot exceed it
+	status := "confirmed"
+	if in.Amount >= refundable-0.001 {
+		status = "refunded"
+	}
+	adminID, _ := GetUserIDFromContext(c)
+	bookingID := p.BookingID
+	tx := LedgerTransaction{
+		Key:       fmt.Sprintf("refund:%s:%s", pid.Hex(), primitive.NewObjectID().Hex()),
+		Type:      "refund",
+		OwnerID:   original.OwnerID,
+		BookingID: &bookingID,
+		PaymentID: &pid,
+		Entries: []LedgerEntry{
+			{Account: accountOwnerPayable, Debit: in.Amount - feeShare},
+			{Account: accountPlatformFee, Debit: feeShare},
+			{Account: accountCash, Credit: in.Amount},
+		},
+		Memo: fmt.Sprintf("refund by %s: %s", adminID.Hex(), in.Reason),
+	}
+	var released *Ruko
+	err = h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		err := h.repo.Payments.UpdateWhere(sc,
+			bson.M{"_id": pid, "status": "confirmed", "refunded_amount": bson.M{"$in": []interface{}{p.RefundedAmount, nil}}},
+			bson.M{"refunded_amount": p.RefundedAmount + in.Amount, "status": status, "updated_at": time.Now()})
+		if err == ErrNotFound {
+			return errPaymentChanged
+		}
+		if err != nil {
+			return err
+		}
+		if err := postLedger(sc, h.repo.Ledger, tx); err != nil {
+			return err
+		}
+		released, err = h.applyRefundToBooking(sc, p.BookingID)
+		return err
+	})
+	if err == errPaymentChanged {
+		c.JSON(http.StatusConflict, gin.H{"error": "payment was changed, try again"})
+		return
+	}
+	if err != nil {
+		log.Println("refund error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed record refund, nothing was saved"})
+		return
+	}
+
+	if b, err := h.repo.Bookings.Get(ctx, p.BookingID); err == nil {
+		h.notify(ctx, b.TenantID, "payment.refunded",
+			"Dana dikembalikan",
+			fmt.Sprintf("Pengembalian dana sebesar %s telah diproses", formatRupiah(in.Amount)),
+			map[string]string{"booking_id": b.ID.Hex(), "payment_id": pid.Hex()})
+		h.notifyRukoOwner(ctx, b.RukoID, "payment.refunded",
+			"Dana dikembalikan",
+			fmt.Sprintf("Pembayaran sebesar %s dikembalikan ke penyewa: %s", formatRupiah(in.Amount), in.Reason),
+			map[string]string{"booking_id": b.ID.Hex(), "payment_id": pid.Hex()})
+		h.publishRukoEvent(ctx, b.RukoID, "payment.refunded",
+			map[string]interface{}{"booking_id": b.ID.Hex(), "payment_id": pid.Hex(), "amount": in.Amount})
+	}
+	if released != nil {
+		h.announceRukoReleased(ctx, *released)
+	}
+	c.JSON(http.StatusOK, gin.H{"refunded": in.Amount, "payment_status": status, "ledger": tx})
+}
+
+// applyRefundToBooking brings a booking in line with what is still paid after a refund:
+// the booking and its rental history get the new amount, the invoices no longer covered
+// are open again (latest installment first), and a booking with nothing left paid is
+// cancelled and lets go of the ruko. Returns the ruko when it became available.
+func (h *Handlers) applyRefundToBooking(sc mongo.SessionContext, bookingID primitive.ObjectID) (*Ruko, error) {
+	b, err := h.repo.Bookings.Get(sc, bookingID)
+	if err != nil {
+		return nil, fmt.Errorf("load booking: %w", err)
+	}
+	paid, err := h.bookingPaidAmount(sc, bookingID)
+	if err != nil {
+		return nil, fmt.Errorf("load payments: %w", err)
+	}
+	now := time.Now()
+
+	settled, err := h.repo.Invoices.Find(sc, bson.M{"booking_id": bookingID, "status": "paid"},
+		options.Find().SetSort(bson.D{{Key: "installment", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("load invoices: %w", err)
+	}
+	var covered float64
+	for _, inv := range settled {
+		covered += inv.Total
+	}
+	// 1 rupiah covers invoice rounding
+	for _, inv := range settled {
+		if covered <= paid+1 {
+			break
+		}
+		err := h.repo.Invoices.Update(sc, inv.ID, bson.M{"status": "unpaid", "payment_id": Unset, "paid_at": Unset, "updated_at": now})
+		if err != nil {
+			return nil, fmt.Errorf("reopen invoice: %w", err)
+		}
+		covered -= inv.Total
+	}
+
+	if _, err := h.repo.RentalHistory.UpdateMany(sc, bson.M{"booking_id": bookingID}, bson.M{"total_paid": paid, "updated_at": now}); err != nil {
+		return nil, fmt.Errorf("update rental history: %w", err)
+	}
+
+	set := bson.M{"payment_status": "paid", "updated_at": now}
+	switch {
+	case paid < 1:
+		set["payment_status"] = "refunded"
+		if slices.Contains(activeBookingStatuses, b.BookingStatus) {
+			set["booking_status"] = "cancelled"
+			set["cancelled_at"] = now
+		}
+	case paid+1 < b.TotalPrice:
+		set["payment_status"] = "partial"
+	}
+	if err := h.repo.Bookings.Update(sc, bookingID, set); err != nil {
+		return nil, fmt.Errorf("update booking: %w", err)
+	}
+	if set["booking_status"] != "cancelled" {
+		return nil, nil
+	}
+	r, released, err := h.freeRuko(sc, b.RukoID)
+	if err != nil {
+		return nil, fmt.Errorf("release ruko: %w", err)
+	}
+	if !released {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+var (
+	errPaymentChanged  = errors.New("payment was changed")
+	errNoPayoutBalance = errors.New("no owner balance to pay out")
+	errPayoutProcessed = errors.New("payout item was already processed")
+)
+
+// CreatePayoutBatch: POST /api/payouts {"owner_ids"?: [], "min_amount"?: 100000}
+// one item per owner with a positive available balance
+func (h *Handlers) CreatePayoutBatch(c *gin.Context) {
+	var in struct {
+		OwnerIDs  []string `json:"owner_ids"`
+		MinAmount float64  `json:"min_amount"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var owners []primitive.ObjectID
+	for _, s := range in.OwnerIDs {
+		oid, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner id " + s})
+			return
+		}
+		owners = append(owners, oid)
+	}
+	if in.MinAmount < 1 {
+		in.MinAmount = 1
+	}
+	ctx := context.Background()
+	adminID, _ := GetUserIDFromContext(c)
+	var batch PayoutBatch
+	err := h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		// every batch bumps the same counter first, so concurrent batches conflict and the
+		// retried one reads the balances with the other batch already pending
+		if _, err := h.nextSequence(sc, "payout_batch"); err != nil {
+			return err
+		}
+		balances, err := ownerBalances(sc, h.repo, owners)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		batch = PayoutBatch{Status: "pending", Items: []PayoutItem{}, CreatedBy: adminID, CreatedAt: now, UpdatedAt: now}
+		for id, b := range balances {
+			if b.Available >= in.MinAmount {
+				batch.Items = append(batch.Items, PayoutItem{OwnerID: id, Amount: b.Available, Status: "pending"})
+				batch.Total += b.Available
+			}
+		}
+		if len(batch.Items) == 0 {
+			return errNoPayoutBalance
+		}
+		return h.repo.PayoutBatches.Create(sc, &batch)
+	})
+	if err == errNoPayoutBalance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no owner balance to pay out"})
+		return
+	}
+	if err != nil {
+		log.Println("create payout batch error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create payout batch"})
+		return
+	}
+	setAuditTarget(c, batch.ID)
+	c.JSON(http.StatusCreated, batch)
+}
+
+// ListPayoutBatches: GET /api/payouts?status=
+func (h *Handlers) ListPayoutBatches(c *gin.Context) {
+	filter := bson.M{}
+	if s := c.Query("status"); s != "" {
+		filter["status"] = s
+	}
+	out, err := h.repo.PayoutBatches.Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list payouts"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// helper: load payout batch by :id
+func (h *Handlers) loadPayoutBatch(c *gin.Context) (PayoutBatch, bool) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return PayoutBatch{}, false
+	}
+	b, err := h.repo.PayoutBatches.Get(context.Background(), oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payout batch not found"})
+		return b, false
+	}
+	return b, true
+}
+
+// GetPayoutBatch: GET /api/payouts/:id
+func (h *Handlers) GetPayoutBatch(c *gin.Context) {
+	b, ok := h.loadPayoutBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// UpdatePayoutItem: PATCH /api/payouts/:id/items/:ownerId {"status": "paid"|"failed", "reference", "reason"}
+// a paid item moves the amount out of the owner's payable balance
+func (h *Handlers) UpdatePayoutItem(c *gin.Context) {
+	batch, ok := h.loadPayoutBatch(c)
+	if !ok {
+		return
+	}
+	ownerOID, err := primitive.ObjectIDFromHex(c.Param("ownerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner id"})
+		return
+	}
+	var in struct {
+		Status    string `json:"status" binding:"required"`
+		Reference string `json:"reference"`
+		Reason    string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Status != "paid" && in.Status != "failed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be paid or failed"})
+		return
+	}
+	if batch.Status != "pending" && batch.Status != "processing" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payout batch is " + batch.Status})
+		return
+	}
+	var item *PayoutItem
+	for i := range batch.Items {
+		if batch.Items[i].OwnerID == ownerOID {
+			item = &batch.Items[i]
+		}
+	}
+	if item == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "owner is not part of this batch"})
+		return
+	}
+	if item.Status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "payout item is already " + item.Status})
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	amount := item.Amount
+	// the item and its ledger entry are written together, a paid item always leaves the payable
+	err = h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		current, err := h.repo.PayoutBatches.Get(sc, batch.ID)
+		if err != nil {
+			return err
+		}
+		items := append([]PayoutItem{}, current.Items...)
+		i := slices.IndexFunc(items, func(it PayoutItem) bool { return it.OwnerID == ownerOID })
+		if i < 0 || items[i].Status != "pending" {
+			return errPayoutProcessed
+		}
+		items[i].Status = in.Status
+		if in.Status == "paid" {
+			items[i].PaidAt = &now
+			items[i].Reference = in.Reference
+		} else {
+			items[i].FailureReason = in.Reason
+		}
+		amount = items[i].Amount
+		// updated_at changes with every write, so a concurrent update of another item is not lost
+		err = h.repo.PayoutBatches.UpdateWhere(sc,
+			bson.M{"_id": batch.ID, "updated_at": current.UpdatedAt},
+			bson.M{"items": items, "status": "processing", "updated_at": now})
+		if err == ErrNotFound {
+			return errPayoutProcessed
+		}
+		if err != nil || in.Status != "paid" {
+			return err
+		}
+		batchID := batch.ID
+		return postLedger(sc, h.repo.Ledger, LedgerTransaction{
+			Key:      fmt.Sprintf("payout:%s:%s", batch.ID.Hex(), ownerOID.Hex()),
+			Type:     "payout",
+			OwnerID:  ownerOID,
+			PayoutID: &batchID,
+			Entries: []LedgerEntry{
+				{Account: accountOwnerPayable, Debit: amount},
+				{Account: accountCash, Credit: amount},
+			},
+			Memo:      "payout " + in.Reference,
+			CreatedAt: now,
+		})
+	})
+	if err == errPayoutProcessed {
+		c.JSON(http.StatusConflict, gin.H{"error": "payout item was already processed"})
+		return
+	}
+	if err != nil {
+		log.Println("update payout error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update payout, nothing was saved"})
+		return
+	}
+
+	if in.Status == "paid" {
+		h.notify(ctx, ownerOID, "payout.paid",
+			"Dana dicairkan",
+			fmt.Sprintf("Pencairan dana sebesar %s telah ditransfer", formatRupiah(amount)),
+			map[string]string{"payout_id": batch.ID.Hex()})
+	}
+
+	// close the batch once every item is settled
+	_ = h.repo.PayoutBatches.UpdateWhere(ctx,
+		bson.M{"_id": batch.ID, "items.status": bson.M{"$ne": "pending"}},
+		bson.M{"status": "completed", "completed_at": now})
+
+	if updated, err := h.repo.PayoutBatches.Get(ctx, batch.ID); err == nil {
+		batch = updated
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// CancelPayoutBatch: POST /api/payouts/:id/cancel (only before any item is paid)
+func (h *Handlers) CancelPayoutBatch(c *gin.Context) {
+	batch, ok := h.loadPayoutBatch(c)
+	if !ok {
+		return
+	}
+	items := append([]PayoutItem{}, batch.Items...)
+	for i := range items {
+		if items[i].Status == "pending" {
+			items[i].Status = "cancelled"
+		}
+	}
+	// the updated_at filter fails when an item was paid after the batch was loaded
+	err := h.repo.PayoutBatches.UpdateWhere(context.Background(),
+		bson.M{"_id": batch.ID, "updated_at": batch.UpdatedAt, "status": bson.M{"$in": []string{"pending", "processing"}}, "items.status": bson.M{"$ne": "paid"}},
+		bson.M{"status": "cancelled", "items": items, "updated_at": time.Now()})
+	if err == ErrNotFound {
+		c.JSON(http.StatusConflict, gin.H{"error": "payout batch is closed or already has paid items"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed cancel payout"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "payout batch cancelled"})
+}
@@ -16,7 +16,9 @@ func main() {
 
 	client, db := InitMongo()
 	defer func() {
-		_ = client.Disconnect(ctxBackground())
+		ctx, cancel := ctxBackground()
+		defer cancel()
+		_ = client.Disconnect(ctx)
 	}()
 
 	RunSeeder(ctx, db)
@@ -24,6 +26,9 @@ func main() {
 	r.Use(JSONContentTypeMiddleware())
 
 	handlers := NewHandlers(db)
+	moveContractFiles(handlers.storage, handlers.documents)
+	StartSiteVisitReminders(context.Background(), handlers, 5*time.Minute)
+	StartOfflineRentalSync(context.Background(), handlers, time.Hour)
 
 	SetupRoutes(r, handlers)
 
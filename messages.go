@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxMessageLength = 4000
+
+// helper: load conversation by :id, only the tenant, the owner and admins can access it
+func (h *Handlers) loadConversation(c *gin.Context) (Conversation, primitive.ObjectID, bool) {
+	var conv Conversation
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return conv, primitive.NilObjectID, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return conv, uid, false
+	}
+	conv, err = h.repo.Conversations.Get(context.Background(), oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+		return conv, uid, false
+	}
+	if uid != conv.TenantID && uid != conv.OwnerID && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not a participant"})
+		return conv, uid, false
+	}
+	return conv, uid, true
+}
+
+// helper: a message can only be attached to a pending booking of this tenant on this ruko
+func (h *Handlers) pendingBookingForConversation(ctx context.Context, bookingHex string, rukoID, tenantID primitive.ObjectID) (*primitive.ObjectID, string) {
+	if bookingHex == "" {
+		return nil, ""
+	}
+	bid, err := primitive.ObjectIDFromHex(bookingHex)
+	if err != nil {
+		return nil, "invalid booking_id"
+	}
+	b, err := h.repo.Bookings.Get(ctx, bid)
+	if err != nil {
+		return nil, "booking not found"
+	}
+	if b.RukoID != rukoID || b.TenantID != tenantID {
+		return nil, "booking does not belong to this conversation"
+	}
+	if b.BookingStatus != "waiting" {
+		return nil, "only pending bookings can be attached"
+	}
+	return &bid, ""
+}
+
+// StartConversation: POST /api/conversations {"ruko_id", "booking_id"?, "message"}
+// started by the tenant; reuses the existing thread for the same ruko/booking
+func (h *Handlers) StartConversation(c *gin.Context) {
+	var in struct {
+		RukoID    string `json:"ruko_id" binding:"required"`
+		BookingID string `json:"booking_id"`
+		Message   string `json:"message" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rukoOID, err := primitive.ObjectIDFromHex(in.RukoID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ruko_id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx := context.Background()
+	r, err := h.repo.Rukos.Get(ctx, rukoOID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return
+	}
+	if r.OwnerID == uid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot start a conversation with yourself"})
+		return
+	}
+	var bookingID *primitive.ObjectID
+	if in.BookingID != "" {
+		bid, err := primitive.ObjectIDFromHex(in.BookingID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid booking_id"})
+			return
+		}
+		b, err := h.repo.Bookings.Get(ctx, bid)
+		if err != nil || b.RukoID != rukoOID || b.TenantID != uid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "booking does not belong to you and this ruko"})
+			return
+		}
+		bookingID = &bid
+	}
+
+	now := time.Now()
+	filter := bson.M{"ruko_id": rukoOID, "tenant_id": uid, "booking_id": bookingID}
+	conv, err := h.repo.Conversations.FindOne(ctx, filter)
+	if err == ErrNotFound {
+		conv = Conversation{RukoID: rukoOID, BookingID: bookingID, TenantID: uid, OwnerID: r.OwnerID, CreatedAt: now}
+		if err = h.repo.Conversations.Create(ctx, &conv); err == ErrDuplicate {
+			// started concurrently, use that thread
+			conv, err = h.repo.Conversations.FindOne(ctx, filter)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed start conversation"})
+		return
+	}
+	msg, ok := h.sendMessage(c, conv, uid, in.Message, nil)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"conversation": conv, "message": msg})
+}
+
+// ListConversations: GET /api/conversations?page=&limit=
+func (h *Handlers) ListConversations(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	filter := bson.M{"$or": []bson.M{{"tenant_id": uid}, {"owner_id": uid}}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "last_message_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	out, err := h.repo.Conversations.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list conversations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": page, "limit": limit})
+}
+
+// ListMessages: GET /api/conversations/:id/messages?before=<messageId>&limit=30
+// newest first; use next_before to load older messages
+func (h *Handlers) ListMessages(c *gin.Context) {
+	conv, _, ok := h.loadConversation(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if limit < 1 || limit > 100 {
+		limit = 30
+	}
+	filter := bson.M{"conversation_id": conv.ID}
+	if before := c.Query("before"); before != "" {
+		bid, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": bid}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	out, err := h.repo.Messages.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list messages"})
+		return
+	}
+	resp := gin.H{"data": out}
+	if len(out) == limit {
+		resp["next_before"] = out[len(out)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// SendMessage: POST /api/conversations/:id/messages {"body", "booking_id"?}
+func (h *Handlers) SendMessage(c *gin.Context) {
+	conv, uid, ok := h.loadConversation(c)
+	if !ok {
+		return
+	}
+	if uid != conv.TenantID && uid != conv.OwnerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only participants can send messages"})
+		return
+	}
+	var in struct {
+		Body      string `json:"body" binding:"required"`
+		BookingID string `json:"booking_id"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bookingID, errMsg := h.pendingBookingForConversation(context.Background(), in.BookingID, conv.RukoID, conv.TenantID)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	msg, ok := h.sendMessage(c, conv, uid, in.Body, bookingID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, msg)
+}
+
+// sendMessage stores the message, updates the thread and notifies the other party
+func (h *Handlers) sendMessage(c *gin.Context, conv Conversation, sender primitive.ObjectID, body string, bookingID *primitive.ObjectID) (Message, bool) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > maxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message must be between 1 and 4000 characters"})
+		return Message{}, false
+	}
+	ctx := context.Background()
+	now := time.Now()
+	msg := Message{
+		ConversationID: conv.ID,
+		SenderID:       sender,
+		Body:           body,
+		BookingID:      bookingID,
+		CreatedAt:      now,
+	}
+	if err := h.repo.Messages.Create(ctx, &msg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed send message"})
+		return msg, false
+	}
+
+	preview := body
+	if runes := []rune(preview); len(runes) > 100 {
+		preview = string(runes[:100])
+	}
+	_ = h.repo.Conversations.Update(ctx, conv.ID, bson.M{
+		"last_message":    preview,
+		"last_message_at": now,
+		"updated_at":      now,
+	})
+
+	recipient := conv.OwnerID
+	if sender == conv.OwnerID {
+		recipient = conv.TenantID
+	}
+	h.notify(ctx, recipient, "message.new", "Pesan baru", preview,
+		map[string]string{"conversation_id": conv.ID.Hex(), "message_id": msg.ID.Hex()})
+	return msg, true
+}
+
+// MarkConversationRead: PATCH /api/conversations/:id/read
+// sets read_at (read receipt) on messages sent by the other party
+func (h *Handlers) MarkConversationRead(c *gin.Context) {
+	conv, uid, ok := h.loadConversation(c)
+	if !ok {
+		return
+	}
+	if uid != conv.TenantID && uid != conv.OwnerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only participants can mark messages as read"})
+		return
+	}
+	n, err := h.repo.Messages.UpdateMany(context.Background(),
+		bson.M{"conversation_id": conv.ID, "sender_id": bson.M{"$ne": uid}, "read_at": bson.M{"$exists": false}},
+		bson.M{"read_at": time.Now()})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update messages"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": n})
+}
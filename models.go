@@ -26,22 +26,61 @@ type User struct {
 type Ruko struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	OwnerID         primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	ExternalRef     string             `bson:"external_ref,omitempty" json:"external_ref,omitempty"` // owner's own code, used by bulk import
 	Name            string             `bson:"name" json:"name"`
 	Description     string             `bson:"description,omitempty" json:"description"`
 	Address         string             `bson:"address,omitempty" json:"address"`
 	City            string             `bson:"city,omitempty" json:"city"`
 	Latitude        float64            `bson:"latitude,omitempty" json:"latitude"`
 	Longitude       float64            `bson:"longitude,omitempty" json:"longitude"`
+	Location        *GeoPoint          `bson:"location,omitempty" json:"location,omitempty"`
 	Price           float64            `bson:"price" json:"price"`
 	DiscountPercent float64            `bson:"discount_percent,omitempty" json:"discount_percent"`
-	RentalType      string             `bson:"rental_type" json:"rental_type"` // monthly, yearly
+	RentalType      string             `bson:"rental_type" json:"rental_type"`               // monthly, yearly
+	LandArea        float64            `bson:"land_area,omitempty" json:"land_area"`         // m²
+	BuildingArea    float64            `bson:"building_area,omitempty" json:"building_area"` // m²
+	Floors          int                `bson:"floors,omitempty" json:"floors"`
+	Bathrooms       int                `bson:"bathrooms,omitempty" json:"bathrooms"`
+	PowerVA         int                `bson:"power_va,omitempty" json:"power_va"` // electricity capacity
+	Facilities      []string           `bson:"facilities,omitempty" json:"facilities"`
+	Zoning          string             `bson:"zoning,omitempty" json:"zoning"` // commercial, mixed_use, ...
 	IsAvailable     bool               `bson:"is_available" json:"is_available"`
 	RentedOffline   bool               `bson:"rented_offline" json:"rented_offline"`
-	Image           string             `bson:"image,omitempty" json:"image"`
+	Image           string             `bson:"image,omitempty" json:"image"` // cover image url
+	Images          []RukoImage        `bson:"images,omitempty" json:"images"`
+	RatingAvg       float64            `bson:"rating_avg,omitempty" json:"rating_avg"`
+	RatingCount     int                `bson:"rating_count,omitempty" json:"rating_count"`
+	Archived        bool               `bson:"archived,omitempty" json:"archived"` // soft-deleted, hidden from listing
+	ArchivedAt      *time.Time         `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
 	CreatedAt       time.Time          `bson:"created_at,omitempty" json:"created_at"`
 	UpdatedAt       time.Time          `bson:"updated_at,omitempty" json:"updated_at"`
 }
 
+// RukoImage (gallery photo, files kept in Storage)
+type RukoImage struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Key         string             `bson:"key" json:"-"`
+	ThumbKey    string             `bson:"thumb_key" json:"-"`
+	URL         string             `bson:"url" json:"url"`
+	ThumbURL    string             `bson:"thumb_url" json:"thumb_url"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+	Order       int                `bson:"order" json:"order"`
+	IsCover     bool               `bson:"is_cover" json:"is_cover"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// GeoPoint (GeoJSON point, coordinates = [lng, lat])
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewGeoPoint builds a GeoJSON point from latitude/longitude
+func NewGeoPoint(lat, lng float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
 // Booking
 type Booking struct {
 	ID                primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
@@ -50,26 +89,45 @@ type Booking struct {
 	StartDate         time.Time           `bson:"start_date" json:"start_date"`
 	EndDate           time.Time           `bson:"end_date" json:"end_date"`
 	TotalPrice        float64             `bson:"total_price" json:"total_price"`
-	PaymentStatus     string              `bson:"payment_status" json:"payment_status"` // pending, paid, cancelled
-	BookingStatus     string              `bson:"booking_status" json:"booking_status"` // waiting, confirmed, rejected, cancelled
+	Pricing           *BookingPricing     `bson:"pricing,omitempty" json:"pricing,omitempty"`
+	PaymentStatus     string              `bson:"payment_status" json:"payment_status"` // pending, partial, paid, cancelled
+	BookingStatus     string              `bson:"booking_status" json:"booking_status"` // waiting, awaiting_signature, confirmed, rejected, cancelled
 	PaymentMethod     string              `bson:"payment_method" json:"payment_method"` // online, offline
 	OfflineVerifiedBy *primitive.ObjectID `bson:"offline_verified_by,omitempty" json:"offline_verified_by,omitempty"`
+	AcceptedAt        *time.Time          `bson:"accepted_at,omitempty" json:"accepted_at,omitempty"` // first time the owner accepted (or confirmed the payment)
+	RejectedAt        *time.Time          `bson:"rejected_at,omitempty" json:"rejected_at,omitempty"`
+	CancelledAt       *time.Time          `bson:"cancelled_at,omitempty" json:"cancelled_at,omitempty"`
 	CreatedAt         time.Time           `bson:"created_at,omitempty" json:"created_at"`
 	UpdatedAt         time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
 }
 
+// BookingPricing is the price breakdown stored when the booking is made
+type BookingPricing struct {
+	Unit            string  `bson:"unit" json:"unit"` // month, year
+	Quantity        int     `bson:"quantity" json:"quantity"`
+	UnitPrice       float64 `bson:"unit_price" json:"unit_price"`
+	Subtotal        float64 `bson:"subtotal" json:"subtotal"`
+	TaxRate         float64 `bson:"tax_rate" json:"tax_rate"`
+	Tax             float64 `bson:"tax" json:"tax"`
+	DiscountCode    string  `bson:"discount_code,omitempty" json:"discount_code,omitempty"`
+	DiscountPercent float64 `bson:"discount_percent" json:"discount_percent"`
+	Discount        float64 `bson:"discount" json:"discount"`
+	Total           float64 `bson:"total" json:"total"`
+}
+
 // Payment
 type Payment struct {
-	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
-	BookingID     primitive.ObjectID  `bson:"booking_id" json:"booking_id"`
-	PaymentMethod string              `bson:"payment_method" json:"payment_method"` // transfer, cash, gateway
-	Amount        float64             `bson:"amount" json:"amount"`
-	PaymentDate   time.Time           `bson:"payment_date" json:"payment_date"`
-	PaymentProof  string              `bson:"payment_proof,omitempty" json:"payment_proof"`
-	Status        string              `bson:"status" json:"status"` // pending, confirmed, failed
-	ConfirmedBy   *primitive.ObjectID `bson:"confirmed_by,omitempty" json:"confirmed_by,omitempty"`
-	CreatedAt     time.Time           `bson:"created_at,omitempty" json:"created_at"`
-	UpdatedAt     time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	BookingID      primitive.ObjectID  `bson:"booking_id" json:"booking_id"`
+	PaymentMethod  string              `bson:"payment_method" json:"payment_method"` // transfer, cash, gateway
+	Amount         float64             `bson:"amount" json:"amount"`
+	PaymentDate    time.Time           `bson:"payment_date" json:"payment_date"`
+	PaymentProof   string              `bson:"payment_proof,omitempty" json:"payment_proof"`
+	Status         string              `bson:"status" json:"status"` // pending, confirmed, failed, refunded
+	RefundedAmount float64             `bson:"refunded_amount,omitempty" json:"refunded_amount,omitempty"`
+	ConfirmedBy    *primitive.ObjectID `bson:"confirmed_by,omitempty" json:"confirmed_by,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
 }
 
 // Discount
@@ -88,13 +146,326 @@ type Discount struct {
 
 // RentalHistory
 type RentalHistory struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	RukoID          primitive.ObjectID  `bson:"ruko_id" json:"ruko_id"`
+	TenantID        primitive.ObjectID  `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`   // empty for a non-registered offline tenant
+	BookingID       *primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"` // set for rentals made through a booking
+	Source          string              `bson:"source,omitempty" json:"source,omitempty"`         // "" from a booking, "offline" recorded by the owner
+	TenantName      string              `bson:"tenant_name,omitempty" json:"tenant_name,omitempty"`
+	TenantPhone     string              `bson:"tenant_phone,omitempty" json:"tenant_phone,omitempty"`
+	TenantEmail     string              `bson:"tenant_email,omitempty" json:"tenant_email,omitempty"`
+	StartDate       time.Time           `bson:"start_date" json:"start_date"`
+	EndDate         time.Time           `bson:"end_date" json:"end_date"`
+	OriginalEndDate *time.Time          `bson:"original_end_date,omitempty" json:"original_end_date,omitempty"` // set when ended early
+	EndedAt         *time.Time          `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+	TotalPaid       float64             `bson:"total_paid" json:"total_paid"`
+	PaymentMethod   string              `bson:"payment_method" json:"payment_method"`
+	Notes           string              `bson:"notes,omitempty" json:"notes,omitempty"`
+	RecordedBy      *primitive.ObjectID `bson:"recorded_by,omitempty" json:"recorded_by,omitempty"`
+	CreatedAt       time.Time           `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt       time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
+}
+
+// Review (one per rental history entry)
+type Review struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	RukoID          primitive.ObjectID  `bson:"ruko_id" json:"ruko_id"`
+	OwnerID         primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	TenantID        primitive.ObjectID  `bson:"tenant_id" json:"tenant_id"`
+	RentalHistoryID primitive.ObjectID  `bson:"rental_history_id" json:"rental_history_id"`
+	Rating          int                 `bson:"rating" json:"rating"` // 1-5
+	Comment         string              `bson:"comment,omitempty" json:"comment"`
+	OwnerResponse   string              `bson:"owner_response,omitempty" json:"owner_response,omitempty"`
+	RespondedAt     *time.Time          `bson:"responded_at,omitempty" json:"responded_at,omitempty"`
+	Hidden          bool                `bson:"hidden" json:"hidden"` // hidden by admin moderation
+	HiddenReason    string              `bson:"hidden_reason,omitempty" json:"hidden_reason,omitempty"`
+	HiddenBy        *primitive.ObjectID `bson:"hidden_by,omitempty" json:"hidden_by,omitempty"`
+	CreatedAt       time.Time           `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt       time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
+}
+
+// Favorite (saved ruko)
+type Favorite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RukoID    primitive.ObjectID `bson:"ruko_id" json:"ruko_id"`
+	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at"`
+}
+
+// SavedSearch (empty criteria = any)
+type SavedSearch struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	RukoID        primitive.ObjectID `bson:"ruko_id" json:"ruko_id"`
-	TenantID      primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
-	StartDate     time.Time          `bson:"start_date" json:"start_date"`
-	EndDate       time.Time          `bson:"end_date" json:"end_date"`
-	TotalPaid     float64            `bson:"total_paid" json:"total_paid"`
-	PaymentMethod string             `bson:"payment_method" json:"payment_method"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name          string             `bson:"name" json:"name"`
+	City          string             `bson:"city" json:"city"` // stored lower-case
+	MinPrice      float64            `bson:"min_price" json:"min_price"`
+	MaxPrice      float64            `bson:"max_price" json:"max_price"`
+	RentalType    string             `bson:"rental_type" json:"rental_type"`
+	AlertsEnabled bool               `bson:"alerts_enabled" json:"alerts_enabled"`
 	CreatedAt     time.Time          `bson:"created_at,omitempty" json:"created_at"`
 	UpdatedAt     time.Time          `bson:"updated_at,omitempty" json:"updated_at"`
 }
+
+// Notification
+type Notification struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID     `bson:"user_id" json:"user_id"`
+	Type       string                 `bson:"type" json:"type"` // saved_search.match, favorite.available, ...
+	Title      string                 `bson:"title" json:"title"`
+	Body       string                 `bson:"body" json:"body"`
+	Data       map[string]string      `bson:"data,omitempty" json:"data,omitempty"`
+	Read       bool                   `bson:"read" json:"read"`
+	ReadAt     *time.Time             `bson:"read_at,omitempty" json:"read_at,omitempty"`
+	Deliveries []NotificationDelivery `bson:"deliveries,omitempty" json:"deliveries,omitempty"`
+	CreatedAt  time.Time              `bson:"created_at,omitempty" json:"created_at"`
+}
+
+// NotificationDelivery (result of sending through one channel)
+type NotificationDelivery struct {
+	Channel string    `bson:"channel" json:"channel"`
+	Status  string    `bson:"status" json:"status"` // sent, failed, skipped
+	Error   string    `bson:"error,omitempty" json:"error,omitempty"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// Conversation (thread between tenant and owner about a ruko, optionally a booking)
+type Conversation struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	RukoID        primitive.ObjectID  `bson:"ruko_id" json:"ruko_id"`
+	BookingID     *primitive.ObjectID `bson:"booking_id" json:"booking_id,omitempty"`
+	TenantID      primitive.ObjectID  `bson:"tenant_id" json:"tenant_id"`
+	OwnerID       primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	LastMessage   string              `bson:"last_message,omitempty" json:"last_message"`
+	LastMessageAt time.Time           `bson:"last_message_at,omitempty" json:"last_message_at"`
+	CreatedAt     time.Time           `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
+}
+
+// Message
+type Message struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ConversationID primitive.ObjectID  `bson:"conversation_id" json:"conversation_id"`
+	SenderID       primitive.ObjectID  `bson:"sender_id" json:"sender_id"`
+	Body           string              `bson:"body" json:"body"`
+	BookingID      *primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"` // attached pending booking
+	ReadAt         *time.Time          `bson:"read_at,omitempty" json:"read_at,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at,omitempty" json:"created_at"`
+}
+
+// ViewingWindow (time range when the owner can show the ruko)
+type ViewingWindow struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RukoID    primitive.ObjectID `bson:"ruko_id" json:"ruko_id"`
+	OwnerID   primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	Start     time.Time          `bson:"start" json:"start"`
+	End       time.Time          `bson:"end" json:"end"`
+	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at"`
+}
+
+// SiteVisit (survey request before booking)
+type SiteVisit struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RukoID         primitive.ObjectID `bson:"ruko_id" json:"ruko_id"`
+	OwnerID        primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	TenantID       primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	Start          time.Time          `bson:"start" json:"start"`
+	End            time.Time          `bson:"end" json:"end"`
+	Status         string             `bson:"status" json:"status"` // requested, accepted, declined, cancelled
+	Note           string             `bson:"note,omitempty" json:"note"`
+	OwnerNote      string             `bson:"owner_note,omitempty" json:"owner_note"`
+	ReminderSentAt *time.Time         `bson:"reminder_sent_at,omitempty" json:"reminder_sent_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at,omitempty" json:"updated_at"`
+}
+
+// Contract (generated rental agreement, one document per version)
+type Contract struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	BookingID   primitive.ObjectID  `bson:"booking_id" json:"booking_id"`
+	RukoID      primitive.ObjectID  `bson:"ruko_id" json:"ruko_id"`
+	OwnerID     primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	TenantID    primitive.ObjectID  `bson:"tenant_id" json:"tenant_id"`
+	Version     int                 `bson:"version" json:"version"`
+	FileKey     string              `bson:"file_key" json:"-"`
+	DataHash    string              `bson:"data_hash" json:"-"`               // hash of the booking data used, detects amendments
+	ContentHash string              `bson:"content_hash" json:"content_hash"` // sha256 of the pdf
+	Signatures  []ContractSignature `bson:"signatures,omitempty" json:"signatures"`
+	SignedAt    *time.Time          `bson:"signed_at,omitempty" json:"signed_at,omitempty"` // set when both parties signed
+	CreatedAt   time.Time           `bson:"created_at,omitempty" json:"created_at"`
+}
+
+// ContractSignature is the in-app acceptance of one contract version by a party
+type ContractSignature struct {
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role        string             `bson:"role" json:"role"` // owner, tenant
+	ContentHash string             `bson:"content_hash" json:"content_hash"`
+	IP          string             `bson:"ip" json:"ip"`
+	UserAgent   string             `bson:"user_agent" json:"user_agent"`
+	SignedAt    time.Time          `bson:"signed_at" json:"signed_at"`
+}
+
+// Invoice (one per booking, or one per installment when the booking is split)
+type Invoice struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Number           string              `bson:"number" json:"number"` // gap-free per owner
+	OwnerID          primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	TenantID         primitive.ObjectID  `bson:"tenant_id" json:"tenant_id"`
+	BookingID        primitive.ObjectID  `bson:"booking_id" json:"booking_id"`
+	RukoID           primitive.ObjectID  `bson:"ruko_id" json:"ruko_id"`
+	Installment      int                 `bson:"installment" json:"installment"`
+	InstallmentCount int                 `bson:"installment_count" json:"installment_count"`
+	Items            []InvoiceItem       `bson:"items" json:"items"`
+	Subtotal         float64             `bson:"subtotal" json:"subtotal"`
+	TaxRate          float64             `bson:"tax_rate" json:"tax_rate"`
+	Tax              float64             `bson:"tax" json:"tax"`
+	Discount         float64             `bson:"discount" json:"discount"`
+	Total            float64             `bson:"total" json:"total"`
+	Status           string              `bson:"status" json:"status"` // unpaid, paid, void (overdue is derived from due_date)
+	DueDate          time.Time           `bson:"due_date" json:"due_date"`
+	PaymentID        *primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	PaidAt           *time.Time          `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+	IssuedAt         time.Time           `bson:"issued_at" json:"issued_at"`
+	UpdatedAt        time.Time           `bson:"updated_at,omitempty" json:"updated_at"`
+}
+
+// InvoiceItem
+type InvoiceItem struct {
+	Description string  `bson:"description" json:"description"`
+	Quantity    int     `bson:"quantity" json:"quantity"`
+	UnitPrice   float64 `bson:"unit_price" json:"unit_price"`
+	Amount      float64 `bson:"amount" json:"amount"`
+}
+
+// Receipt issued when a payment settles an invoice
+type Receipt struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number        string             `bson:"number" json:"number"`
+	OwnerID       primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	TenantID      primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	BookingID     primitive.ObjectID `bson:"booking_id" json:"booking_id"`
+	InvoiceID     primitive.ObjectID `bson:"invoice_id" json:"invoice_id"`
+	InvoiceNumber string             `bson:"invoice_number" json:"invoice_number"`
+	PaymentID     primitive.ObjectID `bson:"payment_id" json:"payment_id"`
+	PaymentMethod string             `bson:"payment_method" json:"payment_method"`
+	Amount        float64            `bson:"amount" json:"amount"`
+	PaidAt        time.Time          `bson:"paid_at" json:"paid_at"`
+	IssuedAt      time.Time          `bson:"issued_at" json:"issued_at"`
+}
+
+// LedgerTransaction is a balanced set of double-entry postings (sum of debits == sum of credits)
+type LedgerTransaction struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Key       string              `bson:"key" json:"key"`   // unique per source, e.g. payment:<id>
+	Type      string              `bson:"type" json:"type"` // payment, owner_collection, refund, payout
+	OwnerID   primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	BookingID *primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"`
+	PaymentID *primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	PayoutID  *primitive.ObjectID `bson:"payout_id,omitempty" json:"payout_id,omitempty"`
+	Entries   []LedgerEntry       `bson:"entries" json:"entries"`
+	Memo      string              `bson:"memo,omitempty" json:"memo,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// LedgerEntry
+type LedgerEntry struct {
+	Account string  `bson:"account" json:"account"` // cash, platform_fee, owner_payable
+	Debit   float64 `bson:"debit" json:"debit"`
+	Credit  float64 `bson:"credit" json:"credit"`
+}
+
+// PayoutBatch groups the transfers of owner balances made together
+type PayoutBatch struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Status      string             `bson:"status" json:"status"` // pending, processing, completed, cancelled
+	Items       []PayoutItem       `bson:"items" json:"items"`
+	Total       float64            `bson:"total" json:"total"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// PayoutItem is the transfer to one owner inside a batch
+type PayoutItem struct {
+	OwnerID       primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	Amount        float64            `bson:"amount" json:"amount"`
+	Status        string             `bson:"status" json:"status"` // pending, paid, failed, cancelled
+	Reference     string             `bson:"reference,omitempty" json:"reference,omitempty"`
+	FailureReason string             `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
+	PaidAt        *time.Time         `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+}
+
+// ImportJob (bulk ruko import, processed in the background)
+type ImportJob struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID    primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	Filename   string             `bson:"filename" json:"filename"`
+	Status     string             `bson:"status" json:"status"` // queued, running, completed, failed
+	Total      int                `bson:"total" json:"total"`
+	Processed  int                `bson:"processed" json:"processed"`
+	Created    int                `bson:"created" json:"created"`
+	Updated    int                `bson:"updated" json:"updated"`
+	Failed     int                `bson:"failed" json:"failed"`
+	Errors     []ImportRowError   `bson:"errors,omitempty" json:"errors,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	StartedAt  *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// ImportRowError (row is the line number in the file, header is row 1)
+type ImportRowError struct {
+	Row   int    `bson:"row" json:"row"`
+	Field string `bson:"field,omitempty" json:"field,omitempty"`
+	Error string `bson:"error" json:"error"`
+}
+
+// Activity (owner dashboard feed)
+type Activity struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID   primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	RukoID    primitive.ObjectID `bson:"ruko_id" json:"ruko_id"`
+	RukoName  string             `bson:"ruko_name" json:"ruko_name"`
+	Type      string             `bson:"type" json:"type"`         // booking.created, payment.confirmed, ruko.updated, ...
+	Category  string             `bson:"category" json:"category"` // booking, payment, discount, review, ruko, rental, contract
+	Title     string             `bson:"title" json:"title"`
+	Data      map[string]string  `bson:"data,omitempty" json:"data,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// AuditLog (one per mutating request)
+type AuditLog struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	RequestID  string                 `bson:"request_id" json:"request_id"`
+	ActorID    *primitive.ObjectID    `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Role       string                 `bson:"role,omitempty" json:"role,omitempty"`
+	Action     string                 `bson:"action" json:"action"` // "PATCH /api/ruko/:id/rented-offline"
+	Path       string                 `bson:"path" json:"path"`
+	TargetType string                 `bson:"target_type,omitempty" json:"target_type,omitempty"`
+	TargetID   string                 `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	Params     map[string]string      `bson:"params,omitempty" json:"params,omitempty"`
+	Status     int                    `bson:"status" json:"status"`
+	Changes    map[string]AuditChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	IP         string                 `bson:"ip" json:"ip"`
+	UserAgent  string                 `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	CreatedAt  time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// AuditChange (value of one field before and after the request)
+type AuditChange struct {
+	From interface{} `bson:"from,omitempty" json:"from,omitempty"`
+	To   interface{} `bson:"to,omitempty" json:"to,omitempty"`
+}
+
+// IdempotencyRecord (stored response of a POST sent with an Idempotency-Key, expires after a while)
+type IdempotencyRecord struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       string             `bson:"user_id" json:"user_id"`
+	Key          string             `bson:"key" json:"key"`
+	RequestHash  string             `bson:"request_hash" json:"request_hash"`
+	Status       string             `bson:"status" json:"status"` // processing, completed
+	ResponseCode int                `bson:"response_code,omitempty" json:"response_code,omitempty"`
+	ContentType  string             `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	ResponseBody []byte             `bson:"response_body,omitempty" json:"-"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+}
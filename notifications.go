@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notifier stores in-app notifications and pushes them to the delivery channels
+type Notifier struct {
+	notifications NotificationRepo
+	users         UserRepo
+	channels      []NotificationChannel
+}
+
+func NewNotifier(repo Repositories, channels ...NotificationChannel) *Notifier {
+	return &Notifier{notifications: repo.Notifications, users: repo.Users, channels: channels}
+}
+
+// Notify saves the notification, then delivers it in the background
+func (n *Notifier) Notify(ctx context.Context, userID primitive.ObjectID, ntype, title, body string, data map[string]string) error {
+	if n == nil {
+		return nil
+	}
+	notif := Notification{
+		UserID:    userID,
+		Type:      ntype,
+		Title:     title,
+		Body:      body,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+	if err := n.notifications.Create(ctx, &notif); err != nil {
+		return err
+	}
+	if len(n.channels) > 0 {
+		go n.deliver(notif)
+	}
+	return nil
+}
+
+func (n *Notifier) deliver(notif Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := n.users.Get(ctx, notif.UserID)
+	if err != nil {
+		log.Println("notification delivery: user not found:", notif.UserID.Hex())
+		return
+	}
+	deliveries := sendToChannels(ctx, n.channels, user, notif)
+	_ = n.notifications.Update(ctx, notif.ID, bson.M{"deliveries": deliveries})
+}
+
+// sendToChannels sends notif on every channel and records how each one went
+func sendToChannels(ctx context.Context, channels []NotificationChannel, user User, notif Notification) []NotificationDelivery {
+	deliveries := make([]NotificationDelivery, 0, len(channels))
+	for _, ch := range channels {
+		d := NotificationDelivery{Channel: ch.Name(), Status: "sent"}
+		if err := ch.Send(ctx, user, notif); errors.Is(err, errSkipDelivery) {
+			d.Status = "skipped"
+		} else if err != nil {
+			d.Status = "failed"
+			d.Error = deliveryError(err)
+			log.Printf("notification delivery via %s failed: %v", ch.Name(), err)
+		}
+		d.At = time.Now()
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+// helper: notify without failing the request
+func (h *Handlers) notify(ctx context.Context, userID primitive.ObjectID, ntype, title, body string, data map[string]string) {
+	if err := h.notifier.Notify(ctx, userID, ntype, title, body, data); err != nil {
+		log.Println("notify error:", err)
+	}
+}
+
+// helper: notify the owner of a ruko
+func (h *Handlers) notifyRukoOwner(ctx context.Context, rukoID primitive.ObjectID, ntype, title, body string, data map[string]string) {
+	r, err := h.repo.Rukos.Get(ctx, rukoID)
+	if err != nil {
+		log.Println("notify owner: ruko not found:", rukoID.Hex())
+		return
+	}
+	h.notify(ctx, r.OwnerID, ntype, title, body, data)
+}
+
+// ListNotifications: GET /api/notifications?unread=true&page=1&limit=20
+func (h *Handlers) ListNotifications(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	filter := bson.M{"user_id": uid}
+	if c.Query("unread") == "true" {
+		filter["read"] = false
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	ctx := context.Background()
+	out, err := h.repo.Notifications.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list notifications"})
+		return
+	}
+	total, _ := h.repo.Notifications.Count(ctx, filter)
+	c.JSON(http.StatusOK, gin.H{"data": out, "page": page, "limit": limit, "total": total})
+}
+
+// UnreadNotificationCount: GET /api/notifications/unread-count
+func (h *Handlers) UnreadNotificationCount(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	n, err := h.repo.Notifications.Count(context.Background(), bson.M{"user_id": uid, "read": false})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed count notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": n})
+}
+
+// MarkNotificationRead: PATCH /api/notifications/:id/read
+func (h *Handlers) MarkNotificationRead(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	err = h.repo.Notifications.UpdateWhere(context.Background(),
+		bson.M{"_id": oid, "user_id": uid},
+		bson.M{"read": true, "read_at": time.Now()})
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update notification"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "notification marked as read"})
+}
+
+// MarkAllNotificationsRead: PATCH /api/notifications/read-all
+func (h *Handlers) MarkAllNotificationsRead(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	n, err := h.repo.Notifications.UpdateMany(context.Background(),
+		bson.M{"user_id": uid, "read": false},
+		bson.M{"read": true, "read_at": time.Now()})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": n})
+}
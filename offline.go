@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// offlineRentalConflict returns an offline rental of the ruko overlapping [start, end), if any
+func (h *Handlers) offlineRentalConflict(ctx context.Context, rukoID primitive.ObjectID, start, end time.Time, exclude primitive.ObjectID) (*RentalHistory, error) {
+	rh, err := h.repo.RentalHistory.FindOne(ctx, bson.M{
+		"ruko_id":    rukoID,
+		"source":     "offline",
+		"start_date": bson.M{"$lt": end},
+		"end_date":   bson.M{"$gt": start},
+		"_id":        bson.M{"$ne": exclude},
+	})
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rh, nil
+}
+
+// bookingConflict returns an active booking of the ruko overlapping [start, end), if any
+func (h *Handlers) bookingConflict(ctx context.Context, rukoID primitive.ObjectID, start, end time.Time) (*Booking, error) {
+	b, err := h.repo.Bookings.FindOne(ctx, bson.M{
+		"ruko_id":        rukoID,
+		"booking_status": bson.M{"$in": activeBookingStatuses},
+		"start_date":     bson.M{"$lt": end},
+		"end_date":       bson.M{"$gt": start},
+	})
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// helper: answer 409 when the period is taken by a booking or another offline rental
+func (h *Handlers) checkOfflinePeriod(c *gin.Context, rukoID primitive.ObjectID, start, end time.Time, exclude primitive.ObjectID) bool {
+	ctx := context.Background()
+	rh, err := h.offlineRentalConflict(ctx, rukoID, start, end, exclude)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check rentals"})
+		return false
+	}
+	if rh != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko is already rented offline in that period", "rental_id": rh.ID})
+		return false
+	}
+	b, err := h.bookingConflict(ctx, rukoID, start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check bookings"})
+		return false
+	}
+	if b != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko has a booking in that period", "booking_id": b.ID})
+		return false
+	}
+	return true
+}
+
+// syncOfflineStatus sets rented_offline while an offline rental covers today,
+// and frees the ruko once none does (unless a booking still holds it)
+func (h *Handlers) syncOfflineStatus(ctx context.Context, rukoID primitive.ObjectID) {
+	r, err := h.repo.Rukos.Get(ctx, rukoID)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	n, err := h.repo.RentalHistory.Count(ctx, bson.M{
+		"ruko_id":    rukoID,
+		"source":     "offline",
+		"start_date": bson.M{"$lte": now},
+		"end_date":   bson.M{"$gt": now},
+	})
+	if err != nil {
+		log.Println("offline rental sync error:", err)
+		return
+	}
+	active := n > 0
+	switch {
+	case active && (!r.RentedOffline || r.IsAvailable):
+		err = h.repo.Rukos.Update(ctx, rukoID, bson.M{
+			"rented_offline": true, "is_available": false, "updated_at": now,
+		})
+		if err == nil && r.IsAvailable {
+			h.publishAvailability(ctx, rukoID, false)
+		}
+	case !active && r.RentedOffline:
+		// rukos flagged by the old endpoint have no rental record, keep them until the
+		// owner clears them with DELETE /api/ruko/:id/rented-offline
+		var recorded int64
+		recorded, err = h.repo.RentalHistory.Count(ctx, bson.M{"ruko_id": rukoID, "source": "offline"})
+		if err != nil || recorded == 0 {
+			break
+		}
+		err = h.repo.Rukos.Update(ctx, rukoID, bson.M{"rented_offline": false, "updated_at": now})
+		if err == nil {
+			h.releaseRukoIfFree(ctx, rukoID)
+		}
+	}
+	if err != nil {
+		log.Println("offline rental sync error:", err)
+	}
+}
+
+// StartOfflineRentalSync keeps rented_offline in line with the rental periods as
+// they start and end. Runs until ctx is cancelled.
+func StartOfflineRentalSync(ctx context.Context, h *Handlers, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			h.syncOfflineRentals(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (h *Handlers) syncOfflineRentals(ctx context.Context) {
+	now := time.Now()
+	current, err := h.repo.RentalHistory.Find(ctx, bson.M{
+		"source":     "offline",
+		"start_date": bson.M{"$lte": now},
+		"end_date":   bson.M{"$gt": now},
+	})
+	if err != nil {
+		log.Println("offline rental sync error:", err)
+		return
+	}
+	flagged, err := h.repo.Rukos.Find(ctx, bson.M{"rented_offline": true})
+	if err != nil {
+		log.Println("offline rental sync error:", err)
+		return
+	}
+	ids := make([]primitive.ObjectID, 0, len(current)+len(flagged))
+	for _, rh := range current {
+		ids = append(ids, rh.RukoID)
+	}
+	for _, r := range flagged {
+		ids = append(ids, r.ID)
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			h.syncOfflineStatus(ctx, id)
+		}
+	}
+}
+
+// offlineRentalInput is the body of create and correct; on correct every field is optional
+type offlineRentalInput struct {
+	TenantID      *string  `json:"tenant_id"` // registered tenant, or leave empty and send tenant_name
+	TenantName    *string  `json:"tenant_name"`
+	TenantPhone   *string  `json:"tenant_phone"`
+	TenantEmail   *string  `json:"tenant_email"`
+	StartDate     *string  `json:"start_date"` // YYYY-MM-DD
+	EndDate       *string  `json:"end_date"`   // YYYY-MM-DD, exclusive
+	TotalPaid     *float64 `json:"total_paid"`
+	PaymentMethod *string  `json:"payment_method"`
+	Notes         *string  `json:"notes"`
+}
+
+// apply copies the sent fields into rh and set
+func (h *Handlers) applyOfflineRentalInput(ctx context.Context, in offlineRentalInput, rh *RentalHistory, set bson.M) error {
+	str := func(p *string) string { return strings.TrimSpace(*p) }
+	if in.TenantID != nil {
+		if id := str(in.TenantID); id == "" {
+			rh.TenantID = primitive.NilObjectID
+			set["tenant_id"] = nil
+		} else {
+			oid, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return errors.New("invalid tenant_id")
+			}
+			u, err := h.repo.Users.Get(ctx, oid)
+			if err != nil {
+				return errors.New("tenant not found")
+			}
+			rh.TenantID = oid
+			// registered tenant: contact details default to the account
+			if in.TenantName == nil {
+				rh.TenantName = u.Name
+			}
+			if in.TenantPhone == nil {
+				rh.TenantPhone = u.Phone
+			}
+			if in.TenantEmail == nil {
+				rh.TenantEmail = u.Email
+			}
+			set["tenant_id"] = oid
+		}
+	}
+	if in.TenantName != nil {
+		rh.TenantName = str(in.TenantName)
+	}
+	if in.TenantPhone != nil {
+		rh.TenantPhone = str(in.TenantPhone)
+	}
+	if in.TenantEmail != nil {
+		rh.TenantEmail = str(in.TenantEmail)
+	}
+	if in.StartDate != nil {
+		t, err := time.Parse("2006-01-02", str(in.StartDate))
+		if err != nil {
+			return errors.New("invalid start_date, use YYYY-MM-DD")
+		}
+		rh.StartDate = t
+		set["start_date"] = t
+	}
+	if in.EndDate != nil {
+		t, err := time.Parse("2006-01-02", str(in.EndDate))
+		if err != nil {
+			return errors.New("invalid end_date, use YYYY-MM-DD")
+		}
+		rh.EndDate = t
+		set["end_date"] = t
+	}
+	if in.TotalPaid != nil {
+		rh.TotalPaid = *in.TotalPaid
+		set["total_paid"] = rh.TotalPaid
+	}
+	if in.PaymentMethod != nil {
+		rh.PaymentMethod = str(in.PaymentMethod)
+		set["payment_method"] = rh.PaymentMethod
+	}
+	if in.Notes != nil {
+		rh.Notes = str(in.Notes)
+		set["notes"] = rh.Notes
+	}
+	set["tenant_name"] = rh.TenantName
+	set["tenant_phone"] = rh.TenantPhone
+	set["tenant_email"] = rh.TenantEmail
+
+	if rh.TenantID.IsZero() && rh.TenantName == "" {
+		return errors.New("tenant_id or tenant_name is required")
+	}
+	if rh.StartDate.IsZero() || rh.EndDate.IsZero() {
+		return errors.New("start_date and end_date are required")
+	}
+	if !rh.EndDate.After(rh.StartDate) {
+		return errors.New("end_date must be after start_date")
+	}
+	if rh.TotalPaid < 0 {
+		return errors.New("total_paid must not be negative")
+	}
+	if rh.PaymentMethod == "" {
+		rh.PaymentMethod = "cash"
+		set["payment_method"] = rh.PaymentMethod
+	}
+	return nil
+}
+
+// CreateOfflineRental: POST /api/ruko/:id/offline-rentals
+// {"tenant_id"? | "tenant_name", "tenant_phone"?, "tenant_email"?, "start_date", "end_date", "total_paid", "payment_method"?, "notes"?}
+// records a rental arranged outside the app; the ruko is blocked for that period only
+func (h *Handlers) CreateOfflineRental(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	if r.Archived {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko is archived, restore it first"})
+		return
+	}
+	var in offlineRentalInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.TotalPaid == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "total_paid is required"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	rh := RentalHistory{
+		RukoID:     r.ID,
+		Source:     "offline",
+		RecordedBy: &uid,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := h.applyOfflineRentalInput(ctx, in, &rh, bson.M{}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkOfflinePeriod(c, r.ID, rh.StartDate, rh.EndDate, primitive.NilObjectID) {
+		return
+	}
+	if err := h.repo.RentalHistory.Create(ctx, &rh); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed record offline rental"})
+		return
+	}
+
+	h.syncOfflineStatus(ctx, r.ID)
+	h.publishRukoEvent(ctx, r.ID, "ruko.rented_offline", map[string]interface{}{
+		"rental_id":  rh.ID.Hex(),
+		"start_date": rh.StartDate.Format("2006-01-02"),
+		"end_date":   rh.EndDate.Format("2006-01-02"),
+	})
+	if !rh.TenantID.IsZero() {
+		h.notify(ctx, rh.TenantID, "rental.offline_recorded", "Sewa tercatat",
+			fmt.Sprintf("Sewa %s periode %s - %s telah dicatat oleh pemilik.", r.Name,
+				rh.StartDate.Format("02/01/2006"), rh.EndDate.Format("02/01/2006")),
+			map[string]string{"rental_id": rh.ID.Hex(), "ruko_id": r.ID.Hex()})
+	}
+	c.JSON(http.StatusCreated, rh)
+}
+
+// MarkRukoRentedOffline: PATCH /api/ruko/:id/rented-offline (deprecated, no body)
+// kept for old clients: blocks the ruko with no end date. Use POST /api/ruko/:id/offline-rentals
+func (h *Handlers) MarkRukoRentedOffline(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", fmt.Sprintf("</api/ruko/%s/offline-rentals>; rel=\"successor-version\"", c.Param("id")))
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	if r.Archived {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko is archived, restore it first"})
+		return
+	}
+	ctx := context.Background()
+	err := h.repo.Rukos.Update(ctx, r.ID, bson.M{"rented_offline": true, "is_available": false, "updated_at": time.Now()})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update ruko"})
+		return
+	}
+	if r.IsAvailable {
+		h.publishAvailability(ctx, r.ID, false)
+	}
+	h.publishRukoEvent(ctx, r.ID, "ruko.rented_offline", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "ruko marked as rented offline"})
+}
+
+// ClearRukoRentedOffline: DELETE /api/ruko/:id/rented-offline
+// clears a flag set by the old endpoint; rukos with a current offline rental must end it instead
+func (h *Handlers) ClearRukoRentedOffline(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	now := time.Now()
+	rh, err := h.offlineRentalConflict(ctx, r.ID, now, now.Add(time.Nanosecond), primitive.NilObjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check rentals"})
+		return
+	}
+	if rh != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko has a current offline rental, end it instead", "rental_id": rh.ID})
+		return
+	}
+	if !r.RentedOffline {
+		c.JSON(http.StatusOK, gin.H{"message": "ruko is not marked as rented offline"})
+		return
+	}
+	if err := h.repo.Rukos.Update(ctx, r.ID, bson.M{"rented_offline": false, "updated_at": now}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update ruko"})
+		return
+	}
+	h.releaseRukoIfFree(ctx, r.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "offline rental flag cleared"})
+}
+
+// ListOfflineRentals: GET /api/ruko/:id/offline-rentals
+func (h *Handlers) ListOfflineRentals(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	out, err := h.repo.RentalHistory.Find(context.Background(), bson.M{"ruko_id": r.ID, "source": "offline"},
+		options.Find().SetSort(bson.D{{Key: "start_date", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list offline rentals"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// helper: load offline rental :id and make sure the current user owns the ruko (admin can access all)
+func (h *Handlers) loadOfflineRental(c *gin.Context) (RentalHistory, Ruko, bool) {
+	var rh RentalHistory
+	var r Ruko
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return rh, r, false
+	}
+	ctx := context.Background()
+	if rh, err = h.repo.RentalHistory.FindOne(ctx, bson.M{"_id": oid, "source": "offline"}); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "offline rental not found"})
+		return rh, r, false
+	}
+	if r, err = h.repo.Rukos.Get(ctx, rh.RukoID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return rh, r, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return rh, r, false
+	}
+	if c.GetString("role") != "admin" && r.OwnerID != uid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not the owner of this ruko"})
+		return rh, r, false
+	}
+	return rh, r, true
+}
+
+// CorrectOfflineRental: PUT /api/offline-rentals/:id (fix tenant, dates or amount; same fields as create, all optional)
+func (h *Handlers) CorrectOfflineRental(c *gin.Context) {
+	rh, r, ok := h.loadOfflineRental(c)
+	if !ok {
+		return
+	}
+	var in offlineRentalInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := context.Background()
+	set := bson.M{}
+	if err := h.applyOfflineRentalInput(ctx, in, &rh, set); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkOfflinePeriod(c, r.ID, rh.StartDate, rh.EndDate, rh.ID) {
+		return
+	}
+	rh.UpdatedAt = time.Now()
+	set["updated_at"] = rh.UpdatedAt
+	if err := h.repo.RentalHistory.Update(ctx, rh.ID, set); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update offline rental"})
+		return
+	}
+	h.syncOfflineStatus(ctx, r.ID)
+	h.publishRukoEvent(ctx, r.ID, "rental.offline_corrected", map[string]interface{}{"rental_id": rh.ID.Hex()})
+	c.JSON(http.StatusOK, rh)
+}
+
+// EndOfflineRental: POST /api/offline-rentals/:id/end {"end_date"?: "YYYY-MM-DD"} (default today)
+// ends the rental early, the original end date is kept
+func (h *Handlers) EndOfflineRental(c *gin.Context) {
+	rh, r, ok := h.loadOfflineRental(c)
+	if !ok {
+		return
+	}
+	var in struct {
+		EndDate string `json:"end_date"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	now := time.Now()
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if in.EndDate != "" {
+		t, err := time.Parse("2006-01-02", in.EndDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end_date, use YYYY-MM-DD"})
+			return
+		}
+		end = t
+	}
+	if !end.After(rh.StartDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be after start_date, correct the rental instead"})
+		return
+	}
+	if !end.Before(rh.EndDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be before the current end date"})
+		return
+	}
+
+	set := bson.M{"end_date": end, "ended_at": now, "updated_at": now}
+	if rh.OriginalEndDate == nil {
+		original := rh.EndDate
+		rh.OriginalEndDate = &original
+		set["original_end_date"] = original
+	}
+	rh.EndDate, rh.EndedAt, rh.UpdatedAt = end, &now, now
+	ctx := context.Background()
+	if err := h.repo.RentalHistory.Update(ctx, rh.ID, set); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed end offline rental"})
+		return
+	}
+	h.syncOfflineStatus(ctx, r.ID)
+	h.publishRukoEvent(ctx, r.ID, "rental.offline_ended", map[string]interface{}{
+		"rental_id": rh.ID.Hex(),
+		"end_date":  end.Format("2006-01-02"),
+	})
+	c.JSON(http.StatusOK, rh)
+}
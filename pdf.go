@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// pdfTemplateFuncs are available in the text templates of generated documents
+var pdfTemplateFuncs = template.FuncMap{
+	"date":    func(t time.Time) string { return t.Format("02 January 2006") },
+	"rupiah":  formatRupiah,
+	"percent": func(rate float64) string { return fmt.Sprintf("%g%%", math.Round(rate*10000)/100) },
+}
+
+// renderTextPDF renders a simple A4 document: a title followed by the body text.
+// Lines starting with "## " are printed as section headings, blank lines separate paragraphs.
+func renderTextPDF(title, body string) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	tr := pdf.UnicodeTranslatorFromDescriptor("") // utf-8 -> cp1252 for core fonts
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.MultiCell(0, 8, tr(title), "", "C", false)
+	pdf.Ln(4)
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		switch {
+		case strings.HasPrefix(line, "## "):
+			pdf.Ln(2)
+			pdf.SetFont("Helvetica", "B", 11)
+			pdf.MultiCell(0, 6, tr(strings.TrimPrefix(line, "## ")), "", "L", false)
+		case line == "":
+			pdf.Ln(3)
+		default:
+			pdf.SetFont("Helvetica", "", 10)
+			pdf.MultiCell(0, 5, tr(line), "", "L", false)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatRupiah formats an amount as "Rp 5.000.000"
+func formatRupiah(amount float64) string {
+	n := int64(math.Round(amount))
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	s := fmt.Sprintf("%d", n)
+	var out []byte
+	for i := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			out = append(out, '.')
+		}
+		out = append(out, s[i])
+	}
+	if neg {
+		return "-Rp " + string(out)
+	}
+	return "Rp " + string(out)
+}
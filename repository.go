@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+// Unset as a value of an update set removes the field ($unset)
+var Unset = unsetField{}
+
+type unsetField struct{}
+
+// Repo is the data access used by the handlers. Filters use the Mongo query
+// syntax; update sets are applied as $set. Passing a mongo.SessionContext as
+// ctx runs the call inside that transaction.
+type Repo[T any] interface {
+	Create(ctx context.Context, doc *T) error
+	Get(ctx context.Context, id primitive.ObjectID) (T, error)
+	FindOne(ctx context.Context, filter bson.M) (T, error)
+	// Find honours the sort, skip and limit of opts
+	Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]T, error)
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// UpdateWhere updates the first document matching filter, ErrNotFound when none does.
+	// Put the expected state in the filter to change a document only from that state.
+	UpdateWhere(ctx context.Context, filter bson.M, set bson.M) error
+	// UpdateMany updates every document matching filter and returns how many matched
+	UpdateMany(ctx context.Context, filter bson.M, set bson.M) (int64, error)
+	// Delete removes the first document matching filter, ErrNotFound when none does
+	Delete(ctx context.Context, filter bson.M) error
+}
+
+type UserRepo interface{ Repo[User] }
+
+type RukoRepo interface{ Repo[Ruko] }
+
+type BookingRepo interface{ Repo[Booking] }
+
+type PaymentRepo interface{ Repo[Payment] }
+
+type DiscountRepo interface{ Repo[Discount] }
+
+type RentalHistoryRepo interface{ Repo[RentalHistory] }
+
+type InvoiceRepo interface{ Repo[Invoice] }
+
+type ReceiptRepo interface{ Repo[Receipt] }
+
+type ContractRepo interface{ Repo[Contract] }
+
+// LedgerRepo stores the ledger transactions and sums their postings
+type LedgerRepo interface {
+	Repo[LedgerTransaction]
+	// Totals sums debit and credit per owner, transaction type and account; nil owners means all
+	Totals(ctx context.Context, owners []primitive.ObjectID) ([]LedgerTotal, error)
+}
+
+// LedgerTotal is one group of LedgerRepo.Totals
+type LedgerTotal struct {
+	OwnerID primitive.ObjectID `bson:"owner"`
+	Type    string             `bson:"type"`
+	Account string             `bson:"account"`
+	Debit   float64            `bson:"debit"`
+	Credit  float64            `bson:"credit"`
+}
+
+type PayoutBatchRepo interface{ Repo[PayoutBatch] }
+
+type AuditLogRepo interface{ Repo[AuditLog] }
+
+type IdempotencyRepo interface{ Repo[IdempotencyRecord] }
+
+type ActivityRepo interface{ Repo[Activity] }
+
+type FavoriteRepo interface{ Repo[Favorite] }
+
+type SavedSearchRepo interface{ Repo[SavedSearch] }
+
+type ViewingWindowRepo interface{ Repo[ViewingWindow] }
+
+type SiteVisitRepo interface{ Repo[SiteVisit] }
+
+type ConversationRepo interface{ Repo[Conversation] }
+
+type MessageRepo interface{ Repo[Message] }
+
+type NotificationRepo interface{ Repo[Notification] }
+
+type ReviewRepo interface{ Repo[Review] }
+
+// Counters hands out gap-free sequence numbers (invoice and receipt numbers)
+type Counters interface {
+	Next(ctx context.Context, key string) (int64, error)
+}
+
+// Transactor runs fn so that its writes through the repos are all kept or all discarded.
+// fn may be retried on transient errors, so it must be safe to run again.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error
+}
+
+// Repositories groups the repos a Handlers depends on
+type Repositories struct {
+	Users          UserRepo
+	Rukos          RukoRepo
+	Bookings       BookingRepo
+	Payments       PaymentRepo
+	Discounts      DiscountRepo
+	RentalHistory  RentalHistoryRepo
+	Invoices       InvoiceRepo
+	Receipts       ReceiptRepo
+	Contracts      ContractRepo
+	Ledger         LedgerRepo
+	PayoutBatches  PayoutBatchRepo
+	AuditLogs      AuditLogRepo
+	Idempotency    IdempotencyRepo
+	Activities     ActivityRepo
+	Favorites      FavoriteRepo
+	SavedSearches  SavedSearchRepo
+	ViewingWindows ViewingWindowRepo
+	SiteVisits     SiteVisitRepo
+	Conversations  ConversationRepo
+	Messages       MessageRepo
+	Notifications  NotificationRepo
+	Reviews        ReviewRepo
+	Counters       Counters
+	Tx             Transactor
+}
+
+func NewMongoRepositories(db *mongo.Database) Repositories {
+	return Repositories{
+		Users:          NewMongoRepo[User](db.Collection("users")),
+		Rukos:          NewMongoRepo[Ruko](db.Collection("ruko")),
+		Bookings:       NewMongoRepo[Booking](db.Collection("bookings")),
+		Payments:       NewMongoRepo[Payment](db.Collection("payments")),
+		Discounts:      NewMongoRepo[Discount](db.Collection("discounts")),
+		RentalHistory:  NewMongoRepo[RentalHistory](db.Collection("rental_history")),
+		Invoices:       NewMongoRepo[Invoice](db.Collection("invoices")),
+		Receipts:       NewMongoRepo[Receipt](db.Collection("receipts")),
+		Contracts:      NewMongoRepo[Contract](db.Collection("contracts")),
+		Ledger:         &MongoLedgerRepo{MongoRepo: NewMongoRepo[LedgerTransaction](db.Collection("ledger"))},
+		PayoutBatches:  NewMongoRepo[PayoutBatch](db.Collection("payout_batches")),
+		AuditLogs:      NewMongoRepo[AuditLog](db.Collection("audit_logs", auditCollectionOptions)),
+		Idempotency:    NewMongoRepo[IdempotencyRecord](db.Collection("idempotency_keys")),
+		Activities:     NewMongoRepo[Activity](db.Collection("activities")),
+		Favorites:      NewMongoRepo[Favorite](db.Collection("favorites")),
+		SavedSearches:  NewMongoRepo[SavedSearch](db.Collection("saved_searches")),
+		ViewingWindows: NewMongoRepo[ViewingWindow](db.Collection("viewing_windows")),
+		SiteVisits:     NewMongoRepo[SiteVisit](db.Collection("site_visits")),
+		Conversations:  NewMongoRepo[Conversation](db.Collection("conversations")),
+		Messages:       NewMongoRepo[Message](db.Collection("messages")),
+		Notifications:  NewMongoRepo[Notification](db.Collection("notifications")),
+		Reviews:        NewMongoRepo[Review](db.Collection("reviews")),
+		Counters:       mongoCounters{col: db.Collection("counters")},
+		Tx:             mongoTransactor{client: db.Client()},
+	}
+}
+
+// NewMemoryRepositories keeps everything in process, for handler tests without Mongo.
+// The unique indexes match ensureIndexes.
+func NewMemoryRepositories() Repositories {
+	// one live (unpaid or paid) invoice per booking installment
+	invoiceInstallment := unique("booking_id", "installment").where(bson.M{"status": bson.M{"$in": bson.A{"unpaid", "paid"}}})
+	repos := Repositories{
+		Users:          NewMemoryRepo[User](unique("email")),
+		Rukos:          NewMemoryRepo[Ruko](),
+		Bookings:       NewMemoryRepo[Booking](),
+		Payments:       NewMemoryRepo[Payment](),
+		Discounts:      NewMemoryRepo[Discount](),
+		RentalHistory:  NewMemoryRepo[RentalHistory](unique("booking_id").where(bson.M{"booking_id": bson.M{"$exists": true}})),
+		Invoices:       NewMemoryRepo[Invoice](unique("number"), invoiceInstallment),
+		Receipts:       NewMemoryRepo[Receipt](unique("number")),
+		Contracts:      NewMemoryRepo[Contract](unique("booking_id", "version")),
+		Ledger:         &MemoryLedgerRepo{MemoryRepo: NewMemoryRepo[LedgerTransaction](unique("key"))},
+		PayoutBatches:  NewMemoryRepo[PayoutBatch](),
+		AuditLogs:      NewMemoryRepo[AuditLog](),
+		Idempotency:    NewMemoryRepo[IdempotencyRecord](unique("user_id", "key")),
+		Activities:     NewMemoryRepo[Activity](),
+		Favorites:      NewMemoryRepo[Favorite](unique("user_id", "ruko_id")),
+		SavedSearches:  NewMemoryRepo[SavedSearch](),
+		ViewingWindows: NewMemoryRepo[ViewingWindow](),
+		SiteVisits:     NewMemoryRepo[SiteVisit](),
+		Conversations:  NewMemoryRepo[Conversation](unique("ruko_id", "tenant_id", "booking_id")),
+		Messages:       NewMemoryRepo[Message](),
+		Notifications:  NewMemoryRepo[Notification](),
+		Reviews:        NewMemoryRepo[Review](unique("rental_history_id")),
+		Counters:       &memoryCounters{seq: map[string]int64{}},
+	}
+	repos.Tx = newMemoryTransactor(repos)
+	return repos
+}
+
+// document is a model stored with an ObjectID _id
+type document[T any] interface {
+	*T
+	docID() primitive.ObjectID
+	setDocID(id primitive.ObjectID)
+}
+
+func (u *User) docID() primitive.ObjectID                   { return u.ID }
+func (u *User) setDocID(id primitive.ObjectID)              { u.ID = id }
+func (r *Ruko) docID() primitive.ObjectID                   { return r.ID }
+func (r *Ruko) setDocID(id primitive.ObjectID)              { r.ID = id }
+func (b *Booking) docID() primitive.ObjectID                { return b.ID }
+func (b *Booking) setDocID(id primitive.ObjectID)           { b.ID = id }
+func (p *Payment) docID() primitive.ObjectID                { return p.ID }
+func (p *Payment) setDocID(id primitive.ObjectID)           { p.ID = id }
+func (d *Discount) docID() primitive.ObjectID               { return d.ID }
+func (d *Discount) setDocID(id primitive.ObjectID)          { d.ID = id }
+func (r *RentalHistory) docID() primitive.ObjectID          { return r.ID }
+func (r *RentalHistory) setDocID(id primitive.ObjectID)     { r.ID = id }
+func (i *Invoice) docID() primitive.ObjectID                { return i.ID }
+func (i *Invoice) setDocID(id primitive.ObjectID)           { i.ID = id }
+func (r *Receipt) docID() primitive.ObjectID                { return r.ID }
+func (r *Receipt) setDocID(id primitive.ObjectID)           { r.ID = id }
+func (c *Contract) docID() primitive.ObjectID               { return c.ID }
+func (c *Contract) setDocID(id primitive.ObjectID)          { c.ID = id }
+func (t *LedgerTransaction) docID() primitive.ObjectID      { return t.ID }
+func (t *LedgerTransaction) setDocID(id primitive.ObjectID) { t.ID = id }
+func (b *PayoutBatch) docID() primitive.ObjectID            { return b.ID }
+func (b *PayoutBatch) setDocID(id primitive.ObjectID)       { b.ID = id }
+func (a *AuditLog) docID() primitive.ObjectID               { return a.ID }
+func (a *AuditLog) setDocID(id primitive.ObjectID)          { a.ID = id }
+func (r *IdempotencyRecord) docID() primitive.ObjectID      { return r.ID }
+func (r *IdempotencyRecord) setDocID(id primitive.ObjectID) { r.ID = id }
+func (a *Activity) docID() primitive.ObjectID               { return a.ID }
+func (a *Activity) setDocID(id primitive.ObjectID)          { a.ID = id }
+func (f *Favorite) docID() primitive.ObjectID               { return f.ID }
+func (f *Favorite) setDocID(id primitive.ObjectID)          { f.ID = id }
+func (s *SavedSearch) docID() primitive.ObjectID            { return s.ID }
+func (s *SavedSearch) setDocID(id primitive.ObjectID)       { s.ID = id }
+func (w *ViewingWindow) docID() primitive.ObjectID          { return w.ID }
+func (w *ViewingWindow) setDocID(id primitive.ObjectID)     { w.ID = id }
+func (v *SiteVisit) docID() primitive.ObjectID              { return v.ID }
+func (v *SiteVisit) setDocID(id primitive.ObjectID)         { v.ID = id }
+func (c *Conversation) docID() primitive.ObjectID           { return c.ID }
+func (c *Conversation) setDocID(id primitive.ObjectID)      { c.ID = id }
+func (m *Message) docID() primitive.ObjectID                { return m.ID }
+func (m *Message) setDocID(id primitive.ObjectID)           { m.ID = id }
+func (n *Notification) docID() primitive.ObjectID           { return n.ID }
+func (n *Notification) setDocID(id primitive.ObjectID)      { n.ID = id }
+func (r *Review) docID() primitive.ObjectID                 { return r.ID }
+func (r *Review) setDocID(id primitive.ObjectID)            { r.ID = id }
+
+// MongoRepo stores T in one collection
+type MongoRepo[T any, PT document[T]] struct {
+	col *mongo.Collection
+}
+
+func NewMongoRepo[T any, PT document[T]](col *mongo.Collection) *MongoRepo[T, PT] {
+	return &MongoRepo[T, PT]{col: col}
+}
+
+func (r *MongoRepo[T, PT]) Create(ctx context.Context, doc *T) error {
+	res, err := r.col.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	PT(doc).setDocID(res.InsertedID.(primitive.ObjectID))
+	return nil
+}
+
+func (r *MongoRepo[T, PT]) Get(ctx context.Context, id primitive.ObjectID) (T, error) {
+	return r.FindOne(ctx, bson.M{"_id": id})
+}
+
+func (r *MongoRepo[T, PT]) FindOne(ctx context.Context, filter bson.M) (T, error) {
+	var out T
+	err := r.col.FindOne(ctx, filter).Decode(&out)
+	if err == mongo.ErrNoDocuments {
+		return out, ErrNotFound
+	}
+	return out, err
+}
+
+func (r *MongoRepo[T, PT]) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]T, error) {
+	cur, err := r.col.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []T{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *MongoRepo[T, PT]) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.col.CountDocuments(ctx, filter)
+}
+
+func (r *MongoRepo[T, PT]) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return r.UpdateWhere(ctx, bson.M{"_id": id}, set)
+}
+
+func (r *MongoRepo[T, PT]) UpdateWhere(ctx context.Context, filter bson.M, set bson.M) error {
+	res, err := r.col.UpdateOne(ctx, filter, mongoUpdate(set))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoRepo[T, PT]) UpdateMany(ctx context.Context, filter bson.M, set bson.M) (int64, error) {
+	res, err := r.col.UpdateMany(ctx, filter, mongoUpdate(set))
+	if err != nil {
+		return 0, err
+	}
+	return res.MatchedCount, nil
+}
+
+func (r *MongoRepo[T, PT]) Delete(ctx context.Context, filter bson.M) error {
+	res, err := r.col.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// helper: split an update set into $set and $unset
+func mongoUpdate(set bson.M) bson.M {
+	fields, unset := bson.M{}, bson.M{}
+	for k, v := range set {
+		if v == Unset {
+			unset[k] = ""
+		} else {
+			fields[k] = v
+		}
+	}
+	update := bson.M{}
+	if len(fields) > 0 {
+		update["$set"] = fields
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// MongoLedgerRepo sums the postings with an aggregation
+type MongoLedgerRepo struct {
+	*MongoRepo[LedgerTransaction, *LedgerTransaction]
+}
+
+func (r *MongoLedgerRepo) Totals(ctx context.Context, owners []primitive.ObjectID) ([]LedgerTotal, error) {
+	match := bson.M{}
+	if owners != nil {
+		match["owner_id"] = bson.M{"$in": owners}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$entries"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"owner": "$owner_id", "type": "$type", "account": "$entries.account"},
+			"debit":  bson.M{"$sum": "$entries.debit"},
+			"credit": bson.M{"$sum": "$entries.credit"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"owner": "$_id.owner", "type": "$_id.type", "account": "$_id.account", "debit": 1, "credit": 1,
+		}}},
+	}
+	cur, err := r.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var out []LedgerTotal
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// mongoCounters keeps one document per key in the counters collection
+type mongoCounters struct {
+	col *mongo.Collection
+}
+
+func (c mongoCounters) Next(ctx context.Context, key string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := c.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+	return counter.Seq, err
+}
+
+// mongoTransactor runs fn in a transaction, retried by the driver on transient errors
+// (TransientTransactionError / UnknownTransactionCommitResult)
+type mongoTransactor struct {
+	client *mongo.Client
+}
+
+func (t mongoTransactor) WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	sess, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+	opts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	}, opts)
+	return err
+}
@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryRepo keeps documents in insertion order and understands the filter
+// operators the handlers use ($eq, $ne, $in, $nin, $gt, $gte, $lt, $lte, $all,
+// $exists, $and, $or). Geo and text queries are not supported.
+type MemoryRepo[T any, PT document[T]] struct {
+	mu     sync.RWMutex
+	docs   []bson.M
+	unique []uniqueIndex
+}
+
+// uniqueIndex is a unique Mongo index: no two documents matching filter
+// (all of them when nil) share the values of fields
+type uniqueIndex struct {
+	fields []string
+	filter bson.M
+}
+
+func unique(fields ...string) uniqueIndex { return uniqueIndex{fields: fields} }
+
+// where makes it a partial index
+func (u uniqueIndex) where(filter bson.M) uniqueIndex {
+	u.filter = filter
+	return u
+}
+
+func (u uniqueIndex) conflicts(a, b bson.M) bool {
+	for _, d := range []bson.M{a, b} {
+		if u.filter == nil {
+			break
+		}
+		if ok, _ := matchDocument(d, u.filter); !ok {
+			return false
+		}
+	}
+	for _, f := range u.fields {
+		x, _ := lookupField(a, f)
+		y, _ := lookupField(b, f)
+		if !valuesEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewMemoryRepo[T any, PT document[T]](unique ...uniqueIndex) *MemoryRepo[T, PT] {
+	return &MemoryRepo[T, PT]{unique: unique}
+}
+
+func (r *MemoryRepo[T, PT]) Create(ctx context.Context, doc *T) error {
+	if PT(doc).docID().IsZero() {
+		PT(doc).setDocID(primitive.NewObjectID())
+	}
+	m, err := toBSONDoc(doc)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkUnique(m, nil); err != nil {
+		return err
+	}
+	r.docs = append(r.docs, m)
+	return nil
+}
+
+// helper: ErrDuplicate when m clashes with a stored document other than self
+func (r *MemoryRepo[T, PT]) checkUnique(m bson.M, self bson.M) error {
+	for _, d := range r.docs {
+		if self != nil && d["_id"] == self["_id"] {
+			continue
+		}
+		if d["_id"] == m["_id"] {
+			return ErrDuplicate
+		}
+		for _, u := range r.unique {
+			if u.conflicts(d, m) {
+				return ErrDuplicate
+			}
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepo[T, PT]) Get(ctx context.Context, id primitive.ObjectID) (T, error) {
+	return r.FindOne(ctx, bson.M{"_id": id})
+}
+
+func (r *MemoryRepo[T, PT]) FindOne(ctx context.Context, filter bson.M) (T, error) {
+	var out T
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.docs {
+		ok, err := matchDocument(d, filter)
+		if err != nil {
+			return out, err
+		}
+		if ok {
+			return out, fromBSONDoc(d, &out)
+		}
+	}
+	return out, ErrNotFound
+}
+
+func (r *MemoryRepo[T, PT]) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var matched []bson.M
+	for _, d := range r.docs {
+		ok, err := matchDocument(d, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, d)
+		}
+	}
+	var skip, limit int64
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Sort != nil {
+			if err := sortDocuments(matched, o.Sort); err != nil {
+				return nil, err
+			}
+		}
+		if o.Skip != nil {
+			skip = *o.Skip
+		}
+		if o.Limit != nil {
+			limit = *o.Limit
+		}
+	}
+	matched = matched[min(skip, int64(len(matched))):]
+	if limit > 0 && limit < int64(len(matched)) {
+		matched = matched[:limit]
+	}
+	out := []T{}
+	for _, d := range matched {
+		var v T
+		if err := fromBSONDoc(d, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// helper: stable sort by a bson.D sort spec, missing fields first like Mongo's null
+func sortDocuments(docs []bson.M, spec interface{}) error {
+	keys, ok := spec.(bson.D)
+	if !ok {
+		return fmt.Errorf("memory repo: sort must be a bson.D")
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, k := range keys {
+			a, _ := lookupField(docs[i], k.Key)
+			b, _ := lookupField(docs[j], k.Key)
+			c, ok := compareValues(a, b)
+			if !ok {
+				switch {
+				case a == nil && b != nil:
+					c = -1
+				case a != nil && b == nil:
+					c = 1
+				}
+			}
+			if dir, _ := bsonNumber(normalizeBSONValue(k.Value)); dir < 0 {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func (r *MemoryRepo[T, PT]) Count(ctx context.Context, filter bson.M) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var n int64
+	for _, d := range r.docs {
+		ok, err := matchDocument(d, filter)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryRepo[T, PT]) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return r.UpdateWhere(ctx, bson.M{"_id": id}, set)
+}
+
+func (r *MemoryRepo[T, PT]) UpdateWhere(ctx context.Context, filter bson.M, set bson.M) error {
+	n, err := r.update(filter, set, 1)
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *MemoryRepo[T, PT]) UpdateMany(ctx context.Context, filter bson.M, set bson.M) (int64, error) {
+	return r.update(filter, set, -1)
+}
+
+// update applies set to at most limit matching documents (all when limit < 0)
+func (r *MemoryRepo[T, PT]) update(filter bson.M, set bson.M, limit int) (int64, error) {
+	for k := range set {
+		if strings.Contains(k, ".") || k == "_id" {
+			return 0, fmt.Errorf("memory repo: cannot set %q", k)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for i, d := range r.docs {
+		if limit >= 0 && n >= int64(limit) {
+			break
+		}
+		ok, err := matchDocument(d, filter)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+		updated := maps.Clone(d)
+		for k, v := range set {
+			if v == Unset {
+				delete(updated, k)
+			} else {
+				updated[k] = normalizeBSONValue(v)
+			}
+		}
+		if err := r.checkUnique(updated, d); err != nil {
+			return n, err
+		}
+		r.docs[i] = updated
+		n++
+	}
+	return n, nil
+}
+
+func (r *MemoryRepo[T, PT]) Delete(ctx context.Context, filter bson.M) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, d := range r.docs {
+		ok, err := matchDocument(d, filter)
+		if err != nil {
+			return err
+		}
+		if ok {
+			r.docs = append(r.docs[:i:i], r.docs[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// helper: document as stored by Mongo, so filters see the same field names and types
+func toBSONDoc(v interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	err = bson.Unmarshal(raw, &m)
+	return m, err
+}
+
+// fromBSONDoc decodes nested documents in interface{} fields as maps, like the audit log collection
+func fromBSONDoc(m bson.M, out interface{}) error {
+	raw, err := bson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+	if err != nil {
+		return err
+	}
+	dec.DefaultDocumentM()
+	return dec.Decode(out)
+}
+
+// helper: convert a Go value (time.Time, int, []string, ...) to its decoded bson form
+func normalizeBSONValue(v interface{}) interface{} {
+	m, err := toBSONDoc(bson.M{"v": v})
+	if err != nil {
+		return v
+	}
+	return m["v"]
+}
+
+// matchDocument reports whether doc matches a Mongo query filter
+func matchDocument(doc bson.M, filter bson.M) (bool, error) {
+	for k, cond := range filter {
+		switch k {
+		case "$and", "$or":
+			subs, err := filterList(cond)
+			if err != nil {
+				return false, err
+			}
+			matched := false
+			for _, sub := range subs {
+				ok, err := matchDocument(doc, sub)
+				if err != nil {
+					return false, err
+				}
+				if k == "$and" && !ok {
+					return false, nil
+				}
+				matched = matched || ok
+			}
+			if k == "$or" && !matched {
+				return false, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(k, "$") {
+			return false, fmt.Errorf("memory repo: unsupported operator %s", k)
+		}
+		val, exists := lookupField(doc, k)
+		ok, err := matchField(val, exists, cond)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchField(val interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, isOps := asDocument(cond)
+	if isOps {
+		for k := range ops {
+			if !strings.HasPrefix(k, "$") {
+				isOps = false
+				break
+			}
+		}
+	}
+	if !isOps {
+		return matchEqual(val, normalizeBSONValue(cond)), nil
+	}
+	for op, arg := range ops {
+		want := normalizeBSONValue(arg)
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = matchEqual(val, want)
+		case "$ne":
+			ok = !matchEqual(val, want)
+		case "$in", "$nin":
+			list, isList := want.(primitive.A)
+			if !isList {
+				return false, fmt.Errorf("memory repo: %s needs an array", op)
+			}
+			for _, item := range list {
+				if matchEqual(val, item) {
+					ok = true
+					break
+				}
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$all":
+			list, isList := want.(primitive.A)
+			if !isList {
+				return false, fmt.Errorf("memory repo: $all needs an array")
+			}
+			ok = exists
+			for _, item := range list {
+				if !matchEqual(val, item) {
+					ok = false
+					break
+				}
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = matchCompare(val, want, op)
+		case "$exists":
+			b, _ := want.(bool)
+			ok = b == exists
+		default:
+			return false, fmt.Errorf("memory repo: unsupported operator %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// helper: equality with Mongo array semantics, an array matches if any element does
+func matchEqual(val, want interface{}) bool {
+	if re, ok := want.(primitive.Regex); ok {
+		return matchRegex(val, re)
+	}
+	if arr, ok := val.(primitive.A); ok {
+		for _, item := range arr {
+			if valuesEqual(item, want) {
+				return true
+			}
+		}
+		return reflect.DeepEqual(arr, want)
+	}
+	return valuesEqual(val, want)
+}
+
+// helper: a string, or any string in an array, matches the pattern
+func matchRegex(val interface{}, re primitive.Regex) bool {
+	expr := re.Pattern
+	if re.Options != "" {
+		expr = "(?" + re.Options + ")" + expr
+	}
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return false
+	}
+	items := []interface{}{val}
+	if arr, ok := val.(primitive.A); ok {
+		items = arr
+	}
+	for _, item := range items {
+		if s, ok := item.(string); ok && compiled.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCompare(val, want interface{}, op string) bool {
+	items := []interface{}{val}
+	if arr, ok := val.(primitive.A); ok {
+		items = arr
+	}
+	for _, item := range items {
+		c, ok := compareValues(item, want)
+		if !ok {
+			continue
+		}
+		switch {
+		case op == "$gt" && c > 0, op == "$gte" && c >= 0, op == "$lt" && c < 0, op == "$lte" && c <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two bson values of the same kind; ok is false when they can't be compared
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := bsonNumber(a); ok {
+		y, ok := bsonNumber(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compareInt64(int64(x), int64(y)), true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			return 1, true
+		}
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func compareInt64(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func bsonNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// helper: value of a dotted path, e.g. "pricing.total"
+func lookupField(doc bson.M, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := asDocument(cur)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func asDocument(v interface{}) (bson.M, bool) {
+	switch x := v.(type) {
+	case bson.M:
+		return x, true
+	case map[string]interface{}:
+		return x, true
+	case bson.D:
+		m := bson.M{}
+		for _, e := range x {
+			m[e.Key] = e.Value
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+func filterList(v interface{}) ([]bson.M, error) {
+	switch x := v.(type) {
+	case []bson.M:
+		return x, nil
+	case []interface{}:
+		out := make([]bson.M, 0, len(x))
+		for _, item := range x {
+			m, ok := asDocument(item)
+			if !ok {
+				return nil, fmt.Errorf("memory repo: $and/$or needs documents")
+			}
+			out = append(out, m)
+		}
+		return out, nil
+	case bson.A:
+		return filterList([]interface{}(x))
+	}
+	return nil, fmt.Errorf("memory repo: $and/$or needs an array")
+}
+
+// snapshot copies the documents; the returned func puts them back
+func (r *MemoryRepo[T, PT]) snapshot() func() {
+	r.mu.RLock()
+	saved := make([]bson.M, len(r.docs))
+	copy(saved, r.docs) // updates replace the document, stored ones are never modified
+	r.mu.RUnlock()
+	return func() {
+		r.mu.Lock()
+		r.docs = saved
+		r.mu.Unlock()
+	}
+}
+
+type memorySnapshotter interface{ snapshot() func() }
+
+// MemoryLedgerRepo sums the postings in process
+type MemoryLedgerRepo struct {
+	*MemoryRepo[LedgerTransaction, *LedgerTransaction]
+}
+
+func (r *MemoryLedgerRepo) Totals(ctx context.Context, owners []primitive.ObjectID) ([]LedgerTotal, error) {
+	filter := bson.M{}
+	if owners != nil {
+		filter["owner_id"] = bson.M{"$in": owners}
+	}
+	txs, err := r.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	type group struct {
+		owner        primitive.ObjectID
+		typ, account string
+	}
+	sums := map[group]*LedgerTotal{}
+	var out []LedgerTotal
+	var order []group
+	for _, tx := range txs {
+		for _, e := range tx.Entries {
+			g := group{tx.OwnerID, tx.Type, e.Account}
+			if sums[g] == nil {
+				sums[g] = &LedgerTotal{OwnerID: tx.OwnerID, Type: tx.Type, Account: e.Account}
+				order = append(order, g)
+			}
+			sums[g].Debit += e.Debit
+			sums[g].Credit += e.Credit
+		}
+	}
+	for _, g := range order {
+		out = append(out, *sums[g])
+	}
+	return out, nil
+}
+
+// memoryCounters is Counters in process
+type memoryCounters struct {
+	mu  sync.Mutex
+	seq map[string]int64
+}
+
+func (c *memoryCounters) Next(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq[key]++
+	return c.seq[key], nil
+}
+
+func (c *memoryCounters) snapshot() func() {
+	c.mu.Lock()
+	saved := maps.Clone(c.seq)
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		c.seq = saved
+		c.mu.Unlock()
+	}
+}
+
+// memoryTransactor runs transactions one at a time; when fn fails every memory
+// repo is put back as it was
+type memoryTransactor struct {
+	mu    sync.Mutex
+	repos []memorySnapshotter
+}
+
+// newMemoryTransactor covers every repo of repos that can take a snapshot
+func newMemoryTransactor(repos Repositories) *memoryTransactor {
+	t := &memoryTransactor{}
+	v := reflect.ValueOf(repos)
+	for i := 0; i < v.NumField(); i++ {
+		if s, ok := v.Field(i).Interface().(memorySnapshotter); ok {
+			t.repos = append(t.repos, s)
+		}
+	}
+	return t
+}
+
+func (t *memoryTransactor) WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var restore []func()
+	for _, s := range t.repos {
+		restore = append(restore, s.snapshot())
+	}
+	if err := fn(mongo.NewSessionContext(ctx, nil)); err != nil {
+		for _, undo := range restore {
+			undo()
+		}
+		return err
+	}
+	return nil
+}
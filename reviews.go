@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RatingSummary is the aggregated rating of a ruko or owner
+type RatingSummary struct {
+	Average float64 `bson:"average" json:"average"`
+	Count   int     `bson:"count" json:"count"`
+}
+
+// helper: average of the visible reviews matching filter
+func (h *Handlers) ratingSummary(ctx context.Context, match bson.M) (RatingSummary, error) {
+	match["hidden"] = bson.M{"$ne": true}
+	var sum RatingSummary
+	reviews, err := h.repo.Reviews.Find(ctx, match, options.Find().SetProjection(bson.M{"rating": 1}))
+	if err != nil {
+		return sum, err
+	}
+	total := 0
+	for _, r := range reviews {
+		total += r.Rating
+	}
+	sum.Count = len(reviews)
+	if sum.Count > 0 {
+		sum.Average = math.Round(float64(total)/float64(sum.Count)*10) / 10
+	}
+	return sum, nil
+}
+
+// refreshRukoRating recomputes rating_avg / rating_count on the ruko
+func (h *Handlers) refreshRukoRating(ctx context.Context, rukoID primitive.ObjectID) {
+	sum, err := h.ratingSummary(ctx, bson.M{"ruko_id": rukoID})
+	if err != nil {
+		log.Println("rating aggregation error:", err)
+		return
+	}
+	err = h.repo.Rukos.Update(ctx, rukoID, bson.M{
+		"rating_avg":   sum.Average,
+		"rating_count": sum.Count,
+	})
+	if err != nil {
+		log.Println("update ruko rating error:", err)
+	}
+}
+
+// CreateReview: POST /api/ruko/:id/reviews
+// only the tenant of a completed rental can review, once per rental
+func (h *Handlers) CreateReview(c *gin.Context) {
+	rukoOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var in struct {
+		RentalHistoryID string `json:"rental_history_id" binding:"required"`
+		Rating          int    `json:"rating" binding:"required,min=1,max=5"`
+		Comment         string `json:"comment" binding:"max=2000"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rhOID, err := primitive.ObjectIDFromHex(in.RentalHistoryID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rental_history_id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+
+	ctx := context.Background()
+	rh, err := h.repo.RentalHistory.Get(ctx, rhOID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rental history not found"})
+		return
+	}
+	if rh.TenantID != uid || rh.RukoID != rukoOID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you can only review your own rental of this ruko"})
+		return
+	}
+	if rh.EndDate.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rental is not completed yet"})
+		return
+	}
+	r, err := h.repo.Rukos.Get(ctx, rukoOID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return
+	}
+
+	now := time.Now()
+	review := Review{
+		RukoID:          rukoOID,
+		OwnerID:         r.OwnerID,
+		TenantID:        uid,
+		RentalHistoryID: rhOID,
+		Rating:          in.Rating,
+		Comment:         in.Comment,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	err = h.repo.Reviews.Create(ctx, &review)
+	if err == ErrDuplicate {
+		c.JSON(http.StatusConflict, gin.H{"error": "this rental has already been reviewed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create review"})
+		return
+	}
+	h.refreshRukoRating(ctx, rukoOID)
+	h.publishRukoEvent(ctx, rukoOID, "review.created",
+		map[string]interface{}{"review_id": review.ID.Hex(), "rating": review.Rating})
+	c.JSON(http.StatusCreated, review)
+}
+
+// ListRukoReviews: GET /api/ruko/:id/reviews (public, hidden reviews excluded)
+func (h *Handlers) ListRukoReviews(c *gin.Context) {
+	rukoOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	out, err := h.repo.Reviews.Find(ctx, bson.M{"ruko_id": rukoOID, "hidden": bson.M{"$ne": true}}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list reviews"})
+		return
+	}
+	sum, _ := h.ratingSummary(ctx, bson.M{"ruko_id": rukoOID})
+	c.JSON(http.StatusOK, gin.H{"rating": sum, "reviews": out})
+}
+
+// GetOwnerRating: GET /api/owners/:id/rating
+func (h *Handlers) GetOwnerRating(c *gin.Context) {
+	ownerOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	sum, err := h.ratingSummary(context.Background(), bson.M{"owner_id": ownerOID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed aggregate rating"})
+		return
+	}
+	c.JSON(http.StatusOK, sum)
+}
+
+// RespondReview: PUT /api/reviews/:id/response (owner of the ruko)
+func (h *Handlers) RespondReview(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var in struct {
+		Response string `json:"response" binding:"required,max=2000"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	ctx := context.Background()
+	review, err := h.repo.Reviews.Get(ctx, oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return
+	}
+	if review.OwnerID != uid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the ruko owner can respond"})
+		return
+	}
+	now := time.Now()
+	review.OwnerResponse = in.Response
+	review.RespondedAt = &now
+	review.UpdatedAt = now
+	err = h.repo.Reviews.Update(ctx, oid, bson.M{
+		"owner_response": review.OwnerResponse,
+		"responded_at":   now,
+		"updated_at":     now,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update review"})
+		return
+	}
+	c.JSON(http.StatusOK, review)
+}
+
+// HideReview: PATCH /api/reviews/:id/hide (admin moderation)
+func (h *Handlers) HideReview(c *gin.Context) {
+	h.setReviewHidden(c, true)
+}
+
+// UnhideReview: PATCH /api/reviews/:id/unhide
+func (h *Handlers) UnhideReview(c *gin.Context) {
+	h.setReviewHidden(c, false)
+}
+
+func (h *Handlers) setReviewHidden(c *gin.Context, hidden bool) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.ShouldBindJSON(&in) // reason is optional
+	adminID, _ := GetUserIDFromContext(c)
+
+	set := bson.M{"hidden": true, "hidden_reason": in.Reason, "hidden_by": adminID, "updated_at": time.Now()}
+	if !hidden {
+		set = bson.M{"hidden": false, "hidden_reason": Unset, "hidden_by": Unset, "updated_at": time.Now()}
+	}
+	ctx := context.Background()
+	err = h.repo.Reviews.Update(ctx, oid, set)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update review"})
+		return
+	}
+	review, err := h.repo.Reviews.Get(ctx, oid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return
+	}
+	h.refreshRukoRating(ctx, review.RukoID)
+	c.JSON(http.StatusOK, review)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"net/http"
+	"strings"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -9,8 +12,8 @@ func SetupRoutes(r *gin.Engine, h *Handlers) {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "https://ruko-space.vercel.app"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID", "Idempotent-Replayed"},
 		AllowCredentials: true,
 	}))
 
@@ -18,7 +21,24 @@ func SetupRoutes(r *gin.Engine, h *Handlers) {
 		ctx.JSON(200, "Hello World")
 	})
 
+	// uploaded files (local disk storage only)
+	if ls, ok := h.storage.(*LocalStorage); ok {
+		uploads := r.Group(ls.BaseURL)
+		uploads.Use(func(c *gin.Context) {
+			// contracts used to be stored here, they are only served through /api/bookings/:id/contract
+			if strings.HasPrefix(strings.TrimPrefix(c.Request.URL.Path, ls.BaseURL), "/contracts/") {
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			// let the file server detect the real content type
+			c.Writer.Header().Del("Content-Type")
+			c.Next()
+		})
+		uploads.Static("/", ls.Dir)
+	}
+
 	api := r.Group("/api")
+	api.Use(h.AuditMiddleware())
 	{
 		// auth
 		api.POST("/auth/register", h.Register)
@@ -26,39 +46,119 @@ func SetupRoutes(r *gin.Engine, h *Handlers) {
 
 		// public ruko listing
 		api.GET("/ruko", h.ListRuko)
+		api.GET("/ruko/nearby", h.NearbyRuko)
+		api.GET("/ruko/within", h.RukoWithinBounds)
 		api.GET("/ruko/:id", h.GetRuko)
+		api.GET("/ruko/:id/reviews", h.ListRukoReviews)
+		api.GET("/owners/:id/rating", h.GetOwnerRating)
+
+		// owner dashboard stream, also opened by EventSource with ?token=
+		api.GET("/:ownerId/events", StreamAuthMiddleware(), RoleMiddleware("owner", "admin"), h.OwnerEvents)
 
 		// authenticated routes
 		authed := api.Group("/")
 		authed.Use(AuthMiddleware())
+		// retry-safe creates, the middleware buffers the body so it is not used on uploads
+		idempotent := h.IdempotencyMiddleware()
 		{
-			authed.POST("/bookings", h.CreateBooking)
+			authed.POST("/bookings", idempotent, h.CreateBooking)
+			authed.PUT("/bookings/:id/cancel", h.CancelBooking)
 			authed.GET("/bookings", h.ListBookings)
 			authed.GET("/bookings/:id", h.GetBooking)
+			authed.GET("/bookings/:id/contract", h.GetBookingContract)
+			authed.POST("/bookings/:id/contract", h.RegenerateBookingContract)
+			authed.GET("/bookings/:id/contracts", h.ListBookingContracts)
+			authed.POST("/bookings/:id/contract/sign", h.SignBookingContract)
+			authed.GET("/bookings/:id/invoices", h.ListBookingInvoices)
+			authed.POST("/bookings/:id/invoices/split", h.SplitBookingInvoice)
+			authed.GET("/bookings/:id/receipts", h.ListBookingReceipts)
+			authed.GET("/invoices", h.ListInvoices)
+			authed.GET("/invoices/:id", h.GetInvoice)
+			authed.GET("/invoices/:id/pdf", h.GetInvoicePDF)
+			authed.GET("/receipts/:id", h.GetReceipt)
+			authed.GET("/receipts/:id/pdf", h.GetReceiptPDF)
+			authed.GET("/bookings/:id/contract/verify", h.VerifyBookingContract)
 
-			authed.POST("/payments", h.CreatePayment)
+			authed.POST("/payments", idempotent, h.CreatePayment)
 			authed.GET("/payments/:id", h.GetPayment)
 
+			// notifications
+			authed.GET("/notifications", h.ListNotifications)
+			authed.GET("/notifications/unread-count", h.UnreadNotificationCount)
+			authed.PATCH("/notifications/read-all", h.MarkAllNotificationsRead)
+			authed.PATCH("/notifications/:id/read", h.MarkNotificationRead)
+
+			// tenant-owner messaging
+			authed.POST("/conversations", h.StartConversation)
+			authed.GET("/conversations", h.ListConversations)
+			authed.GET("/conversations/:id/messages", h.ListMessages)
+			authed.POST("/conversations/:id/messages", h.SendMessage)
+			authed.PATCH("/conversations/:id/read", h.MarkConversationRead)
+
+			// site visits (survey before booking)
+			authed.GET("/ruko/:id/viewing-windows", h.ListViewingWindows)
+			authed.POST("/ruko/:id/site-visits", h.RequestSiteVisit)
+			authed.GET("/site-visits", h.ListSiteVisits)
+			authed.PUT("/site-visits/:id/cancel", h.CancelSiteVisit)
+
+			// favorites & saved searches
+			authed.GET("/favorites", h.ListFavorites)
+			authed.POST("/favorites", h.AddFavorite)
+			authed.DELETE("/favorites/:rukoId", h.RemoveFavorite)
+			authed.GET("/saved-searches", h.ListSavedSearches)
+			authed.POST("/saved-searches", h.CreateSavedSearch)
+			authed.PUT("/saved-searches/:id", h.UpdateSavedSearch)
+			authed.DELETE("/saved-searches/:id", h.DeleteSavedSearch)
+
+			// reviews
+			authed.POST("/ruko/:id/reviews", h.CreateReview)
+			authed.PUT("/reviews/:id/response", h.RespondReview)
+
 			// owner-only routes
 			owner := authed.Group("/")
 			owner.Use(RoleMiddleware("owner", "admin"))
 			{
 				owner.POST("/ruko", h.CreateRuko)
-				owner.PATCH("/ruko/:id/rented-offline", h.MarkRukoRentedOffline)
+				owner.PUT("/ruko/:id", h.UpdateRuko)
+				owner.PATCH("/ruko/:id", h.PatchRuko)
+				owner.DELETE("/ruko/:id", h.DeleteRuko)
+				owner.POST("/ruko/:id/restore", h.RestoreRuko)
+				owner.POST("/ruko/:id/images", h.UploadRukoImages)
+				owner.PUT("/ruko/:id/images/order", h.ReorderRukoImages)
+				owner.PATCH("/ruko/:id/images/:imageId/cover", h.SetRukoCoverImage)
+				owner.DELETE("/ruko/:id/images/:imageId", h.DeleteRukoImage)
+				owner.POST("/ruko/:id/viewing-windows", h.CreateViewingWindow)
+				owner.DELETE("/viewing-windows/:id", h.DeleteViewingWindow)
+				owner.PUT("/site-visits/:id/accept", h.AcceptSiteVisit)
+				owner.PUT("/site-visits/:id/decline", h.DeclineSiteVisit)
+				owner.PUT("/site-visits/:id/reschedule", h.RescheduleSiteVisit)
+				owner.POST("/ruko/:id/offline-rentals", h.CreateOfflineRental)
+				owner.PATCH("/ruko/:id/rented-offline", h.MarkRukoRentedOffline) // deprecated
+				owner.DELETE("/ruko/:id/rented-offline", h.ClearRukoRentedOffline)
+				owner.GET("/ruko/:id/offline-rentals", h.ListOfflineRentals)
+				owner.PUT("/offline-rentals/:id", h.CorrectOfflineRental)
+				owner.POST("/offline-rentals/:id/end", h.EndOfflineRental)
 				owner.PATCH("/bookings/:id/confirm-offline", h.ConfirmBookingOffline)
+				owner.PUT("/bookings/:id/accept", h.AcceptBooking)
+				owner.PUT("/bookings/:id/reject", h.RejectBooking)
 
 				// owner dashboard endpoints
 				owner.GET("/:ownerId/stats", h.GetOwnerStats)
 				owner.GET("/:ownerId/rukos", h.GetOwnerRukos)
+				owner.POST("/:ownerId/rukos/import", h.ImportRukos)
+				owner.GET("/:ownerId/imports", h.ListImportJobs)
+				owner.GET("/:ownerId/imports/:jobId", h.GetImportJob)
 				owner.GET("/:ownerId/bookings/pending", h.GetPendingBookings)
 				owner.GET("/:ownerId/bookings", h.GetAllBookings)
 				owner.GET("/:ownerId/income", h.GetIncomeData)
+				owner.GET("/:ownerId/analytics", h.GetOwnerAnalytics)
+				owner.GET("/:ownerId/export/:dataset", h.ExportOwnerData)
 				owner.GET("/:ownerId/activities/recent", h.GetRecentActivities)
+				owner.POST("/:ownerId/events/token", h.CreateEventsToken)
+				owner.GET("/:ownerId/balance", h.GetOwnerBalance)
+				owner.GET("/:ownerId/ledger", h.GetOwnerLedger)
+				owner.GET("/:ownerId/payouts", h.GetOwnerPayouts)
 			}
-			// accept/reject booking
-			authed.PUT("/bookings/:id/accept", h.AcceptBooking)
-			authed.PUT("/bookings/:id/reject", h.RejectBooking)
-
 			// admin/owner discounts & rental history
 			authed.GET("/discounts", h.ListDiscounts)
 			authed.POST("/discounts", h.CreateDiscount)
@@ -68,6 +168,18 @@ func SetupRoutes(r *gin.Engine, h *Handlers) {
 			admin.Use(RoleMiddleware("admin"))
 			{
 				admin.GET("/users", h.ListUsers)
+				admin.PATCH("/reviews/:id/hide", h.HideReview)
+				admin.PATCH("/reviews/:id/unhide", h.UnhideReview)
+				admin.GET("/ledger/balances", h.GetLedgerBalances)
+				admin.GET("/analytics/:metric", h.GetAdminAnalytics)
+				admin.POST("/payments/:id/refund", h.RefundPayment)
+				admin.POST("/payouts", h.CreatePayoutBatch)
+				admin.GET("/payouts", h.ListPayoutBatches)
+				admin.GET("/payouts/:id", h.GetPayoutBatch)
+				admin.PATCH("/payouts/:id/items/:ownerId", h.UpdatePayoutItem)
+				admin.POST("/payouts/:id/cancel", h.CancelPayoutBatch)
+				admin.GET("/audit-logs", h.ListAuditLogs)
+				admin.GET("/audit-logs/:id", h.GetAuditLog)
 			}
 
 		}
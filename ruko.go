@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// booking statuses that still occupy a ruko
+var activeBookingStatuses = []string{"waiting", "awaiting_signature", "confirmed"}
+
+// filter for rukos visible in public listing (archived rukos are hidden)
+func notArchivedFilter() bson.M {
+	return bson.M{"archived": bson.M{"$ne": true}}
+}
+
+// validateRukoFields checks the fields shared by create and update
+func validateRukoFields(price, discount float64, rentalType string, lat, lng float64) error {
+	if price <= 0 {
+		return errors.New("price must be greater than 0")
+	}
+	if discount < 0 || discount > 100 {
+		return errors.New("discount_percent must be between 0 and 100")
+	}
+	if rentalType != "monthly" && rentalType != "yearly" {
+		return errors.New("rental_type must be monthly or yearly")
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return errors.New("invalid latitude/longitude")
+	}
+	return nil
+}
+
+// helper: load ruko by :id and make sure the current user owns it (admin can access all)
+func (h *Handlers) findOwnedRuko(c *gin.Context) (Ruko, bool) {
+	var r Ruko
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return r, false
+	}
+	if r, err = h.repo.Rukos.Get(context.Background(), oid); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return r, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return r, false
+	}
+	if c.GetString("role") != "admin" && r.OwnerID != uid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: not the owner of this ruko"})
+		return r, false
+	}
+	return r, true
+}
+
+// helper: count bookings that still occupy the ruko
+func (h *Handlers) countActiveBookings(ctx context.Context, rukoID primitive.ObjectID) (int64, error) {
+	return h.repo.Bookings.Count(ctx, bson.M{
+		"ruko_id":        rukoID,
+		"booking_status": bson.M{"$in": activeBookingStatuses},
+		"end_date":       bson.M{"$gte": time.Now()},
+	})
+}
+
+// releaseRukoIfFree marks the ruko available again when no booking or offline rental holds it,
+// and lets users who saved it or search for it know
+func (h *Handlers) releaseRukoIfFree(ctx context.Context, rukoID primitive.ObjectID) {
+	r, released, err := h.freeRuko(ctx, rukoID)
+	if err != nil || !released {
+		return
+	}
+	h.announceRukoReleased(ctx, r)
+}
+
+// freeRuko is the write half of releaseRukoIfFree, usable inside a transaction;
+// released reports whether the ruko was made available
+func (h *Handlers) freeRuko(ctx context.Context, rukoID primitive.ObjectID) (Ruko, bool, error) {
+	r, err := h.repo.Rukos.Get(ctx, rukoID)
+	if err != nil {
+		return r, false, err
+	}
+	if r.IsAvailable || r.Archived || r.RentedOffline {
+		return r, false, nil
+	}
+	active, err := h.countActiveBookings(ctx, rukoID)
+	if err != nil || active > 0 {
+		return r, false, err
+	}
+	r.IsAvailable, r.UpdatedAt = true, time.Now()
+	if err := h.repo.Rukos.Update(ctx, rukoID, bson.M{"is_available": true, "updated_at": r.UpdatedAt}); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
+
+// helper: tell listeners, users who saved it and matching saved searches that the ruko is available again
+func (h *Handlers) announceRukoReleased(ctx context.Context, r Ruko) {
+	h.publishAvailability(ctx, r.ID, true)
+	h.notifyFavoritesAvailable(ctx, r)
+	h.matchSavedSearches(ctx, r)
+}
+
+// UpdateRuko: PUT /api/ruko/:id (full update)
+func (h *Handlers) UpdateRuko(c *gin.Context) {
+	h.updateRuko(c, true)
+}
+
+// PatchRuko: PATCH /api/ruko/:id (partial update)
+func (h *Handlers) PatchRuko(c *gin.Context) {
+	h.updateRuko(c, false)
+}
+
+func (h *Handlers) updateRuko(c *gin.Context, full bool) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	if r.Archived {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko is archived, restore it first"})
+		return
+	}
+
+	var in struct {
+		Name            *string  `json:"name"`
+		Description     *string  `json:"description"`
+		Address         *string  `json:"address"`
+		City            *string  `json:"city"`
+		Latitude        *float64 `json:"latitude"`
+		Longitude       *float64 `json:"longitude"`
+		Price           *float64 `json:"price"`
+		DiscountPercent *float64 `json:"discount_percent"`
+		RentalType      *string  `json:"rental_type"`
+		IsAvailable     *bool    `json:"is_available"`
+		Image           *string  `json:"image"`
+		rukoAttributesInput
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if full && (in.Name == nil || in.Price == nil || in.RentalType == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name, price and rental_type are required"})
+		return
+	}
+
+	set := bson.M{}
+	if in.Name != nil {
+		if *in.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+			return
+		}
+		r.Name = *in.Name
+		set["name"] = r.Name
+	}
+	if in.Description != nil {
+		r.Description = *in.Description
+		set["description"] = r.Description
+	}
+	if in.Address != nil {
+		r.Address = *in.Address
+		set["address"] = r.Address
+	}
+	if in.City != nil {
+		r.City = *in.City
+		set["city"] = r.City
+	}
+	if in.Price != nil {
+		r.Price = *in.Price
+		set["price"] = r.Price
+	}
+	if in.DiscountPercent != nil {
+		r.DiscountPercent = *in.DiscountPercent
+		set["discount_percent"] = r.DiscountPercent
+	}
+	if in.RentalType != nil {
+		r.RentalType = *in.RentalType
+		set["rental_type"] = r.RentalType
+	}
+	wasAvailable := r.IsAvailable
+	if in.IsAvailable != nil {
+		r.IsAvailable = *in.IsAvailable
+		set["is_available"] = r.IsAvailable
+	}
+	if in.Image != nil {
+		r.Image = *in.Image
+		set["image"] = r.Image
+	}
+	if in.Latitude != nil || in.Longitude != nil {
+		if in.Latitude != nil {
+			r.Latitude = *in.Latitude
+		}
+		if in.Longitude != nil {
+			r.Longitude = *in.Longitude
+		}
+		set["latitude"] = r.Latitude
+		set["longitude"] = r.Longitude
+		if validLatLng(r.Latitude, r.Longitude) {
+			r.Location = NewGeoPoint(r.Latitude, r.Longitude)
+			set["location"] = r.Location
+		}
+	}
+	in.rukoAttributesInput.apply(&r, set)
+	if err := validateRukoFields(r.Price, r.DiscountPercent, r.RentalType, r.Latitude, r.Longitude); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateRukoAttributes(r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(set) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+		return
+	}
+
+	r.UpdatedAt = time.Now()
+	set["updated_at"] = r.UpdatedAt
+	if err := h.repo.Rukos.Update(context.Background(), r.ID, set); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update ruko"})
+		return
+	}
+	switch {
+	case !wasAvailable && r.IsAvailable:
+		h.announceRukoReleased(context.Background(), r)
+	case wasAvailable && !r.IsAvailable:
+		h.publishAvailability(context.Background(), r.ID, false)
+	case r.IsAvailable && (set["city"] != nil || set["price"] != nil || set["rental_type"] != nil):
+		// it may match saved searches it did not match before
+		h.matchSavedSearches(context.Background(), r)
+	}
+	h.publishRukoEvent(context.Background(), r.ID, "ruko.updated", map[string]interface{}{"fields": changedFields(set)})
+	c.JSON(http.StatusOK, r)
+}
+
+// DeleteRuko: DELETE /api/ruko/:id
+// soft delete: the ruko is archived and hidden from listing but kept for booking history
+func (h *Handlers) DeleteRuko(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	if r.Archived {
+		c.JSON(http.StatusOK, gin.H{"message": "ruko already archived"})
+		return
+	}
+	active, err := h.countActiveBookings(context.Background(), r.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed check bookings"})
+		return
+	}
+	if active > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "ruko still has active bookings", "active_bookings": active})
+		return
+	}
+
+	now := time.Now()
+	err = h.repo.Rukos.Update(context.Background(), r.ID, bson.M{
+		"archived":     true,
+		"archived_at":  now,
+		"is_available": false,
+		"updated_at":   now,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed archive ruko"})
+		return
+	}
+	if r.IsAvailable {
+		h.publishAvailability(context.Background(), r.ID, false)
+	}
+	h.publishRukoEvent(context.Background(), r.ID, "ruko.archived", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "ruko archived"})
+}
+
+// RestoreRuko: POST /api/ruko/:id/restore
+func (h *Handlers) RestoreRuko(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	if !r.Archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ruko is not archived"})
+		return
+	}
+	err := h.repo.Rukos.Update(context.Background(), r.ID, bson.M{
+		"archived":     false,
+		"archived_at":  Unset,
+		"is_available": !r.RentedOffline,
+		"updated_at":   time.Now(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed restore ruko"})
+		return
+	}
+	if !r.RentedOffline {
+		r.Archived, r.IsAvailable = false, true
+		h.announceRukoReleased(context.Background(), r)
+	}
+	h.publishRukoEvent(context.Background(), r.ID, "ruko.restored", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "ruko restored"})
+}
@@ -76,6 +76,7 @@ func RunSeeder(ctx context.Context, db *mongo.Database) {
 				CreatedAt:     time.Now(),
 				UpdatedAt:     time.Now(),
 			}
+			ruko.Location = NewGeoPoint(ruko.Latitude, ruko.Longitude)
 			rukos = append(rukos, ruko)
 		}
 		_, err := rukoCol.InsertMany(ctx, rukos)
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// contract parties that have to sign before a booking is confirmed
+var contractSignerRoles = []string{"owner", "tenant"}
+
+// fullySigned reports whether every party signed the contract
+func (ct Contract) fullySigned() bool {
+	for _, role := range contractSignerRoles {
+		if ct.signatureOf(role) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (ct Contract) signatureOf(role string) *ContractSignature {
+	for i := range ct.Signatures {
+		if ct.Signatures[i].Role == role {
+			return &ct.Signatures[i]
+		}
+	}
+	return nil
+}
+
+// SignBookingContract: POST /api/bookings/:id/contract/sign {"version", "content_hash"?}
+// the owner and the tenant each accept the latest contract version; the booking
+// becomes "confirmed" once both signed
+func (h *Handlers) SignBookingContract(c *gin.Context) {
+	b, _, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	var in struct {
+		Version     int    `json:"version" binding:"required"`
+		ContentHash string `json:"content_hash"` // hash of the document the user reviewed, optional
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if b.BookingStatus != "awaiting_signature" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "booking is not waiting for contract signatures"})
+		return
+	}
+	ctx := context.Background()
+	ct, err := h.latestContract(ctx, b.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed load contract"})
+		return
+	}
+	if ct == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "contract not generated yet"})
+		return
+	}
+	if in.Version != ct.Version {
+		c.JSON(http.StatusConflict, gin.H{"error": "contract was amended, please review the latest version", "latest_version": ct.Version})
+		return
+	}
+	if in.ContentHash != "" && in.ContentHash != ct.ContentHash {
+		c.JSON(http.StatusConflict, gin.H{"error": "content_hash does not match the contract"})
+		return
+	}
+
+	uid, _ := GetUserIDFromContext(c)
+	var role string
+	switch uid {
+	case ct.OwnerID:
+		role = "owner"
+	case ct.TenantID:
+		role = "tenant"
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the owner and the tenant can sign the contract"})
+		return
+	}
+
+	now := time.Now()
+	sig := ContractSignature{
+		UserID:      uid,
+		Role:        role,
+		ContentHash: ct.ContentHash,
+		IP:          c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		SignedAt:    now,
+	}
+	// the role filter makes signing idempotent under concurrent requests, the
+	// signatures filter keeps a signature added meanwhile by the other party
+	signed := *ct
+	signed.Signatures = append(append([]ContractSignature{}, ct.Signatures...), sig)
+	err = h.repo.Contracts.UpdateWhere(ctx,
+		bson.M{"_id": ct.ID, "signatures.role": bson.M{"$ne": role}, "signatures": ct.Signatures},
+		bson.M{"signatures": signed.Signatures})
+	if err == ErrNotFound {
+		c.JSON(http.StatusConflict, gin.H{"error": "you already signed this contract"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed sign contract"})
+		return
+	}
+
+	other := ct.TenantID
+	if role == "tenant" {
+		other = ct.OwnerID
+	}
+	data := map[string]string{"booking_id": b.ID.Hex(), "contract_id": ct.ID.Hex(), "version": strconv.Itoa(ct.Version)}
+
+	if !signed.fullySigned() {
+		h.notify(ctx, other, "contract.signed",
+			"Kontrak ditandatangani",
+			fmt.Sprintf("Kontrak sewa versi %d telah ditandatangani pihak lain, menunggu tanda tangan Anda", ct.Version),
+			data)
+		h.publishRukoEvent(ctx, b.RukoID, "contract.signed", map[string]interface{}{"booking_id": b.ID.Hex(), "role": role})
+		c.JSON(http.StatusOK, gin.H{"contract": signed, "booking_status": b.BookingStatus})
+		return
+	}
+
+	_ = h.repo.Contracts.Update(ctx, ct.ID, bson.M{"signed_at": now})
+	signed.SignedAt = &now
+	err = h.repo.Bookings.UpdateWhere(ctx,
+		bson.M{"_id": b.ID, "booking_status": "awaiting_signature"},
+		bson.M{"booking_status": "confirmed", "updated_at": now})
+	if err != nil && err != ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed confirm booking"})
+		return
+	}
+	h.notify(ctx, ct.TenantID, "booking.confirmed",
+		"Booking dikonfirmasi",
+		"Kontrak sewa telah ditandatangani kedua pihak, booking Anda dikonfirmasi",
+		data)
+	h.notify(ctx, ct.OwnerID, "booking.confirmed",
+		"Booking dikonfirmasi",
+		"Kontrak sewa telah ditandatangani kedua pihak",
+		data)
+	h.publishRukoEvent(ctx, b.RukoID, "booking.confirmed", map[string]interface{}{"booking_id": b.ID.Hex()})
+	c.JSON(http.StatusOK, gin.H{"contract": signed, "booking_status": "confirmed"})
+}
+
+// VerifyBookingContract: GET /api/bookings/:id/contract/verify?version=N
+// recomputes the hash of the stored pdf and checks it against the hash recorded at signing
+func (h *Handlers) VerifyBookingContract(c *gin.Context) {
+	b, _, ok := h.loadBookingForParty(c)
+	if !ok {
+		return
+	}
+	ct, ok := h.contractVersion(c, b.ID)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+
+	f, err := h.documents.Open(ctx, ct.FileKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "contract file not available"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed read contract"})
+		return
+	}
+	computed := sha256Hex(data)
+
+	valid := computed == ct.ContentHash
+	signatures := []gin.H{}
+	for _, s := range ct.Signatures {
+		match := s.ContentHash == computed
+		valid = valid && match
+		signatures = append(signatures, gin.H{
+			"role":       s.Role,
+			"user_id":    s.UserID,
+			"signed_at":  s.SignedAt,
+			"ip":         s.IP,
+			"user_agent": s.UserAgent,
+			"hash_match": match,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"booking_id":    b.ID,
+		"version":       ct.Version,
+		"content_hash":  ct.ContentHash,
+		"computed_hash": computed,
+		"fully_signed":  ct.fullySigned(),
+		"signed_at":     ct.SignedAt,
+		"signatures":    signatures,
+		"valid":         valid,
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is where uploaded / generated files are kept (images, documents).
+// Keys are slash separated relative paths, e.g. "ruko/<id>/<file>.jpg".
+type Storage interface {
+	Save(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// LocalStorage stores files on local disk and serves them from BaseURL
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir, baseURL string) *LocalStorage {
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// storage config from env (UPLOAD_DIR, UPLOAD_BASE_URL)
+func NewStorageFromEnv() Storage {
+	dir := os.Getenv("UPLOAD_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	base := os.Getenv("UPLOAD_BASE_URL")
+	if base == "" {
+		base = "/uploads"
+	}
+	return NewLocalStorage(dir, base)
+}
+
+// private documents (contracts) from env (DOCUMENT_DIR); never served as static files,
+// only through the authenticated endpoints
+func NewDocumentStorageFromEnv() Storage {
+	dir := os.Getenv("DOCUMENT_DIR")
+	if dir == "" {
+		dir = "documents"
+	}
+	return NewLocalStorage(dir, "")
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", errors.New("empty storage key")
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// write to temp file first so readers never see partial files
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.BaseURL + path.Clean("/"+key)
+}
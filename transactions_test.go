@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errHistoryDown = errors.New("rental history unavailable")
+
+// failingHistory fails every insert while fail is set, the last write of confirmBookingPaid
+type failingHistory struct {
+	RentalHistoryRepo
+	fail bool
+}
+
+func (f *failingHistory) Create(ctx context.Context, doc *RentalHistory) error {
+	if f.fail {
+		return errHistoryDown
+	}
+	return f.RentalHistoryRepo.Create(ctx, doc)
+}
+
+// memoryFailingHistory keeps the memory repo's snapshot, so the transaction can restore it
+type memoryFailingHistory struct {
+	*MemoryRepo[RentalHistory, *RentalHistory]
+	fail bool
+}
+
+func (f *memoryFailingHistory) Create(ctx context.Context, doc *RentalHistory) error {
+	if f.fail {
+		return errHistoryDown
+	}
+	return f.MemoryRepo.Create(ctx, doc)
+}
+
+// confirmedPayment posts a confirmed payment of the whole booking
+func confirmedPayment(s *testServer, b Booking) int {
+	return s.do(http.MethodPost, "/api/payments", s.tenant, gin.H{
+		"booking_id":     b.ID.Hex(),
+		"payment_method": "transfer",
+		"amount":         b.TotalPrice,
+		"status":         "confirmed",
+	}, nil)
+}
+
+// checkNothingSaved asserts the booking is as created and no payment or rental history was kept
+func checkNothingSaved(t *testing.T, s *testServer, b Booking) {
+	t.Helper()
+	ctx := context.Background()
+	got := s.booking(b.ID)
+	if got.PaymentStatus != "pending" || got.BookingStatus != "waiting" || got.AcceptedAt != nil {
+		t.Errorf("booking changed to %s/%s accepted_at=%v", got.PaymentStatus, got.BookingStatus, got.AcceptedAt)
+	}
+	if n, _ := s.h.repo.Payments.Count(ctx, bson.M{"booking_id": b.ID}); n != 0 {
+		t.Errorf("%d payments kept", n)
+	}
+	if n, _ := s.h.repo.RentalHistory.Count(ctx, bson.M{"booking_id": b.ID}); n != 0 {
+		t.Errorf("%d rental history entries kept", n)
+	}
+}
+
+// checkAllSaved asserts the confirmation, the payment and the rental history are all stored
+func checkAllSaved(t *testing.T, s *testServer, b Booking) {
+	t.Helper()
+	ctx := context.Background()
+	got := s.booking(b.ID)
+	if got.PaymentStatus != "paid" || got.BookingStatus != "awaiting_signature" || got.AcceptedAt == nil {
+		t.Errorf("booking = %s/%s accepted_at=%v", got.PaymentStatus, got.BookingStatus, got.AcceptedAt)
+	}
+	if n, _ := s.h.repo.Payments.Count(ctx, bson.M{"booking_id": b.ID, "status": "confirmed"}); n != 1 {
+		t.Errorf("%d confirmed payments, want 1", n)
+	}
+	if h := s.rentalHistory(b.ID); len(h) != 1 || h[0].TotalPaid != b.TotalPrice {
+		t.Errorf("rental history = %+v", h)
+	}
+}
+
+func TestConfirmPaymentRollsBackInMemory(t *testing.T) {
+	repo := NewMemoryRepositories()
+	history := &memoryFailingHistory{MemoryRepo: repo.RentalHistory.(*MemoryRepo[RentalHistory, *RentalHistory]), fail: true}
+	repo.RentalHistory = history
+	s := newTestServerWith(t, nil, repo)
+	b := s.createBooking(s.createRuko())
+
+	if code := confirmedPayment(s, b); code != http.StatusInternalServerError {
+		t.Fatalf("payment with failing rental history: status %d, want 500", code)
+	}
+	checkNothingSaved(t, s, b)
+	path := "/api/bookings/" + b.ID.Hex() + "/confirm-offline"
+	if code := s.do(http.MethodPatch, path, s.owner, gin.H{"verifier_id": s.owner.Hex()}, nil); code != http.StatusInternalServerError {
+		t.Fatalf("offline confirm with failing rental history: status %d, want 500", code)
+	}
+	checkNothingSaved(t, s, b)
+
+	history.fail = false
+	if code := confirmedPayment(s, b); code != http.StatusCreated {
+		t.Fatalf("payment: status %d", code)
+	}
+	checkAllSaved(t, s, b)
+}
+
+// TestConfirmPaymentRollsBackInMongo needs a replica set (transactions), e.g.
+// MONGO_TEST_URI="mongodb://localhost:27017/?replicaSet=rs0" go test -run Mongo
+func TestConfirmPaymentRollsBackInMongo(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database(fmt.Sprintf("ruko_test_%s", primitive.NewObjectID().Hex()))
+	defer db.Drop(context.Background())
+	// transactions cannot create collections on older servers
+	for _, name := range []string{"users", "ruko", "bookings", "payments", "rental_history", "ledger", "counters", "invoices", "receipts"} {
+		if err := db.CreateCollection(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := NewMongoRepositories(db)
+	history := &failingHistory{RentalHistoryRepo: repo.RentalHistory, fail: true}
+	repo.RentalHistory = history
+	s := newTestServerWith(t, db, repo)
+	b := s.createBooking(s.createRuko())
+
+	if code := confirmedPayment(s, b); code != http.StatusInternalServerError {
+		t.Fatalf("payment with failing rental history: status %d, want 500", code)
+	}
+	checkNothingSaved(t, s, b)
+	if n, _ := db.Collection("ledger").CountDocuments(ctx, bson.M{"booking_id": b.ID}); n != 0 {
+		t.Errorf("%d ledger transactions kept", n)
+	}
+
+	history.fail = false
+	if code := confirmedPayment(s, b); code != http.StatusCreated {
+		t.Fatalf("payment: status %d", code)
+	}
+	checkAllSaved(t, s, b)
+	if n, _ := db.Collection("ledger").CountDocuments(ctx, bson.M{"booking_id": b.ID}); n != 1 {
+		t.Errorf("%d ledger transactions, want 1", n)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultVisitDuration = time.Hour
+	maxVisitDuration     = 4 * time.Hour
+	visitReminderBefore  = 24 * time.Hour
+)
+
+// visit times in notifications are shown in Jakarta time (no daylight saving, so a fixed zone is exact)
+var visitTimeZone = time.FixedZone("WIB", 7*60*60)
+
+func formatVisitTime(t time.Time) string {
+	return t.In(visitTimeZone).Format("02 Jan 2006 15:04 MST")
+}
+
+var (
+	errVisitConflict = errors.New("another visit is already scheduled at that time")
+	errVisitChanged  = errors.New("site visit was changed")
+)
+
+// parse start (RFC3339) and optional end; end defaults to start + 1 hour
+func parseVisitPeriod(startStr, endStr string) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, startStr)
+	if err != nil {
+		return start, start, errors.New("invalid start, use RFC3339 e.g. 2026-01-02T10:00:00+07:00")
+	}
+	end := start.Add(defaultVisitDuration)
+	if endStr != "" {
+		if end, err = time.Parse(time.RFC3339, endStr); err != nil {
+			return start, end, errors.New("invalid end, use RFC3339")
+		}
+	}
+	if !end.After(start) {
+		return start, end, errors.New("end must be after start")
+	}
+	if start.Before(time.Now()) {
+		return start, end, errors.New("start must be in the future")
+	}
+	return start, end, nil
+}
+
+// ownerVisitConflict checks accepted visits of the owner (on any of their rukos) overlapping [start, end)
+func (h *Handlers) ownerVisitConflict(ctx context.Context, ownerID primitive.ObjectID, start, end time.Time, exclude primitive.ObjectID) (bool, error) {
+	n, err := h.repo.SiteVisits.Count(ctx, bson.M{
+		"_id":      bson.M{"$ne": exclude},
+		"owner_id": ownerID,
+		"status":   "accepted",
+		"start":    bson.M{"$lt": end},
+		"end":      bson.M{"$gt": start},
+	})
+	return n > 0, err
+}
+
+// checkOwnerVisitFree fails with errVisitConflict when another accepted visit of the owner
+// overlaps [start, end). Must run inside a transaction: it first bumps a counter of the owner,
+// so concurrent accepts for one owner write the same document and run one after the other.
+func (h *Handlers) checkOwnerVisitFree(sc mongo.SessionContext, v SiteVisit, start, end time.Time) error {
+	if _, err := h.repo.Counters.Next(sc, "site_visit_accept:"+v.OwnerID.Hex()); err != nil {
+		return err
+	}
+	conflict, err := h.ownerVisitConflict(sc, v.OwnerID, start, end, v.ID)
+	if err != nil {
+		return err
+	}
+	if conflict {
+		return errVisitConflict
+	}
+	return nil
+}
+
+// insideViewingWindow checks the visit fits in one of the ruko's viewing windows
+func (h *Handlers) insideViewingWindow(ctx context.Context, rukoID primitive.ObjectID, start, end time.Time) (bool, error) {
+	n, err := h.repo.ViewingWindows.Count(ctx, bson.M{
+		"ruko_id": rukoID,
+		"start":   bson.M{"$lte": start},
+		"end":     bson.M{"$gte": end},
+	})
+	return n > 0, err
+}
+
+// --- Viewing windows (owner) ---
+
+// CreateViewingWindow: POST /api/ruko/:id/viewing-windows {"start", "end"}
+func (h *Handlers) CreateViewingWindow(c *gin.Context) {
+	r, ok := h.findOwnedRuko(c)
+	if !ok {
+		return
+	}
+	var in struct {
+		Start string `json:"start" binding:"required"`
+		End   string `json:"end" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, err := parseVisitPeriod(in.Start, in.End)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	w := ViewingWindow{RukoID: r.ID, OwnerID: r.OwnerID, Start: start, End: end, CreatedAt: time.Now()}
+	if err := h.repo.ViewingWindows.Create(context.Background(), &w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create viewing window"})
+		return
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+// ListViewingWindows: GET /api/ruko/:id/viewing-windows (upcoming only)
+func (h *Handlers) ListViewingWindows(c *gin.Context) {
+	rukoOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}})
+	out, err := h.repo.ViewingWindows.Find(context.Background(), bson.M{"ruko_id": rukoOID, "end": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list viewing windows"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// DeleteViewingWindow: DELETE /api/viewing-windows/:id
+func (h *Handlers) DeleteViewingWindow(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	filter := bson.M{"_id": oid}
+	if c.GetString("role") != "admin" {
+		filter["owner_id"] = uid
+	}
+	err = h.repo.ViewingWindows.Delete(context.Background(), filter)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "viewing window not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed delete viewing window"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "viewing window deleted"})
+}
+
+// --- Site visits ---
+
+// RequestSiteVisit: POST /api/ruko/:id/site-visits {"start", "end"?, "note"}
+func (h *Handlers) RequestSiteVisit(c *gin.Context) {
+	rukoOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var in struct {
+		Start string `json:"start" binding:"required"`
+		End   string `json:"end"`
+		Note  string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, err := parseVisitPeriod(in.Start, in.End)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if end.Sub(start) > maxVisitDuration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "site visit cannot be longer than 4 hours"})
+		return
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+
+	ctx := context.Background()
+	r, err := h.repo.Rukos.Get(ctx, rukoOID)
+	if err != nil || r.Archived {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruko not found"})
+		return
+	}
+	if r.OwnerID == uid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot request a visit to your own ruko"})
+		return
+	}
+	if inside, err := h.insideViewingWindow(ctx, rukoOID, start, end); err != nil || !inside {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "requested time is outside the owner's viewing windows"})
+		return
+	}
+	if conflict, err := h.ownerVisitConflict(ctx, r.OwnerID, start, end, primitive.NilObjectID); err != nil || conflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "owner already has a visit scheduled at that time"})
+		return
+	}
+
+	now := time.Now()
+	v := SiteVisit{
+		RukoID:    rukoOID,
+		OwnerID:   r.OwnerID,
+		TenantID:  uid,
+		Start:     start,
+		End:       end,
+		Status:    "requested",
+		Note:      in.Note,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := h.repo.SiteVisits.Create(ctx, &v); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed create site visit"})
+		return
+	}
+	h.notify(ctx, r.OwnerID, "site_visit.requested", "Permintaan survei lokasi",
+		fmt.Sprintf("Permintaan survei %s pada %s", r.Name, formatVisitTime(start)),
+		map[string]string{"site_visit_id": v.ID.Hex(), "ruko_id": rukoOID.Hex()})
+	c.JSON(http.StatusCreated, v)
+}
+
+// ListSiteVisits: GET /api/site-visits?status= (as tenant or owner)
+func (h *Handlers) ListSiteVisits(c *gin.Context) {
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return
+	}
+	filter := bson.M{"$or": []bson.M{{"tenant_id": uid}, {"owner_id": uid}}}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}})
+	out, err := h.repo.SiteVisits.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed list site visits"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// helper: load visit by :id; owner=true requires the ruko owner (or admin), otherwise tenant or owner
+func (h *Handlers) loadSiteVisit(c *gin.Context, ownerOnly bool) (SiteVisit, primitive.ObjectID, bool) {
+	var v SiteVisit
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return v, primitive.NilObjectID, false
+	}
+	uid, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid user"})
+		return v, uid, false
+	}
+	if v, err = h.repo.SiteVisits.Get(context.Background(), oid); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "site visit not found"})
+		return v, uid, false
+	}
+	allowed := uid == v.OwnerID || c.GetString("role") == "admin"
+	if !ownerOnly {
+		allowed = allowed || uid == v.TenantID
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return v, uid, false
+	}
+	return v, uid, true
+}
+
+// AcceptSiteVisit: PUT /api/site-visits/:id/accept
+func (h *Handlers) AcceptSiteVisit(c *gin.Context) {
+	v, _, ok := h.loadSiteVisit(c, true)
+	if !ok {
+		return
+	}
+	if v.Status != "requested" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only requested visits can be accepted"})
+		return
+	}
+	h.updateSiteVisit(c, v, bson.M{"status": "accepted"}, "site_visit.accepted", "Survei lokasi diterima",
+		fmt.Sprintf("Survei lokasi Anda pada %s telah diterima", formatVisitTime(v.Start)))
+}
+
+// DeclineSiteVisit: PUT /api/site-visits/:id/decline {"note"}
+func (h *Handlers) DeclineSiteVisit(c *gin.Context) {
+	v, _, ok := h.loadSiteVisit(c, true)
+	if !ok {
+		return
+	}
+	if v.Status != "requested" && v.Status != "accepted" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visit can no longer be declined"})
+		return
+	}
+	var in struct {
+		Note string `json:"note"`
+	}
+	_ = c.ShouldBindJSON(&in)
+	h.updateSiteVisit(c, v, bson.M{"status": "declined", "owner_note": in.Note}, "site_visit.declined", "Survei lokasi ditolak",
+		"Maaf, permintaan survei lokasi Anda ditolak")
+}
+
+// RescheduleSiteVisit: PUT /api/site-visits/:id/reschedule {"start", "end"?, "note"}
+// the owner proposes a new time which becomes the accepted schedule
+func (h *Handlers) RescheduleSiteVisit(c *gin.Context) {
+	v, _, ok := h.loadSiteVisit(c, true)
+	if !ok {
+		return
+	}
+	if v.Status != "requested" && v.Status != "accepted" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visit can no longer be rescheduled"})
+		return
+	}
+	var in struct {
+		Start string `json:"start" binding:"required"`
+		End   string `json:"end"`
+		Note  string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, err := parseVisitPeriod(in.Start, in.End)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	set := bson.M{"status": "accepted", "start": start, "end": end, "owner_note": in.Note}
+	h.updateSiteVisit(c, v, set, "site_visit.rescheduled", "Jadwal survei diubah",
+		fmt.Sprintf("Jadwal survei lokasi Anda diubah ke %s", formatVisitTime(start)))
+}
+
+// CancelSiteVisit: PUT /api/site-visits/:id/cancel (tenant or owner)
+func (h *Handlers) CancelSiteVisit(c *gin.Context) {
+	v, uid, ok := h.loadSiteVisit(c, false)
+	if !ok {
+		return
+	}
+	if v.Status == "declined" || v.Status == "cancelled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visit is already closed"})
+		return
+	}
+	if uid == v.TenantID {
+		// tell the owner instead of the tenant
+		h.updateSiteVisitNotify(c, v, bson.M{"status": "cancelled"}, v.OwnerID, "site_visit.cancelled",
+			"Survei lokasi dibatalkan", "Penyewa membatalkan survei lokasi")
+		return
+	}
+	h.updateSiteVisit(c, v, bson.M{"status": "cancelled"}, "site_visit.cancelled", "Survei lokasi dibatalkan",
+		"Pemilik membatalkan survei lokasi")
+}
+
+// helper: update visit, notify tenant and respond with the new state
+func (h *Handlers) updateSiteVisit(c *gin.Context, v SiteVisit, set bson.M, ntype, title, body string) {
+	h.updateSiteVisitNotify(c, v, set, v.TenantID, ntype, title, body)
+}
+
+func (h *Handlers) updateSiteVisitNotify(c *gin.Context, v SiteVisit, set bson.M, notifyUser primitive.ObjectID, ntype, title, body string) {
+	set["updated_at"] = time.Now()
+	if _, changed := set["start"]; changed {
+		// new time, new reminder
+		set["reminder_sent_at"] = Unset
+	}
+	ctx := context.Background()
+	err := h.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		if set["status"] == "accepted" {
+			start, end := v.Start, v.End
+			if t, ok := set["start"].(time.Time); ok {
+				start, end = t, set["end"].(time.Time)
+			}
+			if err := h.checkOwnerVisitFree(sc, v, start, end); err != nil {
+				return err
+			}
+		}
+		// only from the status the caller checked, a concurrent change makes this fail
+		err := h.repo.SiteVisits.UpdateWhere(sc, bson.M{"_id": v.ID, "status": v.Status}, set)
+		if err == ErrNotFound {
+			return errVisitChanged
+		}
+		return err
+	})
+	switch err {
+	case nil:
+	case errVisitConflict:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errVisitChanged:
+		c.JSON(http.StatusConflict, gin.H{"error": "site visit was changed by another request, reload it"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update site visit"})
+		return
+	}
+	out, err := h.repo.SiteVisits.Get(ctx, v.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed update site visit"})
+		return
+	}
+	h.notify(ctx, notifyUser, ntype, title, body,
+		map[string]string{"site_visit_id": v.ID.Hex(), "ruko_id": v.RukoID.Hex()})
+	c.JSON(http.StatusOK, out)
+}
+
+// StartSiteVisitReminders sends a reminder to both parties once an accepted
+// visit is less than 24 hours away. Runs until ctx is cancelled.
+func StartSiteVisitReminders(ctx context.Context, h *Handlers, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			h.sendSiteVisitReminders(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (h *Handlers) sendSiteVisitReminders(ctx context.Context) {
+	now := time.Now()
+	visits, err := h.repo.SiteVisits.Find(ctx, bson.M{
+		"status":           "accepted",
+		"start":            bson.M{"$gt": now, "$lte": now.Add(visitReminderBefore)},
+		"reminder_sent_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		log.Println("site visit reminder error:", err)
+		return
+	}
+	for _, v := range visits {
+		// claim the reminder first so it is only sent once
+		err := h.repo.SiteVisits.UpdateWhere(ctx,
+			bson.M{"_id": v.ID, "reminder_sent_at": bson.M{"$exists": false}},
+			bson.M{"reminder_sent_at": now})
+		if err != nil {
+			continue
+		}
+		body := fmt.Sprintf("Pengingat: survei lokasi pada %s", formatVisitTime(v.Start))
+		data := map[string]string{"site_visit_id": v.ID.Hex(), "ruko_id": v.RukoID.Hex()}
+		h.notify(ctx, v.TenantID, "site_visit.reminder", "Pengingat survei lokasi", body, data)
+		h.notify(ctx, v.OwnerID, "site_visit.reminder", "Pengingat survei lokasi", body, data)
+	}
+}